@@ -0,0 +1,50 @@
+package auth
+
+import "context"
+
+// Виды субъектов запроса
+const (
+	PrincipalAPIKey     = "api_key"
+	PrincipalUser       = "user"
+	PrincipalClientCert = "client_cert"
+)
+
+// Principal субъект, от имени которого выполняется запрос:
+// ключ API, пользователь, вошедший по JWT, или клиентский сертификат TLS
+type Principal struct {
+	Type string
+	// Id идентификатор ключа или пользователя
+	Id     string
+	Name   string
+	Scopes []string
+	// UserId номер пользователя, для ключей API и сертификатов равен -1
+	UserId int
+	// Role роль пользователя, у ключей API роли нет
+	Role string
+}
+
+// Can проверяет право субъекта на действие scope
+func (p Principal) Can(scope string) bool {
+	return Grants(p.Scopes, scope)
+}
+
+// CanOwn проверяет право субъекта на действие scope над записями пользователя userId
+func (p Principal) CanOwn(scope string, userId int) bool {
+	if p.Can(scope) {
+		return true
+	}
+	return p.Type == PrincipalUser && p.UserId == userId && GrantsOwn(p.Scopes, scope)
+}
+
+type contextKey struct{}
+
+// WithPrincipal сохраняет субъект запроса в контексте
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// PrincipalFromContext возвращает субъект запроса, если он прошел проверку
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
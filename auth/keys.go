@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"restapi/repository"
+	"slices"
+	"sync"
+	"time"
+)
+
+// tokenPrefix отличает ключи API от других секретов в логах и конфигурации
+const tokenPrefix = "lk_"
+
+var (
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrKeyInvalid   = errors.New("invalid api key")
+	ErrKeyExpired   = errors.New("api key expired")
+	ErrKeyRevoked   = errors.New("api key revoked")
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// KeyInfo описание ключа API без секрета
+// @Description Ключ API: имя, права и срок действия. Сам ключ не хранится и не возвращается.
+type KeyInfo struct {
+	Id        string     `json:"id" example:"3f9a1c2b7d4e5a60"`
+	Name      string     `json:"name" example:"frontend"`
+	Prefix    string     `json:"prefix,omitempty" example:"lk_Zx81"`
+	Scopes    []string   `json:"scopes" example:"read:books,write:story"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKey ключ в хранилище: вместо секрета хранится его SHA-256
+type APIKey struct {
+	KeyInfo
+	Hash string `json:"hash"`
+}
+
+// Active проверяет, что ключ не отозван и не истек к моменту now
+func (k KeyInfo) Active(now time.Time) error {
+	if k.RevokedAt != nil {
+		return ErrKeyRevoked
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+// Principal представляет ключ как субъект запроса
+func (k KeyInfo) Principal() Principal {
+	return Principal{Type: PrincipalAPIKey, Id: k.Id, Name: k.Name, Scopes: k.Scopes, UserId: -1}
+}
+
+// KeyStore хранилище ключей API
+type KeyStore struct {
+	Keys []APIKey `json:"keys"`
+
+	mu     sync.RWMutex
+	repo   repository.Repository
+	byHash map[string]int
+}
+
+// NewKeyStore загружает ключи из repo
+func NewKeyStore(repo repository.Repository) (*KeyStore, error) {
+	s := &KeyStore{repo: repo}
+	if err := repo.Load(context.Background(), repository.APIKeys, s); err != nil {
+		return nil, err
+	}
+	s.reindex()
+	return s, nil
+}
+
+// reindex перестраивает поиск по хешу, вызывающий должен держать s.mu
+func (s *KeyStore) reindex() {
+	s.byHash = make(map[string]int, len(s.Keys))
+	for i, k := range s.Keys {
+		s.byHash[k.Hash] = i
+	}
+}
+
+// Save сохраняет ключи, например перед остановкой сервера
+func (s *KeyStore) Save(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.save(ctx)
+}
+
+// save сохраняет ключи, вызывающий должен держать s.mu
+func (s *KeyStore) save(ctx context.Context) error {
+	return s.repo.Save(ctx, repository.APIKeys, s)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Create выпускает новый ключ. Секрет возвращается только здесь,
+// в хранилище попадает лишь его хеш.
+func (s *KeyStore) Create(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (KeyInfo, string, error) {
+	return s.create(ctx, name, scopes, expiresAt, tokenPrefix+randomString(32))
+}
+
+func (s *KeyStore) create(ctx context.Context, name string, scopes []string, expiresAt *time.Time, token string) (KeyInfo, string, error) {
+	if name == "" {
+		return KeyInfo{}, "", errors.New("key name is required")
+	}
+	if len(scopes) == 0 {
+		return KeyInfo{}, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	// Начало ключа помогает узнать его в списке, короткие ключи не показываются вовсе
+	prefix := ""
+	if len(token) >= 24 {
+		prefix = token[:len(tokenPrefix)+4]
+	}
+	key := APIKey{
+		KeyInfo: KeyInfo{
+			Id:        hex.EncodeToString(id),
+			Name:      name,
+			Prefix:    prefix,
+			Scopes:    slices.Clone(scopes),
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
+		},
+		Hash: hashToken(token),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byHash[key.Hash]; ok {
+		return KeyInfo{}, "", errors.New("api key already exists")
+	}
+	s.Keys = append(s.Keys, key)
+	s.byHash[key.Hash] = len(s.Keys) - 1
+	if err := s.save(ctx); err != nil {
+		s.Keys = s.Keys[:len(s.Keys)-1]
+		s.reindex()
+		return KeyInfo{}, "", err
+	}
+	return key.KeyInfo, token, nil
+}
+
+// Bootstrap создает ключ администратора со всеми правами: с секретом token,
+// если такого ключа еще нет, или сгенерированный, если хранилище пусто.
+// Возвращает выпущенный ключ или пустую строку, если ничего не создано.
+func (s *KeyStore) Bootstrap(ctx context.Context, token string) (string, error) {
+	s.mu.RLock()
+	_, exists := s.byHash[hashToken(token)]
+	empty := len(s.Keys) == 0
+	s.mu.RUnlock()
+
+	if token != "" && !exists {
+		_, token, err := s.create(ctx, "bootstrap", []string{ScopeAll}, nil, token)
+		return token, err
+	}
+	if token == "" && empty {
+		_, token, err := s.Create(ctx, "bootstrap", []string{ScopeAll}, nil)
+		return token, err
+	}
+	return "", nil
+}
+
+// Authenticate находит действующий ключ по секрету
+func (s *KeyStore) Authenticate(token string) (KeyInfo, error) {
+	if token == "" {
+		return KeyInfo{}, ErrKeyInvalid
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byHash[hashToken(token)]
+	if !ok {
+		return KeyInfo{}, ErrKeyInvalid
+	}
+	key := s.Keys[i].KeyInfo
+	if err := key.Active(time.Now()); err != nil {
+		return KeyInfo{}, err
+	}
+	return key, nil
+}
+
+// List возвращает описания всех ключей, включая отозванные
+func (s *KeyStore) List() []KeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]KeyInfo, len(s.Keys))
+	for i, k := range s.Keys {
+		res[i] = k.KeyInfo
+	}
+	return res
+}
+
+// Get возвращает описание ключа id
+func (s *KeyStore) Get(id string) (KeyInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.Keys {
+		if k.Id == id {
+			return k.KeyInfo, nil
+		}
+	}
+	return KeyInfo{}, ErrKeyNotFound
+}
+
+// Revoke отзывает ключ id. Запись остается в хранилище для аудита.
+func (s *KeyStore) Revoke(ctx context.Context, id string) (KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.Keys {
+		if s.Keys[i].Id != id {
+			continue
+		}
+		if s.Keys[i].RevokedAt == nil {
+			now := time.Now().UTC()
+			s.Keys[i].RevokedAt = &now
+			if err := s.save(ctx); err != nil {
+				s.Keys[i].RevokedAt = nil
+				return KeyInfo{}, err
+			}
+		}
+		return s.Keys[i].KeyInfo, nil
+	}
+	return KeyInfo{}, ErrKeyNotFound
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "restapi/model"
+
+// OwnSuffix ограничивает право записями самого пользователя: read:story:own
+// разрешает читать только свою историю покупок
+const OwnSuffix = ":own"
+
+// RoleScopes права пользователей, вошедших по JWT, в зависимости от роли
+var RoleScopes = map[string][]string{
+	model.RoleAdmin: {ScopeAll},
+	model.RoleLibrarian: {
+		"read:*",
+		WriteBooks, DeleteBooks,
+		WriteUsers,
+		WriteStory, DeleteStory,
+	},
+	model.RoleMember: {
+		ReadBooks,
+		ReadStory + OwnSuffix,
+		ReadUsers + OwnSuffix,
+	},
+}
+
+// GrantsOwn проверяет, разрешают ли права scopes действие need
+// над собственными записями пользователя
+func GrantsOwn(scopes []string, need string) bool {
+	return Grants(scopes, need) || Grants(scopes, need+OwnSuffix)
+}
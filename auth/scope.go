@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Права доступа имеют вид <действие>:<ресурс>, например read:books.
+// Звездочка вместо действия или ресурса разрешает любое значение.
+const (
+	ScopeAll = "*"
+
+	ReadBooks   = "read:books"
+	WriteBooks  = "write:books"
+	DeleteBooks = "delete:books"
+	ReadUsers   = "read:users"
+	WriteUsers  = "write:users"
+	DeleteUsers = "delete:users"
+	ReadStory   = "read:story"
+	WriteStory  = "write:story"
+	DeleteStory = "delete:story"
+	AdminKeys   = "admin:keys"
+	// AdminUsers право назначать пользователям роли
+	AdminUsers = "admin:users"
+)
+
+var (
+	scopeActions   = []string{"read", "write", "delete", "admin"}
+	scopeResources = []string{"books", "users", "story", "keys"}
+)
+
+// ParseScopes разбирает список прав, разделенных запятыми или пробелами,
+// и проверяет каждое из них
+func ParseScopes(s string) ([]string, error) {
+	scopes := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAll {
+			continue
+		}
+		action, resource, ok := strings.Cut(scope, ":")
+		if !ok ||
+			(action != "*" && !slices.Contains(scopeActions, action)) ||
+			(resource != "*" && !slices.Contains(scopeResources, resource)) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// Grants проверяет, разрешают ли права scopes действие need
+func Grants(scopes []string, need string) bool {
+	action, resource, _ := strings.Cut(need, ":")
+	for _, s := range scopes {
+		if s == ScopeAll || s == need || s == action+":*" || s == "*:"+resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"restapi/model"
+	"slices"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "read:books", want: []string{"read:books"}},
+		{in: "write:books, read:books read:books", want: []string{"read:books", "write:books"}},
+		{in: "*", want: []string{"*"}},
+		{in: "read:*,*:story", want: []string{"*:story", "read:*"}},
+		{in: "", wantErr: true},
+		{in: " , ", wantErr: true},
+		{in: "books", wantErr: true},
+		{in: "drop:books", wantErr: true},
+		{in: "read:cars", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseScopes(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidScope) {
+				t.Errorf("ParseScopes(%q) error = %v, want ErrInvalidScope", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseScopes(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestGrants(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		need   string
+		want   bool
+	}{
+		{scopes: []string{ReadBooks}, need: ReadBooks, want: true},
+		{scopes: []string{ReadBooks}, need: WriteBooks},
+		{scopes: []string{"read:*"}, need: ReadUsers, want: true},
+		{scopes: []string{"read:*"}, need: WriteUsers},
+		{scopes: []string{"*:story"}, need: DeleteStory, want: true},
+		{scopes: []string{"*:story"}, need: DeleteBooks},
+		{scopes: []string{ScopeAll}, need: AdminKeys, want: true},
+		{scopes: nil, need: ReadBooks},
+		{scopes: []string{ReadStory + OwnSuffix}, need: ReadStory},
+	}
+	for _, tt := range tests {
+		if got := Grants(tt.scopes, tt.need); got != tt.want {
+			t.Errorf("Grants(%v, %s) = %v, want %v", tt.scopes, tt.need, got, tt.want)
+		}
+	}
+}
+
+func TestPrincipalCanOwn(t *testing.T) {
+	member := Principal{Type: PrincipalUser, UserId: 7, Scopes: RoleScopes[model.RoleMember]}
+	key := Principal{Type: PrincipalAPIKey, UserId: -1, Scopes: []string{ReadStory + OwnSuffix}}
+	tests := []struct {
+		name   string
+		p      Principal
+		scope  string
+		userId int
+		want   bool
+	}{
+		{name: "своя история", p: member, scope: ReadStory, userId: 7, want: true},
+		{name: "чужая история", p: member, scope: ReadStory, userId: 8},
+		{name: "свой профиль без права записи", p: member, scope: WriteUsers, userId: 7},
+		{name: "книги без владельца", p: member, scope: ReadBooks, userId: 8, want: true},
+		{name: "ключ API не владеет записями", p: key, scope: ReadStory, userId: -1},
+	}
+	for _, tt := range tests {
+		if got := tt.p.CanOwn(tt.scope, tt.userId); got != tt.want {
+			t.Errorf("%s: CanOwn(%s, %d) = %v, want %v", tt.name, tt.scope, tt.userId, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"restapi/model"
+	"restapi/repository"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Алгоритмы подписи токенов
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+	// MinPasswordLength минимальная длина пароля пользователя
+	MinPasswordLength = 8
+
+	tokenIssuer  = "restapi"
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid login or password")
+	ErrCredentialsNotFound = errors.New("credentials not found")
+	ErrLoginRequired       = errors.New("login is required")
+	ErrLoginTaken          = errors.New("login is already taken")
+	ErrWeakPassword        = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token revoked")
+)
+
+// RoleResolver возвращает текущую роль пользователя.
+// Ошибка означает, что пользователя больше нет.
+type RoleResolver func(ctx context.Context, userId int) (string, error)
+
+// SessionConfig настройки выпуска токенов
+type SessionConfig struct {
+	// Alg алгоритм подписи: HS256 или RS256
+	Alg string
+	// KeyFile секрет HS256 или закрытый ключ RS256 в PEM.
+	// Если файла нет, ключ генерируется и сохраняется при первом запуске.
+	KeyFile    string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// CredentialInfo учетные данные пользователя без хеша пароля
+// @Description Логин пользователя для входа по JWT
+type CredentialInfo struct {
+	UserId int    `json:"user_id" example:"1"`
+	Login  string `json:"login" example:"ivanov"`
+	// ChangedAt момент смены пароля: токены, выданные раньше, недействительны
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Credential учетные данные в хранилище
+type Credential struct {
+	CredentialInfo
+	Hash string `json:"hash"`
+}
+
+// TokenPair токены, выдаваемые при входе и обновлении
+// @Description Токен доступа и токен обновления
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	// ExpiresIn время жизни токена доступа в секундах
+	ExpiresIn int `json:"expires_in" example:"900"`
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	// Use назначение токена: access или refresh
+	Use   string `json:"token_use"`
+	Login string `json:"login,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// credentialSet и revokedSet сохраняемое состояние сессий
+type credentialSet struct {
+	Credentials []Credential `json:"credentials"`
+}
+
+type revokedSet struct {
+	// Tokens идентификаторы (jti) отозванных токенов и сроки их действия
+	Tokens map[string]time.Time `json:"tokens"`
+}
+
+// Sessions выдает и проверяет JWT пользователей: вход по логину и паролю,
+// обновление с ротацией токена обновления и отзыв токенов
+type Sessions struct {
+	cfg       SessionConfig
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+
+	mu      sync.RWMutex
+	repo    repository.Repository
+	creds   credentialSet
+	revoked revokedSet
+	roles   RoleResolver
+}
+
+// NewSessions загружает ключ подписи, учетные данные и список отозванных токенов
+func NewSessions(cfg SessionConfig, repo repository.Repository) (*Sessions, error) {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = DefaultAccessTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = DefaultRefreshTTL
+	}
+	s := &Sessions{cfg: cfg, repo: repo}
+
+	var err error
+	switch strings.ToUpper(cfg.Alg) {
+	case AlgHS256, "":
+		s.method = jwt.SigningMethodHS256
+		s.signKey, err = loadSecret(cfg.KeyFile)
+		s.verifyKey = s.signKey
+	case AlgRS256:
+		var key *rsa.PrivateKey
+		s.method = jwt.SigningMethodRS256
+		key, err = loadRSAKey(cfg.KeyFile)
+		if err == nil {
+			s.signKey, s.verifyKey = key, &key.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unknown jwt algorithm %q, use HS256 or RS256", cfg.Alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %s: %w", cfg.KeyFile, err)
+	}
+
+	if err := repo.Load(context.Background(), repository.Credentials, &s.creds); err != nil {
+		return nil, err
+	}
+	if err := repo.Load(context.Background(), repository.RevokedTokens, &s.revoked); err != nil {
+		return nil, err
+	}
+	if s.revoked.Tokens == nil {
+		s.revoked.Tokens = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// ResolveRoles задает источник ролей пользователей. Права пользователя
+// определяются его текущей ролью при каждом запросе, поэтому смена роли
+// действует сразу, без повторного входа. Без источника все пользователи
+// считаются читателями.
+func (s *Sessions) ResolveRoles(roles RoleResolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles = roles
+}
+
+// role возвращает текущую роль пользователя
+func (s *Sessions) role(ctx context.Context, userId int) (string, error) {
+	s.mu.RLock()
+	roles := s.roles
+	s.mu.RUnlock()
+	if roles == nil {
+		return model.RoleMember, nil
+	}
+	return roles(ctx, userId)
+}
+
+// loadSecret читает секрет HS256 или создает новый
+func loadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		raw := make([]byte, 32)
+		rand.Read(raw)
+		// Ключом служит тот же текст, что записан в файл: после перезапуска
+		// выданные токены остаются действительными
+		secret := []byte(hex.EncodeToString(raw))
+		return secret, writeKeyFile(path, append(secret, '\n'))
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) < 32 {
+		return nil, errors.New("HS256 secret must be at least 32 bytes")
+	}
+	return secret, nil
+}
+
+// loadRSAKey читает закрытый ключ RS256 в PEM или создает новый
+func loadRSAKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, writeKeyFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return key, nil
+}
+
+func writeKeyFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// credential ищет учетные данные, вызывающий должен держать s.mu
+func (s *Sessions) credential(match func(Credential) bool) (Credential, bool) {
+	i := slices.IndexFunc(s.creds.Credentials, match)
+	if i < 0 {
+		return Credential{}, false
+	}
+	return s.creds.Credentials[i], true
+}
+
+// SetCredentials задает логин и пароль пользователя userId. Смена пароля
+// делает недействительными все ранее выданные токены пользователя.
+func (s *Sessions) SetCredentials(ctx context.Context, userId int, login, password string) (CredentialInfo, error) {
+	if login == "" {
+		return CredentialInfo{}, ErrLoginRequired
+	}
+	if len([]rune(password)) < MinPasswordLength {
+		return CredentialInfo{}, ErrWeakPassword
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return CredentialInfo{}, err
+	}
+	cred := Credential{
+		CredentialInfo: CredentialInfo{UserId: userId, Login: login, ChangedAt: time.Now().UTC()},
+		Hash:           hash,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other, ok := s.credential(func(c Credential) bool { return c.Login == login }); ok && other.UserId != userId {
+		return CredentialInfo{}, ErrLoginTaken
+	}
+	prev := slices.Clone(s.creds.Credentials)
+	s.creds.Credentials = slices.DeleteFunc(s.creds.Credentials, func(c Credential) bool { return c.UserId == userId })
+	s.creds.Credentials = append(s.creds.Credentials, cred)
+	if err := s.repo.Save(ctx, repository.Credentials, &s.creds); err != nil {
+		s.creds.Credentials = prev
+		return CredentialInfo{}, err
+	}
+	return cred.CredentialInfo, nil
+}
+
+// RemoveCredentials удаляет учетные данные пользователя, его токены перестают приниматься
+func (s *Sessions) RemoveCredentials(ctx context.Context, userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credential(func(c Credential) bool { return c.UserId == userId }); !ok {
+		return ErrCredentialsNotFound
+	}
+	prev := slices.Clone(s.creds.Credentials)
+	s.creds.Credentials = slices.DeleteFunc(s.creds.Credentials, func(c Credential) bool { return c.UserId == userId })
+	if err := s.repo.Save(ctx, repository.Credentials, &s.creds); err != nil {
+		s.creds.Credentials = prev
+		return err
+	}
+	return nil
+}
+
+// dummyHash хеш, с которым сравнивается пароль несуществующего логина,
+// чтобы по времени ответа нельзя было узнать, какие логины существуют
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("dummy password")
+	return hash
+})
+
+// Login проверяет логин и пароль и выдает пару токенов
+func (s *Sessions) Login(ctx context.Context, login, password string) (TokenPair, error) {
+	s.mu.RLock()
+	cred, ok := s.credential(func(c Credential) bool { return c.Login == login })
+	s.mu.RUnlock()
+
+	hash := cred.Hash
+	if !ok {
+		hash = dummyHash()
+	}
+	valid, err := VerifyPassword(hash, password)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !ok || !valid {
+		return TokenPair{}, ErrInvalidCredentials
+	}
+	return s.issue(ctx, cred.CredentialInfo)
+}
+
+// Refresh выдает новую пару токенов по токену обновления.
+// Использованный токен обновления отзывается.
+func (s *Sessions) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	c, cred, err := s.parse(refreshToken, tokenRefresh)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	// Сначала выпускается новая пара: если это не удалось, старый токен
+	// остается действительным и сессия не теряется. Новая пара отдается,
+	// только если старый токен удалось отозвать.
+	pair, err := s.issue(ctx, cred.CredentialInfo)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := s.revoke(ctx, c); err != nil {
+		return TokenPair{}, err
+	}
+	return pair, nil
+}
+
+// Revoke отзывает токен доступа или обновления. Истекшие токены отзывать не нужно.
+func (s *Sessions) Revoke(ctx context.Context, token string) error {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, s.keyFunc, s.parserOptions()...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if err := s.revoke(ctx, &c); !errors.Is(err, ErrTokenRevoked) {
+		return err
+	}
+	return nil
+}
+
+// Authenticate проверяет токен доступа и возвращает пользователя с правами его роли
+func (s *Sessions) Authenticate(ctx context.Context, accessToken string) (Principal, error) {
+	c, cred, err := s.parse(accessToken, tokenAccess)
+	if err != nil {
+		return Principal{}, err
+	}
+	role, err := s.role(ctx, cred.UserId)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	return Principal{
+		Type:   PrincipalUser,
+		Id:     c.Subject,
+		Name:   cred.Login,
+		Scopes: RoleScopes[role],
+		UserId: cred.UserId,
+		Role:   role,
+	}, nil
+}
+
+func (s *Sessions) keyFunc(*jwt.Token) (any, error) {
+	return s.verifyKey, nil
+}
+
+func (s *Sessions) parserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods([]string{s.method.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+}
+
+// parse проверяет подпись, срок и назначение токена, а также то,
+// что он не отозван и пароль пользователя не менялся после его выдачи
+func (s *Sessions) parse(token, use string) (*claims, Credential, error) {
+	var c claims
+	if _, err := jwt.ParseWithClaims(token, &c, s.keyFunc, s.parserOptions()...); err != nil {
+		return nil, Credential{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if c.Use != use {
+		return nil, Credential{}, fmt.Errorf("%w: %s token expected", ErrInvalidToken, use)
+	}
+	userId, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return nil, Credential{}, fmt.Errorf("%w: bad subject", ErrInvalidToken)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, revoked := s.revoked.Tokens[c.ID]; revoked {
+		return nil, Credential{}, ErrTokenRevoked
+	}
+	cred, ok := s.credential(func(c Credential) bool { return c.UserId == userId })
+	if !ok || c.IssuedAt == nil || c.IssuedAt.Before(cred.ChangedAt.Truncate(time.Second)) {
+		return nil, Credential{}, ErrTokenRevoked
+	}
+	return &c, cred, nil
+}
+
+// Save сохраняет учетные данные и отозванные токены, например перед
+// остановкой сервера
+func (s *Sessions) Save(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return errors.Join(
+		s.repo.Save(ctx, repository.Credentials, &s.creds),
+		s.repo.Save(ctx, repository.RevokedTokens, &s.revoked))
+}
+
+// revoke заносит токен в список отозванных до истечения его срока.
+// Повторный отзыв возвращает ErrTokenRevoked: так один токен обновления
+// нельзя обменять дважды параллельными запросами.
+func (s *Sessions) revoke(ctx context.Context, c *claims) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.revoked.Tokens[c.ID]; ok {
+		return ErrTokenRevoked
+	}
+	now := time.Now()
+	for id, exp := range s.revoked.Tokens {
+		if exp.Before(now) {
+			delete(s.revoked.Tokens, id)
+		}
+	}
+	s.revoked.Tokens[c.ID] = c.ExpiresAt.Time
+	if err := s.repo.Save(ctx, repository.RevokedTokens, &s.revoked); err != nil {
+		// Отзыв не сохранился, токен остается действительным
+		delete(s.revoked.Tokens, c.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *Sessions) issue(ctx context.Context, cred CredentialInfo) (TokenPair, error) {
+	role, err := s.role(ctx, cred.UserId)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	now := time.Now()
+	newClaims := func(use string, ttl time.Duration) claims {
+		return claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    tokenIssuer,
+				Subject:   strconv.Itoa(cred.UserId),
+				ID:        randomString(16),
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			},
+			Use:   use,
+			Login: cred.Login,
+		}
+	}
+
+	// Роль в токене справочная: права проверяются по текущей роли
+	access := newClaims(tokenAccess, s.cfg.AccessTTL)
+	access.Role = role
+	accessToken, err := jwt.NewWithClaims(s.method, access).SignedString(s.signKey)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := jwt.NewWithClaims(s.method, newClaims(tokenRefresh, s.cfg.RefreshTTL)).SignedString(s.signKey)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.cfg.AccessTTL.Seconds()),
+	}, nil
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"restapi/model"
+	"restapi/repository"
+	"slices"
+	"testing"
+)
+
+var errSave = errors.New("save failed")
+
+// failingRepo хранит коллекции в памяти; сохранение коллекции fail
+// возвращает ошибку
+type failingRepo struct {
+	repository.Repository
+	fail string
+}
+
+func (r *failingRepo) Save(ctx context.Context, collection string, v any) error {
+	if collection == r.fail {
+		return errSave
+	}
+	return r.Repository.Save(ctx, collection, v)
+}
+
+func newSessions(t *testing.T, alg string, repo repository.Repository) *Sessions {
+	t.Helper()
+	s, err := NewSessions(SessionConfig{Alg: alg, KeyFile: filepath.Join(t.TempDir(), "jwt.key")}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// login задает пользователю 7 пароль и входит. Хеширование пароля
+// намеренно медленное, поэтому тесты входят по одному разу.
+func login(t *testing.T, s *Sessions) TokenPair {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.SetCredentials(ctx, 7, "reader", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := s.Login(ctx, "reader", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestSessionsIssue(t *testing.T) {
+	for _, alg := range []string{AlgHS256, AlgRS256} {
+		t.Run(alg, func(t *testing.T) {
+			ctx := context.Background()
+			s := newSessions(t, alg, repository.NewMemory())
+			s.ResolveRoles(func(context.Context, int) (string, error) { return model.RoleLibrarian, nil })
+			pair := login(t, s)
+
+			p, err := s.Authenticate(ctx, pair.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != PrincipalUser || p.UserId != 7 || p.Name != "reader" || p.Role != model.RoleLibrarian {
+				t.Errorf("principal = %+v", p)
+			}
+			if !slices.Equal(p.Scopes, RoleScopes[model.RoleLibrarian]) {
+				t.Errorf("scopes = %v, want librarian scopes", p.Scopes)
+			}
+			if _, err := s.Authenticate(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("refresh token accepted as access token: %v", err)
+			}
+
+			// Токен, подписанный другим ключом, не принимается
+			other := newSessions(t, alg, repository.NewMemory())
+			if _, err := other.Authenticate(ctx, pair.AccessToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("token of another key: %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestSessionsLoginRejectsWrongPassword(t *testing.T) {
+	s := newSessions(t, AlgHS256, repository.NewMemory())
+	login(t, s)
+	for _, tt := range []struct{ login, password string }{
+		{"reader", "wrong password"},
+		{"nobody", "correct horse"},
+	} {
+		if _, err := s.Login(context.Background(), tt.login, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q, %q) = %v, want ErrInvalidCredentials", tt.login, tt.password, err)
+		}
+	}
+}
+
+func TestSessionsRefresh(t *testing.T) {
+	ctx := context.Background()
+	s := newSessions(t, AlgHS256, repository.NewMemory())
+	pair := login(t, s)
+
+	next, err := s.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, next.AccessToken); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+	if _, err := s.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("second refresh with the same token: %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.Refresh(ctx, next.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("refresh with an access token: %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.Refresh(ctx, next.RefreshToken); err != nil {
+		t.Errorf("refresh with the rotated token: %v", err)
+	}
+}
+
+// Если новую пару выпустить не удалось, токен обновления остается
+// действительным
+func TestSessionsRefreshKeepsTokenWhenIssueFails(t *testing.T) {
+	ctx := context.Background()
+	s := newSessions(t, AlgHS256, repository.NewMemory())
+	pair := login(t, s)
+
+	s.ResolveRoles(func(context.Context, int) (string, error) { return "", model.ErrUserNotFound })
+	if _, err := s.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("refresh for a missing user: %v, want ErrInvalidCredentials", err)
+	}
+	s.ResolveRoles(nil)
+	if _, err := s.Refresh(ctx, pair.RefreshToken); err != nil {
+		t.Errorf("refresh after a failed issue: %v", err)
+	}
+}
+
+func TestSessionsRevoke(t *testing.T) {
+	ctx := context.Background()
+	repo := &failingRepo{Repository: repository.NewMemory()}
+	s := newSessions(t, AlgHS256, repo)
+	pair := login(t, s)
+
+	// Несохраненный отзыв не действует
+	repo.fail = repository.RevokedTokens
+	if err := s.Revoke(ctx, pair.AccessToken); !errors.Is(err, errSave) {
+		t.Fatalf("Revoke = %v, want %v", err, errSave)
+	}
+	if _, err := s.Authenticate(ctx, pair.AccessToken); err != nil {
+		t.Errorf("token rejected after a failed revoke: %v", err)
+	}
+	if _, err := s.Refresh(ctx, pair.RefreshToken); !errors.Is(err, errSave) {
+		t.Errorf("Refresh = %v, want %v", err, errSave)
+	}
+
+	repo.fail = ""
+	if err := s.Revoke(ctx, pair.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(ctx, pair.AccessToken); err != nil {
+		t.Errorf("second revoke: %v, want nil", err)
+	}
+	if _, err := s.Authenticate(ctx, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revoked token: %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.Refresh(ctx, pair.RefreshToken); err != nil {
+		t.Errorf("refresh token must stay valid after revoking the access token: %v", err)
+	}
+
+	// Отзыв переживает перезапуск
+	restarted, err := NewSessions(s.cfg, repo.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Authenticate(ctx, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revoked token after restart: %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestSessionsRemoveCredentials(t *testing.T) {
+	ctx := context.Background()
+	s := newSessions(t, AlgHS256, repository.NewMemory())
+	pair := login(t, s)
+	if err := s.RemoveCredentials(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token of a user without credentials: %v, want ErrTokenRevoked", err)
+	}
+	if err := s.RemoveCredentials(ctx, 7); !errors.Is(err, ErrCredentialsNotFound) {
+		t.Errorf("second remove: %v, want ErrCredentialsNotFound", err)
+	}
+}
@@ -0,0 +1,441 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"restapi/auth"
+	"restapi/middleware"
+	"restapi/model"
+	"restapi/ratelimit"
+	"restapi/tracing"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config настройки приложения. Значения берутся по возрастанию приоритета
+// из Default, файла конфигурации, переменных окружения и флагов командной
+// строки, см. Load.
+//
+// Тег yaml задает ключ в файле, из него же строится имя переменной окружения:
+// server.port -> LIBRARY_SERVER_PORT. Тег flag задает имя флага, usage его описание.
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Storage     Storage     `yaml:"storage"`
+	Auth        Auth        `yaml:"auth"`
+	RateLimit   RateLimit   `yaml:"rate_limit"`
+	Log         Log         `yaml:"log"`
+	TLS         TLS         `yaml:"tls"`
+	Swagger     Swagger     `yaml:"swagger"`
+	Metrics     Metrics     `yaml:"metrics"`
+	Tracing     Tracing     `yaml:"tracing"`
+	CORS        CORS        `yaml:"cors"`
+	Compression Compression `yaml:"compression"`
+}
+
+// Server адрес и таймауты HTTP сервера
+type Server struct {
+	Bind              string        `yaml:"bind" flag:"bind" usage:"адрес, на котором слушает сервер; пустой означает все интерфейсы"`
+	Port              int           `yaml:"port" flag:"port" usage:"порт HTTP сервера"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" flag:"read-timeout" usage:"максимальное время чтения запроса вместе с телом"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" flag:"write-timeout" usage:"максимальное время записи ответа"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" flag:"idle-timeout" usage:"сколько держать открытым простаивающее keep-alive соединение"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" flag:"read-header-timeout" usage:"максимальное время чтения заголовков запроса"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" flag:"max-header-bytes" usage:"максимальный размер заголовков запроса в байтах"`
+	HTTP2             bool          `yaml:"http2" flag:"http2" usage:"разрешить HTTP/2"`
+	H2C               bool          `yaml:"h2c" flag:"h2c" usage:"HTTP/2 без TLS (h2c) для прокси и клиентов внутри кластера"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" flag:"shutdown-timeout" usage:"сколько ждать завершения начатых запросов при остановке"`
+	DrainDelay        time.Duration `yaml:"drain_delay" flag:"drain-delay" usage:"сколько после сигнала остановки принимать запросы, отвечая 503 на /readyz, чтобы балансировщик успел исключить сервер"`
+}
+
+// Addr адрес для net.Listen
+func (s Server) Addr() string {
+	return net.JoinHostPort(s.Bind, strconv.Itoa(s.Port))
+}
+
+// Storage хранилище данных
+type Storage struct {
+	Backend      string `yaml:"backend" flag:"backend" usage:"хранилище данных: json или sqlite"`
+	Dir          string `yaml:"dir" flag:"storage-dir" usage:"каталог файлов хранилища"`
+	DB           string `yaml:"db" flag:"db" usage:"путь к базе SQLite; по умолчанию <storage-dir>/library.db"`
+	DeletePolicy string `yaml:"delete_policy" flag:"delete-policy" usage:"удаление книг и пользователей с покупками: restrict, cascade или orphan"`
+	// ReadOnlyOnError запускает сервер только на чтение, если книги, пользователей
+	// или покупки не удалось загрузить. Иначе сервер не запускается.
+	ReadOnlyOnError bool `yaml:"read_only_on_error" flag:"read-only-on-error" usage:"если данные не загрузились, запускаться только на чтение вместо остановки"`
+}
+
+// Auth ключи API и вход пользователей по JWT
+type Auth struct {
+	BootstrapKey string        `yaml:"bootstrap_key" flag:"bootstrap-key" usage:"ключ администратора, который создается, если его нет в хранилище; по умолчанию генерируется при первом запуске"`
+	JWTAlg       string        `yaml:"jwt_alg" flag:"jwt-alg" usage:"алгоритм подписи JWT: HS256 или RS256"`
+	JWTKey       string        `yaml:"jwt_key" flag:"jwt-key" usage:"секрет HS256 или закрытый ключ RS256 в PEM, создается при первом запуске; по умолчанию <storage-dir>/jwt.key"`
+	AccessTTL    time.Duration `yaml:"access_ttl" flag:"access-ttl" usage:"время жизни токена доступа"`
+	RefreshTTL   time.Duration `yaml:"refresh_ttl" flag:"refresh-ttl" usage:"время жизни токена обновления"`
+}
+
+// RateLimit ограничение частоты запросов
+type RateLimit struct {
+	Default    string `yaml:"default" flag:"rate-limit" usage:"ограничение частоты запросов одного клиента, например 600/m или 20/s; off отключает"`
+	Routes     string `yaml:"routes" flag:"route-limits" usage:"ограничения отдельных маршрутов через точку с запятой: [METHOD ]<шаблон пути>=<ограничение>"`
+	DailyQuota int    `yaml:"daily_quota" flag:"daily-quota" usage:"суточная квота запросов одного клиента, 0 отключает"`
+}
+
+// Log журнал сервера
+type Log struct {
+	Level  string `yaml:"level" flag:"log-level" usage:"уровень журнала: debug, info, warn или error"`
+	Format string `yaml:"format" flag:"log-format" usage:"формат журнала: json или text"`
+}
+
+// TLS сертификат для HTTPS. Без сертификата сервер работает по HTTP.
+type TLS struct {
+	Cert string `yaml:"cert" flag:"tls-cert" usage:"сертификат сервера в PEM"`
+	Key  string `yaml:"key" flag:"tls-key" usage:"закрытый ключ сертификата в PEM"`
+	Dev  bool   `yaml:"dev" flag:"tls-dev" usage:"HTTPS с самоподписанным сертификатом, который создается в <storage-dir> при первом запуске; только для разработки"`
+
+	ClientCA     string `yaml:"client_ca" flag:"tls-client-ca" usage:"сертификаты УЦ в PEM для проверки клиентских сертификатов (mTLS)"`
+	ClientAuth   string `yaml:"client_auth" flag:"tls-client-auth" usage:"клиентские сертификаты: optional — вместо ключа API, require — обязательны для всех запросов"`
+	ClientScopes string `yaml:"client_scopes" flag:"tls-client-scopes" usage:"права клиента с проверенным сертификатом"`
+
+	RedirectPort int `yaml:"redirect_port" flag:"tls-redirect-port" usage:"порт HTTP, с которого запросы перенаправляются на HTTPS; 0 отключает"`
+}
+
+// Режимы проверки клиентских сертификатов
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Enabled проверяет, что сервер работает по HTTPS
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || t.Dev
+}
+
+// Swagger документация API
+type Swagger struct {
+	Enabled bool   `yaml:"enabled" flag:"swagger" usage:"отдавать Swagger UI и спецификацию"`
+	Spec    string `yaml:"spec" flag:"swagger-spec" usage:"путь к swagger.json"`
+}
+
+// Metrics метрики Prometheus
+type Metrics struct {
+	Enabled bool   `yaml:"enabled" flag:"metrics" usage:"отдавать метрики Prometheus"`
+	Path    string `yaml:"path" flag:"metrics-path" usage:"путь метрик Prometheus"`
+}
+
+// Tracing трассировка OpenTelemetry
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" flag:"trace-exporter" usage:"куда отправлять спаны: none, otlp, stdout или file"`
+	Endpoint    string  `yaml:"endpoint" flag:"trace-endpoint" usage:"адрес приемника OTLP/HTTP, по умолчанию из OTEL_EXPORTER_OTLP_ENDPOINT"`
+	File        string  `yaml:"file" flag:"trace-file" usage:"файл спанов для экспортера file, по умолчанию <storage-dir>/traces.jsonl"`
+	SampleRatio float64 `yaml:"sample_ratio" flag:"trace-sample-ratio" usage:"доля записываемых трасс от 0 до 1"`
+	ServiceName string  `yaml:"service_name" flag:"trace-service-name" usage:"имя сервиса в спанах"`
+}
+
+// CORS доступ к API из браузера со страниц других источников
+type CORS struct {
+	Origins       string        `yaml:"origins" flag:"cors-origins" usage:"источники через запятую, которым разрешен доступ из браузера: https://app.example.com, https://*.example.com или *; пустой список выключает CORS"`
+	Methods       string        `yaml:"methods" flag:"cors-methods" usage:"разрешенные методы через запятую"`
+	Headers       string        `yaml:"headers" flag:"cors-headers" usage:"заголовки запроса через запятую, которые может передавать браузер"`
+	ExposeHeaders string        `yaml:"expose_headers" flag:"cors-expose-headers" usage:"заголовки ответа через запятую, доступные скрипту страницы"`
+	Credentials   bool          `yaml:"credentials" flag:"cors-credentials" usage:"разрешить запросы с cookie и клиентскими сертификатами браузера"`
+	MaxAge        time.Duration `yaml:"max_age" flag:"cors-max-age" usage:"сколько браузер кеширует ответ на предварительный запрос OPTIONS"`
+}
+
+// Enabled сообщает, разрешен ли доступ хотя бы одному источнику
+func (c CORS) Enabled() bool {
+	return len(middleware.SplitList(c.Origins)) > 0
+}
+
+// Options настройки middleware.CORS
+func (c CORS) Options() middleware.CORSOptions {
+	return middleware.CORSOptions{
+		Origins:       middleware.SplitList(c.Origins),
+		Methods:       middleware.SplitList(c.Methods),
+		Headers:       middleware.SplitList(c.Headers),
+		ExposeHeaders: middleware.SplitList(c.ExposeHeaders),
+		Credentials:   c.Credentials,
+		MaxAge:        c.MaxAge,
+	}
+}
+
+// Compression сжатие ответов
+type Compression struct {
+	Enabled   bool   `yaml:"enabled" flag:"compress" usage:"сжимать ответы кодировкой из заголовка Accept-Encoding"`
+	Encodings string `yaml:"encodings" flag:"compress-encodings" usage:"кодировки через запятую в порядке предпочтения: zstd, br, gzip"`
+	MinSize   int    `yaml:"min_size" flag:"compress-min-size" usage:"ответы меньше этого размера в байтах не сжимаются"`
+}
+
+// Options настройки middleware.Compress
+func (c Compression) Options() middleware.CompressOptions {
+	return middleware.CompressOptions{
+		Encodings: middleware.SplitList(c.Encodings),
+		MinSize:   c.MinSize,
+	}
+}
+
+// LogLevels допустимые уровни журнала
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// LogFormats допустимые форматы журнала
+var LogFormats = []string{"json", "text"}
+
+// Default возвращает настройки по умолчанию
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:         8080,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 60 * time.Second,
+			IdleTimeout:  120 * time.Second,
+
+			ReadHeaderTimeout: 10 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+			HTTP2:             true,
+		},
+		Storage: Storage{
+			Backend:      "json",
+			Dir:          "./storage",
+			DeletePolicy: string(model.DeleteRestrict),
+		},
+		Auth: Auth{
+			JWTAlg:     auth.AlgHS256,
+			AccessTTL:  auth.DefaultAccessTTL,
+			RefreshTTL: auth.DefaultRefreshTTL,
+		},
+		RateLimit: RateLimit{
+			Default: "600/m",
+			Routes:  "POST /api/v2/auth/{action:login|refresh|revoke}=10/m",
+		},
+		Log: Log{Level: "info", Format: "json"},
+		TLS: TLS{
+			ClientAuth:   ClientAuthOptional,
+			ClientScopes: "read:*",
+		},
+		Swagger: Swagger{Enabled: true, Spec: "./docs/swagger.json"},
+		Metrics: Metrics{Enabled: true, Path: "/metrics"},
+		Tracing: Tracing{Exporter: tracing.ExporterNone, SampleRatio: 1, ServiceName: "library-api"},
+		CORS: CORS{
+			Methods:       "GET, HEAD, POST, PUT, DELETE",
+			Headers:       "Accept, Content-Type, Authorization, X-API-Key, X-Request-ID, traceparent, If-Match, If-None-Match, If-Modified-Since",
+			ExposeHeaders: "X-Request-ID, X-Total-Count, Link, ETag, Last-Modified, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After",
+			MaxAge:        10 * time.Minute,
+		},
+		Compression: Compression{Enabled: true, Encodings: "zstd, br, gzip", MinSize: 1024},
+	}
+}
+
+// Validate проверяет значения настроек: она ничего в них не меняет, не
+// создает каталогов и не обращается к сети. Возвращает все найденные
+// ошибки сразу, каждая начинается с ключа настройки.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.Bind != "" && net.ParseIP(c.Server.Bind) == nil && !validHostname(c.Server.Bind) {
+		fail("server.bind", "must be an IP address or a host name, got %q", c.Server.Bind)
+	}
+	for _, t := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+	} {
+		if t.d < 0 {
+			fail(t.key, "must not be negative, got %s", t.d)
+		}
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		fail("server.max_header_bytes", "must not be negative, got %d", c.Server.MaxHeaderBytes)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdown_timeout", "must be positive, got %s", c.Server.ShutdownTimeout)
+	}
+
+	switch c.Storage.Backend {
+	case "json", "sqlite":
+	default:
+		fail("storage.backend", "must be json or sqlite, got %q", c.Storage.Backend)
+	}
+	if c.Storage.Dir == "" {
+		fail("storage.dir", "is required")
+	}
+	if _, err := model.ParseDeletePolicy(c.Storage.DeletePolicy); err != nil {
+		fail("storage.delete_policy", "%s", err)
+	}
+
+	switch c.Auth.JWTAlg {
+	case auth.AlgHS256, auth.AlgRS256:
+	default:
+		fail("auth.jwt_alg", "must be %s or %s, got %q", auth.AlgHS256, auth.AlgRS256, c.Auth.JWTAlg)
+	}
+	if c.Auth.AccessTTL <= 0 {
+		fail("auth.access_ttl", "must be positive, got %s", c.Auth.AccessTTL)
+	}
+	if c.Auth.RefreshTTL < c.Auth.AccessTTL {
+		fail("auth.refresh_ttl", "must not be shorter than auth.access_ttl (%s), got %s", c.Auth.AccessTTL, c.Auth.RefreshTTL)
+	}
+
+	if _, err := ratelimit.ParseRule(c.RateLimit.Default); err != nil {
+		fail("rate_limit.default", "%s", err)
+	}
+	if _, err := ratelimit.ParseRoutes(c.RateLimit.Routes); err != nil {
+		fail("rate_limit.routes", "%s", err)
+	}
+	if c.RateLimit.DailyQuota < 0 {
+		fail("rate_limit.daily_quota", "must not be negative, got %d", c.RateLimit.DailyQuota)
+	}
+
+	if !slices.Contains(LogLevels, c.Log.Level) {
+		fail("log.level", "must be one of %v, got %q", LogLevels, c.Log.Level)
+	}
+	if !slices.Contains(LogFormats, c.Log.Format) {
+		fail("log.format", "must be one of %v, got %q", LogFormats, c.Log.Format)
+	}
+
+	switch {
+	case c.TLS.Dev && (c.TLS.Cert != "" || c.TLS.Key != ""):
+		fail("tls.dev", "cannot be combined with tls.cert and tls.key")
+	case c.TLS.Dev, c.TLS.Cert == "" && c.TLS.Key == "":
+	case c.TLS.Cert == "" || c.TLS.Key == "":
+		fail("tls", "cert and key must be set together")
+	default:
+		if _, err := os.Stat(c.TLS.Cert); err != nil {
+			fail("tls.cert", "%s", err)
+		}
+		if _, err := os.Stat(c.TLS.Key); err != nil {
+			fail("tls.key", "%s", err)
+		}
+	}
+	if c.TLS.ClientCA != "" {
+		if !c.TLS.Enabled() {
+			fail("tls.client_ca", "requires HTTPS: set tls.cert and tls.key or tls.dev")
+		}
+		if _, err := os.Stat(c.TLS.ClientCA); err != nil {
+			fail("tls.client_ca", "%s", err)
+		}
+	}
+	switch c.TLS.ClientAuth {
+	case ClientAuthOptional:
+	case ClientAuthRequire:
+		if c.TLS.ClientCA == "" {
+			fail("tls.client_auth", "require needs tls.client_ca")
+		}
+	default:
+		fail("tls.client_auth", "must be optional or require, got %q", c.TLS.ClientAuth)
+	}
+	if _, err := auth.ParseScopes(c.TLS.ClientScopes); err != nil {
+		fail("tls.client_scopes", "%s", err)
+	}
+	if c.TLS.RedirectPort != 0 {
+		switch {
+		case !c.TLS.Enabled():
+			fail("tls.redirect_port", "requires HTTPS: set tls.cert and tls.key or tls.dev")
+		case c.TLS.RedirectPort < 0 || c.TLS.RedirectPort > 65535:
+			fail("tls.redirect_port", "must be between 1 and 65535, got %d", c.TLS.RedirectPort)
+		case c.TLS.RedirectPort == c.Server.Port:
+			fail("tls.redirect_port", "must differ from server.port")
+		}
+	}
+	switch {
+	case c.Server.H2C && !c.Server.HTTP2:
+		fail("server.h2c", "requires server.http2")
+	case c.Server.H2C && c.TLS.Enabled():
+		fail("server.h2c", "is for plain HTTP only, HTTPS negotiates HTTP/2 itself")
+	}
+
+	if c.Swagger.Enabled {
+		if _, err := os.Stat(c.Swagger.Spec); err != nil {
+			fail("swagger.spec", "%s", err)
+		}
+	}
+
+	if c.Metrics.Enabled && (!strings.HasPrefix(c.Metrics.Path, "/") || strings.HasPrefix(c.Metrics.Path, "/api/")) {
+		fail("metrics.path", "must start with / and stay outside /api/, got %q", c.Metrics.Path)
+	}
+
+	if !slices.Contains(tracing.Exporters, c.Tracing.Exporter) {
+		fail("tracing.exporter", "must be one of %v, got %q", tracing.Exporters, c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	for _, origin := range middleware.SplitList(c.CORS.Origins) {
+		if err := middleware.CheckOrigin(origin); err != nil {
+			fail("cors.origins", "%s", err)
+		}
+		if origin == middleware.AnyOrigin && c.CORS.Credentials {
+			fail("cors.credentials", "cannot be combined with origin *, list the origins explicitly")
+		}
+	}
+	if c.CORS.Enabled() && len(middleware.SplitList(c.CORS.Methods)) == 0 {
+		fail("cors.methods", "is required when cors.origins is set")
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("cors.max_age", "must not be negative, got %s", c.CORS.MaxAge)
+	}
+
+	if err := middleware.CheckEncodings(middleware.SplitList(c.Compression.Encodings)); err != nil {
+		fail("compression.encodings", "%s", err)
+	}
+	if c.Compression.Enabled && len(middleware.SplitList(c.Compression.Encodings)) == 0 {
+		fail("compression.encodings", "is required when compression is enabled")
+	}
+	if c.Compression.MinSize < 0 {
+		fail("compression.min_size", "must not be negative, got %d", c.Compression.MinSize)
+	}
+
+	return errors.Join(errs...)
+}
+
+// fillPaths задает пути, которые по умолчанию лежат в storage.dir. Вызывается
+// после Validate: tls.dev несовместим с явно заданными tls.cert и tls.key.
+func (c *Config) fillPaths() {
+	if c.Storage.DB == "" {
+		c.Storage.DB = filepath.Join(c.Storage.Dir, "library.db")
+	}
+	if c.Auth.JWTKey == "" {
+		c.Auth.JWTKey = filepath.Join(c.Storage.Dir, "jwt.key")
+	}
+	if c.TLS.Dev {
+		// Сертификат создается при запуске сервера
+		c.TLS.Cert = filepath.Join(c.Storage.Dir, "dev-cert.pem")
+		c.TLS.Key = filepath.Join(c.Storage.Dir, "dev-key.pem")
+	}
+	if c.Tracing.Exporter == tracing.ExporterFile && c.Tracing.File == "" {
+		c.Tracing.File = filepath.Join(c.Storage.Dir, "traces.jsonl")
+	}
+}
+
+// validHostname проверяет запись имени хоста: метки из латинских букв, цифр
+// и дефиса через точку. Существует ли такой хост, выясняется при запуске.
+func validHostname(s string) bool {
+	if len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
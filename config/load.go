@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// EnvPrefix префикс переменных окружения с настройками
+const EnvPrefix = "LIBRARY_"
+
+// EnvConfig переменная окружения с путем к файлу конфигурации
+const EnvConfig = EnvPrefix + "CONFIG"
+
+// setting одна настройка: ключ в файле, переменная окружения, флаг и поле Config
+type setting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+// settings перечисляет все настройки c
+func settings(c *Config) []setting {
+	var list []setting
+	root := reflect.ValueOf(c).Elem()
+	for i := range root.NumField() {
+		section := root.Type().Field(i)
+		group := root.Field(i)
+		for j := range group.NumField() {
+			field := group.Type().Field(j)
+			key := section.Tag.Get("yaml") + "." + field.Tag.Get("yaml")
+			list = append(list, setting{
+				key:   key,
+				env:   EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_")),
+				flag:  field.Tag.Get("flag"),
+				usage: field.Tag.Get("usage"),
+				value: group.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+// set разбирает строку v в значение настройки
+func (s setting) set(v string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(v)
+	case bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		s.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", v)
+		}
+		s.value.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", v)
+		}
+		s.value.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 30s or 15m, got %q", v)
+		}
+		s.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	return nil
+}
+
+// flagValue запоминает значение флага, чтобы применить его после файла и окружения
+type flagValue struct {
+	def   string
+	isSet bool
+	value string
+	bool  bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(v string) error {
+	f.value, f.isSet = v, true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.bool
+}
+
+// Load собирает настройки: значения по умолчанию, затем файл из флага -config
+// или переменной LIBRARY_CONFIG, затем переменные окружения LIBRARY_*, затем
+// флаги командной строки. Флаги регистрируются в fs, поэтому вызывающий код
+// может добавить в fs собственные флаги до вызова Load. Результат проверяется
+// Validate, после чего пути по умолчанию заполняются от storage.dir.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+	list := settings(c)
+
+	path := fs.String("config", os.Getenv(EnvConfig), "файл конфигурации YAML")
+	flags := make([]*flagValue, len(list))
+	for i, s := range list {
+		if s.flag == "" {
+			continue
+		}
+		_, isBool := s.value.Interface().(bool)
+		flags[i] = &flagValue{bool: isBool}
+		if !s.value.IsZero() {
+			flags[i].def = fmt.Sprint(s.value.Interface())
+		}
+		fs.Var(flags[i], s.flag, s.usage+" ("+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range list {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	for i, s := range list {
+		if f := flags[i]; f != nil && f.isSet {
+			if err := s.set(f.value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c.fillPaths()
+	return c, nil
+}
+
+// loadFile читает настройки из YAML файла. Неизвестные ключи считаются ошибкой,
+// чтобы опечатка не оставляла настройку со значением по умолчанию.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"restapi/tracing"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load вызывает Load с флагами args. Swagger выключен, а хранилище лежит во
+// временном каталоге, чтобы настройки по умолчанию проходили проверку.
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	t.Setenv("LIBRARY_SWAGGER_ENABLED", "false")
+	t.Setenv("LIBRARY_STORAGE_DIR", t.TempDir())
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+// writeConfig пишет файл конфигурации и возвращает путь к нему
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, "server:\n  port: 9001\n  read_timeout: 5s\nlog:\n  level: debug\n")
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want int
+	}{
+		{name: "по умолчанию", want: 8080},
+		{name: "файл", args: []string{"-config", file}, want: 9001},
+		{name: "файл из переменной окружения", env: map[string]string{EnvConfig: file}, want: 9001},
+		{name: "окружение сильнее файла", env: map[string]string{"LIBRARY_SERVER_PORT": "9002"}, args: []string{"-config", file}, want: 9002},
+		{name: "флаг сильнее окружения", env: map[string]string{"LIBRARY_SERVER_PORT": "9002"}, args: []string{"-config", file, "-port", "9003"}, want: 9003},
+		{name: "флаг без файла", args: []string{"-port", "9004"}, want: 9004},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, err := load(t, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.Port != tt.want {
+				t.Errorf("server.port = %d, want %d", c.Server.Port, tt.want)
+			}
+		})
+	}
+}
+
+// Слой задает только те настройки, которые в нем есть: остальные берутся
+// из слоев ниже
+func TestLoadLayersMerge(t *testing.T) {
+	file := writeConfig(t, "server:\n  port: 9001\n  read_timeout: 5s\nlog:\n  level: debug\n")
+	t.Setenv("LIBRARY_LOG_FORMAT", "text")
+	c, err := load(t, "-config", file, "-port", "9003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != 9003 || c.Server.ReadTimeout != 5*time.Second || c.Log.Level != "debug" ||
+		c.Log.Format != "text" || c.Server.WriteTimeout != Default().Server.WriteTimeout {
+		t.Errorf("merged config: port %d, read timeout %s, log %s/%s, write timeout %s",
+			c.Server.Port, c.Server.ReadTimeout, c.Log.Level, c.Log.Format, c.Server.WriteTimeout)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		// want фрагменты, которые должны быть в тексте ошибки
+		want []string
+	}{
+		{name: "неизвестный ключ файла", file: "server:\n  prot: 1\n", want: []string{"prot"}},
+		{name: "неверное значение окружения", env: map[string]string{"LIBRARY_SERVER_PORT": "http"}, want: []string{"LIBRARY_SERVER_PORT"}},
+		{name: "неверный флаг", args: []string{"-read-timeout", "soon"}, want: []string{"-read-timeout"}},
+		{
+			name: "все ошибки проверки сразу",
+			args: []string{"-port", "70000", "-log-level", "loud", "-delete-policy", "keep"},
+			want: []string{"server.port", "log.level", "storage.delete_policy"},
+		},
+		{name: "tls.dev вместе с сертификатом", args: []string{"-tls-dev", "-tls-cert", "c.pem", "-tls-key", "k.pem"}, want: []string{"tls.dev"}},
+		{name: "неверное имя хоста", args: []string{"-bind", "bad host"}, want: []string{"server.bind"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			_, err := load(t, args...)
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFillsPaths(t *testing.T) {
+	c, err := load(t, "-tls-dev", "-trace-exporter", tracing.ExporterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := c.Storage.Dir
+	for name, got := range map[string]string{
+		"library.db":   c.Storage.DB,
+		"jwt.key":      c.Auth.JWTKey,
+		"dev-cert.pem": c.TLS.Cert,
+		"dev-key.pem":  c.TLS.Key,
+		"traces.jsonl": c.Tracing.File,
+	} {
+		if want := filepath.Join(dir, name); got != want {
+			t.Errorf("%s: path %q, want %q", name, got, want)
+		}
+	}
+
+	c, err = load(t, "-db", "/var/lib/library.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Storage.DB != "/var/lib/library.db" {
+		t.Errorf("explicit storage.db replaced with %q", c.Storage.DB)
+	}
+}
+
+// Validate только проверяет: каталог хранилища не создается, а настройки
+// не меняются
+func TestValidateHasNoSideEffects(t *testing.T) {
+	c := Default()
+	c.Swagger.Enabled = false
+	c.Storage.Dir = filepath.Join(t.TempDir(), "missing")
+	c.Server.Bind = "library.invalid"
+	c.TLS.Dev = true
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.Storage.Dir); !os.IsNotExist(err) {
+		t.Errorf("Validate created %s", c.Storage.Dir)
+	}
+	if c.Storage.DB != "" || c.Auth.JWTKey != "" || c.TLS.Cert != "" {
+		t.Errorf("Validate filled paths: db %q, jwt key %q, cert %q", c.Storage.DB, c.Auth.JWTKey, c.TLS.Cert)
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	tests := map[string]bool{
+		"localhost":             true,
+		"api.example.com":       true,
+		"api.example.com.":      true,
+		"node-1":                true,
+		"":                      false,
+		"bad host":              false,
+		"-api.example.com":      false,
+		"api..example.com":      false,
+		"api_1.example.com":     false,
+		strings.Repeat("a", 64): false,
+	}
+	for host, want := range tests {
+		if got := validHostname(host); got != want {
+			t.Errorf("validHostname(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -24,9 +24,161 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/auth/login": {
+            "post": {
+                "description": "Проверяет логин и пароль и выдает токен доступа и токен обновления.\nТокен доступа передается в заголовке Authorization: Bearer вместо X-API-Key.",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Войти",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "ivanov",
+                        "description": "Логин",
+                        "name": "login",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Пароль",
+                        "name": "password",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Токены",
+                        "schema": {
+                            "$ref": "#/definitions/auth.TokenPair"
+                        }
+                    },
+                    "401": {
+                        "description": "Неверный логин или пароль",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/me": {
+            "get": {
+                "description": "Возвращает ключ API или пользователя, с которым выполнен запрос, и его права",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Текущий субъект",
+                "responses": {
+                    "200": {
+                        "description": "Субъект запроса",
+                        "schema": {
+                            "$ref": "#/definitions/handler.Me"
+                        }
+                    },
+                    "401": {
+                        "description": "Запрос без ключа или токена",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/refresh": {
+            "post": {
+                "description": "Выдает новую пару токенов. Переданный токен обновления отзывается и повторно не принимается.",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Обновить токены",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Токен обновления",
+                        "name": "refresh_token",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Новые токены",
+                        "schema": {
+                            "$ref": "#/definitions/auth.TokenPair"
+                        }
+                    },
+                    "401": {
+                        "description": "Токен недействителен или отозван",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/revoke": {
+            "post": {
+                "description": "Отзывает токен доступа или обновления, например при выходе пользователя",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain",
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Отозвать токен",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Токен доступа или обновления",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Token revoked successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Токен недействителен",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
         "/books": {
             "get": {
-                "description": "Возвращает полный список книг в коллекции",
+                "description": "Возвращает книги коллекции. Без параметров выборки коллекция отдается целиком одной страницей,\nс любым из них ответ постраничный, с заголовками X-Total-Count и Link.",
                 "consumes": [
                     "application/json"
                 ],
@@ -37,14 +189,64 @@ const docTemplate = `{
                     "books"
                 ],
                 "summary": "Получить все книги",
+                "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Размер страницы",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение от начала выборки",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы из next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "-price",
+                        "description": "Поле сортировки: id, name, author, price; минус для убывания",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Автор книги",
+                        "name": "author",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Минимальная цена",
+                        "name": "price_min",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Максимальная цена",
+                        "name": "price_max",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "Список всех книг",
+                        "description": "Страница книг",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.BookModel"
-                            }
+                            "$ref": "#/definitions/model.BookList"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные параметры выборки",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -54,10 +256,12 @@ const docTemplate = `{
             "post": {
                 "description": "Создает новую книгу с указанными параметрами",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "books"
@@ -94,24 +298,98 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "400": {
+                        "description": "Неверные данные запроса",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Ошибка сохранения",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/books/search": {
+            "get": {
+                "description": "Полнотекстовый поиск по названию и автору книги без учета регистра.\nСлова сравниваются по основе (русская морфология), слово запроса может быть и началом слова.\nНайдены книги, содержащие все слова запроса, совпадения в названии весят больше.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "books"
+                ],
+                "summary": "Поиск книг",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "война и мир",
+                        "description": "Поисковый запрос",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Размер страницы",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение от начала выдачи",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Найденные книги",
+                        "schema": {
+                            "$ref": "#/definitions/model.BookSearchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Пустой запрос или неверные параметры",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
                     }
                 }
             }
         },
         "/books/update": {
             "post": {
-                "description": "Обновляет данные книги по её идентификатору",
+                "description": "Обновляет данные книги по её идентификатору, если она не изменилась с чтения.\nНовый ETag книги возвращается в заголовке ответа.",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "books"
                 ],
                 "summary": "Обновить книгу",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag книги из GET /books/{id} или *",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "minimum": 1,
                         "type": "integer",
@@ -147,6 +425,42 @@ const docTemplate = `{
                         "description": "Book updated successfully",
                         "schema": {
                             "type": "string"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Новая версия книги"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные данные запроса",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Книга не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Книга изменилась",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Нет заголовка If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Ошибка сохранения",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -173,6 +487,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag известной клиенту версии",
+                        "name": "If-None-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -180,17 +500,43 @@ const docTemplate = `{
                         "description": "Информация о книге",
                         "schema": {
                             "$ref": "#/definitions/model.BookModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Версия книги"
+                            },
+                            "Last-Modified": {
+                                "type": "string",
+                                "description": "Время последнего изменения"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Книга не изменилась"
+                    },
+                    "400": {
+                        "description": "Неверный ID",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Книга не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Удаляет книгу по указанному идентификатору",
+                "description": "Удаляет книгу по указанному идентификатору, если она не изменилась с чтения",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "books"
@@ -204,6 +550,13 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag книги из GET /books/{id} или *",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -212,23 +565,303 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "400": {
+                        "description": "Неверный ID",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Книга не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "На книгу ссылаются покупки",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Книга изменилась",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Нет заголовка If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Ошибка удаления",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
                     }
                 }
             }
         },
-        "/story": {
+        "/keys": {
             "get": {
-                "description": "Маршрутизирует запросы к соответствующим методам обработки истории покупок",
-                "summary": "Основной обработчик запросов истории покупок",
-                "responses": {}
-            },
-            "post": {
+                "description": "Возвращает описания всех ключей, включая отозванные и истекшие. Требует право admin:keys.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Получить ключи API",
+                "responses": {
+                    "200": {
+                        "description": "Ключи API",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/auth.KeyInfo"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права admin:keys",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Создает ключ с указанными правами. Права перечисляются через запятую\nв виде действие:ресурс (read, write, delete, admin и books, users, story, keys),\nзвездочка заменяет любое действие или ресурс. Ключ возвращается только в этом ответе.",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Выпустить ключ API",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "frontend",
+                        "description": "Имя ключа",
+                        "name": "name",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "read:books,write:story",
+                        "description": "Права ключа",
+                        "name": "scopes",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Срок действия, например 720h",
+                        "name": "expires_in",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Момент истечения в RFC 3339",
+                        "name": "expires_at",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Новый ключ",
+                        "schema": {
+                            "$ref": "#/definitions/handler.CreatedKey"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные данные запроса",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права admin:keys",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/{id}": {
+            "get": {
+                "description": "Возвращает описание ключа без секрета. Требует право admin:keys.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Получить ключ API",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID ключа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Ключ API",
+                        "schema": {
+                            "$ref": "#/definitions/auth.KeyInfo"
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права admin:keys",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Ключ не найден",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Ключ перестает приниматься сразу, запись о нем остается. Требует право admin:keys.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Отозвать ключ API",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID ключа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Отозванный ключ",
+                        "schema": {
+                            "$ref": "#/definitions/auth.KeyInfo"
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права admin:keys",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Ключ не найден",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/story": {
+            "get": {
+                "description": "Возвращает записи о покупках/аренде. Без параметров выборки история отдается целиком одной страницей,\nс любым из них ответ постраничный, с заголовками X-Total-Count и Link.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "purchases"
+                ],
+                "summary": "Получить все покупки",
+                "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Размер страницы",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение от начала выборки",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы из next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "-start_at",
+                        "description": "Поле сортировки: id, book_id, user_id, start_at, end_at; минус для убывания",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID книги",
+                        "name": "book_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "true: только открытые аренды, false: только завершенные",
+                        "name": "active",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Страница покупок",
+                        "schema": {
+                            "$ref": "#/definitions/model.PurchaseList"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные параметры выборки",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
                 "description": "Создает новую запись о покупке или аренде книги пользователем",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "purchases"
@@ -262,13 +895,13 @@ const docTemplate = `{
                     "400": {
                         "description": "Неверные данные запроса",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "500": {
                         "description": "Внутренняя ошибка сервера",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -307,12 +940,14 @@ const docTemplate = `{
         },
         "/story/update/{id}": {
             "put": {
-                "description": "Обновляет данные существующей записи о покупке или аренде",
+                "description": "Обновляет данные существующей записи о покупке или аренде, если она не изменилась с чтения.\nНовый ETag записи возвращается в заголовке ответа.",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "purchases"
@@ -327,6 +962,13 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "ETag записи из GET /story/id/{id} или *",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "type": "integer",
                         "example": 2,
@@ -347,24 +989,42 @@ const docTemplate = `{
                         "description": "Loan succesfully updated",
                         "schema": {
                             "type": "string"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Новая версия записи"
+                            }
                         }
                     },
                     "400": {
                         "description": "Неверные данные запроса",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "404": {
                         "description": "Запись не найдена",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Запись изменилась",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Нет заголовка If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "500": {
                         "description": "Внутренняя ошибка сервера",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -384,7 +1044,7 @@ const docTemplate = `{
         },
         "/users": {
             "get": {
-                "description": "Возвращает полный список всех пользователей в системе",
+                "description": "Возвращает пользователей системы. Без параметров выборки список отдается целиком одной страницей,\nс любым из них ответ постраничный, с заголовками X-Total-Count и Link.",
                 "consumes": [
                     "application/json"
                 ],
@@ -395,14 +1055,58 @@ const docTemplate = `{
                     "users"
                 ],
                 "summary": "Получить всех пользователей",
+                "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Размер страницы",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение от начала выборки",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы из next_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "surname",
+                        "description": "Поле сортировки: id, name, surname; минус для убывания",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Имя пользователя",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Фамилия пользователя",
+                        "name": "surname",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "Список всех пользователей",
+                        "description": "Страница пользователей",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.User"
-                            }
+                            "$ref": "#/definitions/model.UserList"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные параметры выборки",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -412,10 +1116,12 @@ const docTemplate = `{
             "post": {
                 "description": "Создает нового пользователя в системе",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "users"
@@ -437,6 +1143,18 @@ const docTemplate = `{
                         "name": "surname",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "enum": [
+                            "admin",
+                            "librarian",
+                            "member"
+                        ],
+                        "type": "string",
+                        "default": "member",
+                        "description": "Роль, кроме member назначает только администратор",
+                        "name": "role",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
@@ -449,19 +1167,25 @@ const docTemplate = `{
                     "400": {
                         "description": "Неверные данные запроса",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права назначать роль",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "409": {
                         "description": "Пользователь уже существует",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "500": {
                         "description": "Ошибка добавления",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -469,18 +1193,27 @@ const docTemplate = `{
         },
         "/users/update": {
             "post": {
-                "description": "Обновляет данные существующего пользователя",
+                "description": "Обновляет данные существующего пользователя, если он не изменился с чтения.\nНовый ETag пользователя возвращается в заголовке ответа.",
                 "consumes": [
-                    "application/x-www-form-urlencoded"
+                    "application/x-www-form-urlencoded",
+                    "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "users"
                 ],
                 "summary": "Обновить пользователя",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag пользователя из GET /users/{id} или *",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "minimum": 1,
                         "type": "integer",
@@ -502,6 +1235,17 @@ const docTemplate = `{
                         "description": "Новая фамилия пользователя",
                         "name": "surname",
                         "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "admin",
+                            "librarian",
+                            "member"
+                        ],
+                        "type": "string",
+                        "description": "Новая роль, меняет только администратор",
+                        "name": "role",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
@@ -509,24 +1253,48 @@ const docTemplate = `{
                         "description": "User updated successfully!",
                         "schema": {
                             "type": "string"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Новая версия пользователя"
+                            }
                         }
                     },
                     "400": {
                         "description": "Неверные данные запроса",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Нет права менять роль",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "404": {
                         "description": "Пользователь не найден",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Пользователь изменился",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Нет заголовка If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "500": {
                         "description": "Ошибка обновления",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
@@ -553,36 +1321,56 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
-                    }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag известной клиенту версии",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
                 ],
                 "responses": {
                     "200": {
                         "description": "Информация о пользователе",
                         "schema": {
                             "$ref": "#/definitions/model.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Версия пользователя"
+                            },
+                            "Last-Modified": {
+                                "type": "string",
+                                "description": "Время последнего изменения"
+                            }
                         }
                     },
+                    "304": {
+                        "description": "Пользователь не изменился"
+                    },
                     "400": {
                         "description": "Неверный ID",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "404": {
                         "description": "Пользователь не найден",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Удаляет пользователя из системы по его идентификатору",
+                "description": "Удаляет пользователя из системы по его идентификатору, если он не изменился с чтения",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "text/plain"
+                    "text/plain",
+                    "application/json"
                 ],
                 "tags": [
                     "users"
@@ -596,6 +1384,13 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag пользователя из GET /users/{id} или *",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -608,20 +1403,326 @@ const docTemplate = `{
                     "404": {
                         "description": "Пользователь не найден",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "На пользователя ссылаются покупки",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "Пользователь изменился",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "Нет заголовка If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
                         }
                     },
                     "500": {
                         "description": "Ошибка удаления",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/credentials": {
+            "post": {
+                "description": "Задает логин и пароль, с которыми пользователь входит через /auth/login.\nПрава вошедшего пользователя определяются его ролью. Смена пароля отзывает все токены пользователя.",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Задать учетные данные пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "ivanov",
+                        "description": "Логин",
+                        "name": "login",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Пароль, не короче 8 символов",
+                        "name": "password",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Учетные данные без пароля",
+                        "schema": {
+                            "$ref": "#/definitions/auth.CredentialInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверные данные запроса",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Логин занят",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Удаляет логин и пароль пользователя, все его токены перестают приниматься",
+                "produces": [
+                    "text/plain",
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Удалить учетные данные пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Credentials removed successfully!",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "404": {
+                        "description": "Учетные данные не найдены",
+                        "schema": {
+                            "$ref": "#/definitions/utils.Problem"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "auth.CredentialInfo": {
+            "description": "Логин пользователя для входа по JWT",
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "description": "ChangedAt момент смены пароля: токены, выданные раньше, недействительны",
+                    "type": "string"
+                },
+                "login": {
+                    "type": "string",
+                    "example": "ivanov"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "auth.KeyInfo": {
+            "description": "Ключ API: имя, права и срок действия. Сам ключ не хранится и не возвращается.",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3f9a1c2b7d4e5a60"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "frontend"
+                },
+                "prefix": {
+                    "type": "string",
+                    "example": "lk_Zx81"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:books",
+                        "write:story"
+                    ]
+                }
+            }
+        },
+        "auth.TokenPair": {
+            "description": "Токен доступа и токен обновления",
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "ExpiresIn время жизни токена доступа в секундах",
+                    "type": "integer",
+                    "example": 900
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string",
+                    "example": "Bearer"
+                }
+            }
+        },
+        "handler.CreatedKey": {
+            "description": "Описание нового ключа и сам ключ. Ключ показывается только один раз.",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3f9a1c2b7d4e5a60"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "frontend"
+                },
+                "prefix": {
+                    "type": "string",
+                    "example": "lk_Zx81"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:books",
+                        "write:story"
+                    ]
+                },
+                "token": {
+                    "type": "string",
+                    "example": "lk_Zx81Qm3rVYb0c2kP9n4T7wLd5sHfA6jE1gUoRiXyC8M"
+                }
+            }
+        },
+        "handler.Me": {
+            "description": "Ключ API или пользователь, от имени которого выполняется запрос",
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "example": "1"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "ivanov"
+                },
+                "role": {
+                    "type": "string",
+                    "example": "member"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "read:*"
+                    ]
+                },
+                "type": {
+                    "type": "string",
+                    "example": "user"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "model.BookHit": {
+            "description": "Книга из результатов поиска и ее релевантность",
+            "type": "object",
+            "properties": {
+                "book": {
+                    "$ref": "#/definitions/model.BookModel"
+                },
+                "score": {
+                    "type": "number"
+                }
+            }
+        },
+        "model.BookList": {
+            "description": "Страница коллекции книг",
+            "type": "object",
+            "properties": {
+                "books": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.BookModel"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
         "model.BookModel": {
             "description": "Информация о книге",
             "type": "object",
@@ -637,6 +1738,92 @@ const docTemplate = `{
                 },
                 "price": {
                     "type": "number"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version растет с каждым изменением книги, из нее строится ETag",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.BookSearchResult": {
+            "description": "Результаты полнотекстового поиска книг, от самых релевантных",
+            "type": "object",
+            "properties": {
+                "hits": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.BookHit"
+                    }
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "query": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.Purchase": {
+            "type": "object",
+            "properties": {
+                "book_id": {
+                    "type": "integer"
+                },
+                "end_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "orphaned": {
+                    "description": "Orphaned отмечает покупки, книга или пользователь которых удалены\nпри политике DeleteOrphan",
+                    "type": "boolean"
+                },
+                "start_at": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "version": {
+                    "description": "Version растет с каждым изменением покупки, из нее строится ETag",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.PurchaseList": {
+            "description": "Страница истории покупок",
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "purchases": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Purchase"
+                    }
+                },
+                "total": {
+                    "type": "integer"
                 }
             }
         },
@@ -649,8 +1836,78 @@ const docTemplate = `{
                 "name": {
                     "type": "string"
                 },
+                "role": {
+                    "description": "Role роль пользователя: admin, librarian или member",
+                    "type": "string"
+                },
                 "surname": {
                     "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version растет с каждым изменением пользователя, из нее строится ETag",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.UserList": {
+            "description": "Страница коллекции пользователей",
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.User"
+                    }
+                }
+            }
+        },
+        "utils.Problem": {
+            "description": "Машиночитаемое описание ошибки",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "book_not_found"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "book not found"
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/api/v2/books/42"
+                },
+                "request_id": {
+                    "description": "RequestId совпадает с заголовком X-Request-ID и записями журнала о запросе",
+                    "type": "string",
+                    "example": "4bf92f3577b34da6a3ce929d0e0e4736"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not Found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "https://www.libraryapi.com/problems/book_not_found"
                 }
             }
         }
@@ -661,6 +1918,12 @@ const docTemplate = `{
             "type": "apiKey",
             "name": "X-API-Key",
             "in": "header"
+        },
+        "BearerAuth": {
+            "description": "JWT из /auth/login в виде \"Bearer \u003caccess_token\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -670,7 +1933,7 @@ var SwaggerInfo = &swag.Spec{
 	Version:          "2.0.0",
 	Host:             "localhost:8080",
 	BasePath:         "/api/v2",
-	Schemes:          []string{"http"},
+	Schemes:          []string{"http", "https"},
 	Title:            "Library Management REST API",
 	Description:      "Полное REST API для управления библиотекой с поддержкой версий v1 и v2",
 	InfoInstanceName: "swagger",
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"restapi/auth"
+	"restapi/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// AuthHandler обработчик входа пользователей по логину и паролю
+// @Description Выдача, обновление и отзыв JWT
+type AuthHandler struct {
+	Sessions *auth.Sessions
+}
+
+// Me субъект текущего запроса
+// @Description Ключ API или пользователь, от имени которого выполняется запрос
+type Me struct {
+	Type   string   `json:"type" example:"user"`
+	Id     string   `json:"id" example:"1"`
+	Name   string   `json:"name" example:"ivanov"`
+	Scopes []string `json:"scopes" example:"read:*"`
+	UserId *int     `json:"user_id,omitempty" example:"1"`
+	Role   string   `json:"role,omitempty" example:"member"`
+}
+
+// NewAuthHandler создает обработчик входа
+func NewAuthHandler(sessions *auth.Sessions) http.Handler {
+	return &AuthHandler{Sessions: sessions}
+}
+
+// ServeHTTP обрабатывает входящие HTTP запросы для входа
+func (h *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch mux.Vars(r)["action"] {
+	case "login":
+		h.Login(w, r)
+	case "refresh":
+		h.Refresh(w, r)
+	case "revoke":
+		h.Revoke(w, r)
+	case "me":
+		h.Me(w, r)
+	default:
+		utils.ErrNotFoundApi(w, r)
+	}
+}
+
+// Login выдает токены по логину и паролю
+// @Summary Войти
+// @Description Проверяет логин и пароль и выдает токен доступа и токен обновления.
+// @Description Токен доступа передается в заголовке Authorization: Bearer вместо X-API-Key.
+// @Tags auth
+// @Accept application/x-www-form-urlencoded,json
+// @Produce json
+// @Param login formData string true "Логин" example(ivanov)
+// @Param password formData string true "Пароль"
+// @Success 200 {object} auth.TokenPair "Токены"
+// @Failure 401 {object} utils.Problem "Неверный логин или пароль"
+// @Router /auth/login [post]
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	tokens, err := h.Sessions.Login(r.Context(), form.Get("login"), form.Get("password"))
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	writeTokens(w, r, tokens)
+}
+
+// Refresh обменивает токен обновления на новую пару токенов
+// @Summary Обновить токены
+// @Description Выдает новую пару токенов. Переданный токен обновления отзывается и повторно не принимается.
+// @Tags auth
+// @Accept application/x-www-form-urlencoded,json
+// @Produce json
+// @Param refresh_token formData string true "Токен обновления"
+// @Success 200 {object} auth.TokenPair "Новые токены"
+// @Failure 401 {object} utils.Problem "Токен недействителен или отозван"
+// @Router /auth/refresh [post]
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	tokens, err := h.Sessions.Refresh(r.Context(), form.Get("refresh_token"))
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	writeTokens(w, r, tokens)
+}
+
+// Revoke отзывает токен
+// @Summary Отозвать токен
+// @Description Отзывает токен доступа или обновления, например при выходе пользователя
+// @Tags auth
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
+// @Param token formData string true "Токен доступа или обновления"
+// @Success 200 {string} string "Token revoked successfully"
+// @Failure 401 {object} utils.Problem "Токен недействителен"
+// @Router /auth/revoke [post]
+func (h *AuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if err := h.Sessions.Revoke(r.Context(), form.Get("token")); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	utils.WriteMessage(w, r, http.StatusOK, "Token revoked successfully")
+}
+
+// Me возвращает субъект текущего запроса
+// @Summary Текущий субъект
+// @Description Возвращает ключ API или пользователя, с которым выполнен запрос, и его права
+// @Tags auth
+// @Produce json
+// @Success 200 {object} Me "Субъект запроса"
+// @Failure 401 {object} utils.Problem "Запрос без ключа или токена"
+// @Router /auth/me [get]
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		writeAuthError(w, r, auth.ErrInvalidToken)
+		return
+	}
+	me := Me{Type: p.Type, Id: p.Id, Name: p.Name, Scopes: p.Scopes}
+	if p.Type == auth.PrincipalUser {
+		me.UserId = &p.UserId
+		me.Role = p.Role
+	}
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(me))
+}
+
+func writeTokens(w http.ResponseWriter, r *http.Request, tokens auth.TokenPair) {
+	// Токены не должны оседать в кэшах (RFC 6749, 5.1)
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(tokens))
+}
+
+// writeAuthError отправляет ошибку входа или проверки токена
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *utils.Problem
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		p = utils.NewProblem(http.StatusUnauthorized, utils.CodeInvalidCredentials, err.Error())
+	case errors.Is(err, auth.ErrTokenRevoked):
+		p = utils.NewProblem(http.StatusUnauthorized, utils.CodeRevokedToken, err.Error())
+	case errors.Is(err, auth.ErrInvalidToken):
+		p = utils.NewProblem(http.StatusUnauthorized, utils.CodeInvalidToken, err.Error())
+	default:
+		writeError(w, r, err)
+		return
+	}
+	utils.WriteProblem(w, r, p)
+}
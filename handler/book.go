@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"io"
 	"net/http"
 	"restapi/model"
 	"restapi/utils"
@@ -15,16 +16,19 @@ import (
 // @Description Обработчик для работы с коллекцией книг
 type BookHandler struct {
 	Books model.Books
+	// Search полнотекстовый поиск, если хранилище книг его поддерживает
+	Search model.BookSearcher
 }
 
 // NewBookHandler создает новый экземпляр BookHandler
 // @Summary Создать обработчик книг
 // @Description Инициализирует и возвращает новый обработчик для работы с книгами
 // @Return http.Handler готовый обработчик HTTP запросов
-func NewBookHandler() http.Handler {
+func NewBookHandler(books model.Books) http.Handler {
 	h := &BookHandler{
-		model.BooksInit(),
+		Books: books,
 	}
+	h.Search, _ = books.(model.BookSearcher)
 	return h
 }
 
@@ -33,6 +37,7 @@ func NewBookHandler() http.Handler {
 // @Description Маршрутизирует запросы к соответствующим методам обработки
 // @Param method path string true "HTTP метод"
 // @Router /books [get]
+// @Router /books/search [get]
 // @Router /books/{id} [get]
 // @Router /books/add [post]
 // @Router /books/update [post]
@@ -40,7 +45,9 @@ func NewBookHandler() http.Handler {
 func (h *BookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		if mux.Vars(r)["id"] == "" {
+		if mux.Vars(r)["action"] == "search" {
+			h.SearchBooks(w, r)
+		} else if mux.Vars(r)["id"] == "" {
 			h.GetAllBooks(w, r)
 		} else {
 			h.GetBook(w, r)
@@ -67,26 +74,36 @@ func (h *BookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // @Summary Добавить новую книгу
 // @Description Создает новую книгу с указанными параметрами
 // @Tags books
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
 // @Param name formData string true "Название книги" example("Война и мир")
 // @Param author formData string true "Автор книги" example("Лев Толстой")
 // @Param price formData number false "Цена книги" example(599.99)
 // @Success 200 {string} string "Book added successfully"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 500 {object} utils.Problem "Ошибка сохранения"
 // @Router /books/add [post]
 func (h *BookHandler) AddBook(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
 	book := model.BookModel{
-		Name:   r.FormValue("name"),
-		Author: r.FormValue("author"),
+		Name:   form.Get("name"),
+		Author: form.Get("author"),
 	}
-	price, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	price, err := strconv.ParseFloat(form.Get("price"), 64)
 	if err != nil {
 		price = 100
 	}
 	book.Price = price
 
-	h.Books.AddBook(book)
-	w.Write([]byte("Book added successfully"))
+	if err := h.Books.AddBook(r.Context(), book); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	utils.WriteMessage(w, r, http.StatusOK, "Book added successfully")
 }
 
 // GetBook возвращает информацию о конкретной книге
@@ -96,77 +113,197 @@ func (h *BookHandler) AddBook(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path int true "ID книги" minimum(1)
+// @Param If-None-Match header string false "ETag известной клиенту версии"
 // @Success 200 {object} model.BookModel "Информация о книге"
+// @Header 200 {string} ETag "Версия книги"
+// @Header 200 {string} Last-Modified "Время последнего изменения"
+// @Success 304 "Книга не изменилась"
+// @Failure 400 {object} utils.Problem "Неверный ID"
+// @Failure 404 {object} utils.Problem "Книга не найдена"
 // @Router /books/{id} [get]
 func (h *BookHandler) GetBook(w http.ResponseWriter, r *http.Request) {
-	res, _ := strconv.Atoi(mux.Vars(r)["id"])
-	result := h.Books.GetBook(res)
-	if result == nil {
-		http.Error(w, "Книга не найдена", 400)
-	} else {
-		w.Write(result)
+	res, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		badRequest(w, r, utils.CodeInvalidId, "Book id must be an integer")
+		return
 	}
-
+	book, err := h.Books.FindBook(r.Context(), res)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeVersioned(w, r, book.Version, book.UpdatedAt, book)
 }
 
 // GetAllBooks возвращает список всех книг
 // @Summary Получить все книги
-// @Description Возвращает полный список книг в коллекции
+// @Description Возвращает книги коллекции. Без параметров выборки коллекция отдается целиком одной страницей,
+// @Description с любым из них ответ постраничный, с заголовками X-Total-Count и Link.
 // @Tags books
 // @Accept json
 // @Produce json
-// @Success 200 {array} model.BookModel "Список всех книг"
+// @Param limit query int false "Размер страницы" default(50) maximum(1000)
+// @Param offset query int false "Смещение от начала выборки"
+// @Param cursor query string false "Курсор следующей страницы из next_cursor"
+// @Param sort query string false "Поле сортировки: id, name, author, price; минус для убывания" example(-price)
+// @Param author query string false "Автор книги"
+// @Param price_min query number false "Минимальная цена"
+// @Param price_max query number false "Максимальная цена"
+// @Success 200 {object} model.BookList "Страница книг"
+// @Failure 400 {object} utils.Problem "Неверные параметры выборки"
 // @Router /books [get]
 func (h *BookHandler) GetAllBooks(w http.ResponseWriter, r *http.Request) {
-	w.Write(h.Books.GetAllBooks())
+	if !wantsList(r, "author", "price_min", "price_max") {
+		err := utils.StreamJSON(w, r, http.StatusOK, func(body io.Writer) error {
+			return h.Books.WriteAllBooks(r.Context(), body)
+		})
+		if err != nil {
+			writeError(w, r, err)
+		}
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := model.BookQuery{Page: page, Author: r.URL.Query().Get("author")}
+	if q.PriceMin, err = queryFloat(r, "price_min"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if q.PriceMax, err = queryFloat(r, "price_max"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := h.Books.ListBooks(r.Context(), q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page.Limit, page.Offset = list.Limit, list.Offset
+	writeList(w, r, list, page, list.Total, list.NextCursor)
+}
+
+// SearchBooks ищет книги по названию и автору
+// @Summary Поиск книг
+// @Description Полнотекстовый поиск по названию и автору книги без учета регистра.
+// @Description Слова сравниваются по основе (русская морфология), слово запроса может быть и началом слова.
+// @Description Найдены книги, содержащие все слова запроса, совпадения в названии весят больше.
+// @Tags books
+// @Accept json
+// @Produce json
+// @Param q query string true "Поисковый запрос" example(война и мир)
+// @Param limit query int false "Размер страницы" default(50) maximum(1000)
+// @Param offset query int false "Смещение от начала выдачи"
+// @Success 200 {object} model.BookSearchResult "Найденные книги"
+// @Failure 400 {object} utils.Problem "Пустой запрос или неверные параметры"
+// @Router /books/search [get]
+func (h *BookHandler) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	if h.Search == nil {
+		utils.ErrNotFoundApi(w, r)
+		return
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := h.Search.SearchBooks(r.Context(), r.URL.Query().Get("q"), page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page.Limit, page.Offset = res.Limit, res.Offset
+	writeList(w, r, res, page, res.Total, "")
 }
 
 // RemoveBook удаляет книгу из коллекции
 // @Summary Удалить книгу
-// @Description Удаляет книгу по указанному идентификатору
+// @Description Удаляет книгу по указанному идентификатору, если она не изменилась с чтения
 // @Tags books
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID книги для удаления" minimum(1)
+// @Param If-Match header string true "ETag книги из GET /books/{id} или *"
 // @Success 200 {string} string "Book removed successfully"
+// @Failure 400 {object} utils.Problem "Неверный ID"
+// @Failure 404 {object} utils.Problem "Книга не найдена"
+// @Failure 409 {object} utils.Problem "На книгу ссылаются покупки"
+// @Failure 412 {object} utils.Problem "Книга изменилась"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка удаления"
 // @Router /books/{id} [delete]
 func (h *BookHandler) RemoveBook(w http.ResponseWriter, r *http.Request) {
-	res, _ := strconv.Atoi(mux.Vars(r)["id"])
-	h.Books.RemoveBook(res)
-	w.Write([]byte("Book removed successfully"))
+	res, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		badRequest(w, r, utils.CodeInvalidId, "Book id must be an integer")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Books.RemoveBook(r.Context(), res, version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	utils.WriteMessage(w, r, http.StatusOK, "Book removed successfully")
 }
 
 // UpdateBook обновляет информацию о существующей книге
 // @Summary Обновить книгу
-// @Description Обновляет данные книги по её идентификатору
+// @Description Обновляет данные книги по её идентификатору, если она не изменилась с чтения.
+// @Description Новый ETag книги возвращается в заголовке ответа.
 // @Tags books
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
+// @Param If-Match header string true "ETag книги из GET /books/{id} или *"
 // @Param id formData int true "ID книги для обновления" minimum(1)
 // @Param name formData string false "Новое название книги" example("Обновленное название")
 // @Param author formData string false "Новый автор" example("Новый автор")
 // @Param price formData number false "Новая цена" example(699.99)
 // @Success 200 {string} string "Book updated successfully"
+// @Header 200 {string} ETag "Новая версия книги"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 404 {object} utils.Problem "Книга не найдена"
+// @Failure 412 {object} utils.Problem "Книга изменилась"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка сохранения"
 // @Router /books/update [post]
 func (h *BookHandler) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
 	book := model.BookModel{
-		Name:   r.FormValue("name"),
-		Author: r.FormValue("author"),
+		Name:   form.Get("name"),
+		Author: form.Get("author"),
 	}
-	price, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	price, err := strconv.ParseFloat(form.Get("price"), 64)
 	if err != nil {
 		price = 100
 	}
 	book.Price = price
 
-	fId, err := strconv.Atoi(r.FormValue("id"))
+	fId, err := strconv.Atoi(form.Get("id"))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("Book id is required"))
+		badRequest(w, r, utils.CodeInvalidId, "Book id is required")
+		return
 	}
 	book.Id = fId
+	var ok bool
+	if book.Version, ok = ifMatch(w, r); !ok {
+		return
+	}
 
-	h.Books.UpdateBook(book)
-
-	w.Write([]byte("Book updated successfully"))
+	if err := h.Books.UpdateBook(r.Context(), book); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setNextETag(w, book.Version)
+	utils.WriteMessage(w, r, http.StatusOK, "Book updated successfully")
 }
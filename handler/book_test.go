@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"restapi/repository"
+	"testing"
+)
+
+// TestBookRoutesParallel обращается ко всем маршрутам книг одновременно
+func TestBookRoutesParallel(t *testing.T) {
+	h, repo := newTestRouter(t)
+	seed(t, h)
+
+	ok, gone := []int{http.StatusOK}, []int{http.StatusOK, http.StatusNotFound}
+	succeeded := hammer(t, h, []route{
+		{name: "list", method: "GET", target: path("/api/v2/books"), allowed: ok},
+		{name: "page", method: "GET", target: path("/api/v2/books?limit=5&sort=-price"), allowed: ok},
+		{name: "search", method: "GET", target: path("/api/v2/books/search?q=book"), allowed: ok},
+		{name: "get", method: "GET", target: pathId("/api/v2/books/%d", 1), allowed: gone},
+		{name: "add", method: "POST", target: path("/api/v2/books/add"), allowed: ok,
+			form: func(i int) url.Values {
+				return url.Values{"name": {fmt.Sprintf("New %d", i)}, "author": {"Author"}, "price": {"50"}}
+			}},
+		{name: "update", method: "POST", target: path("/api/v2/books/update"), allowed: gone,
+			form: func(i int) url.Values {
+				return url.Values{"id": {fmt.Sprint(1 + i%seeded)}, "name": {"Updated"}, "author": {"Author"}, "price": {"75"}}
+			}},
+		{name: "delete", method: "DELETE", target: pathId("/api/v2/books/%d", seeded/2), allowed: gone},
+	})
+	// Каждый id удаляется успешно не больше одного раза
+	checkSaved(t, repo, repository.Books, seeded+succeeded["add"]-succeeded["delete"])
+}
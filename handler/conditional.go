@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"restapi/model"
+	"restapi/utils"
+	"time"
+)
+
+// writeVersioned отправляет запись v версии version с заголовками ETag
+// и Last-Modified. Если у клиента уже есть эта версия, ответ 304 без тела.
+func writeVersioned(w http.ResponseWriter, r *http.Request, version int, modified time.Time, v any) {
+	etag := utils.ETag(version)
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if utils.NotModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalThis(r.Context(), v))
+}
+
+// ifMatch возвращает версию из заголовка If-Match. Если заголовка нет
+// или он неверен, отправляет ошибку и возвращает false.
+func ifMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	version, err := utils.IfMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return 0, false
+	}
+	return version, true
+}
+
+// setNextETag сообщает тег записи после изменения: проверенная версия
+// увеличивается ровно на единицу. После If-Match: * новая версия неизвестна.
+func setNextETag(w http.ResponseWriter, version int) {
+	if version != model.AnyVersion {
+		w.Header().Set("ETag", utils.ETag(version+1))
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// versioned маршрут изменения записи и маршрут, по которому читается ее ETag
+type versioned struct {
+	name   string
+	get    string
+	method string
+	update string
+	form   func(i int) url.Values
+}
+
+var versionedRoutes = []versioned{
+	{name: "book", get: "/api/v2/books/1", method: "POST", update: "/api/v2/books/update",
+		form: func(i int) url.Values {
+			return url.Values{"id": {"1"}, "name": {fmt.Sprintf("Book v%d", i)}, "author": {"Author"}, "price": {"75"}}
+		}},
+	{name: "user", get: "/api/v2/users/0", method: "POST", update: "/api/v2/users/update",
+		form: func(i int) url.Values {
+			return url.Values{"id": {"0"}, "name": {fmt.Sprintf("User v%d", i)}, "surname": {"Surname"}}
+		}},
+	{name: "purchase", get: "/api/v2/story/id/0", method: "PUT", update: "/api/v2/story/update/0",
+		form: func(i int) url.Values {
+			return url.Values{"book_id": {fmt.Sprint(1 + i%seeded)}, "user_id": {"0"}}
+		}},
+}
+
+// etag читает текущий ETag записи
+func etag(t *testing.T, h http.Handler, target string) string {
+	t.Helper()
+	w := do(h, "GET", target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", target, w.Code, w.Body)
+	}
+	tag := w.Header().Get("ETag")
+	if tag == "" {
+		t.Fatalf("GET %s: no ETag", target)
+	}
+	return tag
+}
+
+func TestUpdatePreconditions(t *testing.T) {
+	for _, rt := range versionedRoutes {
+		t.Run(rt.name, func(t *testing.T) {
+			h, _ := newTestRouter(t)
+			seed(t, h)
+
+			if w := doIfMatch(h, rt.method, rt.update, rt.form(0), ""); w.Code != http.StatusPreconditionRequired {
+				t.Errorf("without If-Match: status %d, want 428: %s", w.Code, w.Body)
+			}
+
+			// Каждое изменение возвращает новый ETag, и он совпадает с тем,
+			// что потом отдает GET
+			stale := etag(t, h, rt.get)
+			current := stale
+			for i := 1; i <= 3; i++ {
+				w := doIfMatch(h, rt.method, rt.update, rt.form(i), current)
+				if w.Code != http.StatusOK {
+					t.Fatalf("update %d: status %d: %s", i, w.Code, w.Body)
+				}
+				next := w.Header().Get("ETag")
+				if next == "" || next == current {
+					t.Fatalf("update %d: ETag %q after %q, want a new one", i, next, current)
+				}
+				if got := etag(t, h, rt.get); got != next {
+					t.Fatalf("update %d: GET returns ETag %q, update returned %q", i, got, next)
+				}
+				current = next
+			}
+
+			if w := doIfMatch(h, rt.method, rt.update, rt.form(4), stale); w.Code != http.StatusPreconditionFailed {
+				t.Errorf("stale ETag: status %d, want 412: %s", w.Code, w.Body)
+			}
+			if got := etag(t, h, rt.get); got != current {
+				t.Errorf("stale update changed ETag from %q to %q", current, got)
+			}
+		})
+	}
+}
+
+// Параллельные изменения с одним и тем же настоящим ETag: выигрывает ровно
+// одно, остальные получают 412
+func TestConcurrentUpdatesOneWins(t *testing.T) {
+	for _, rt := range versionedRoutes {
+		t.Run(rt.name, func(t *testing.T) {
+			h, _ := newTestRouter(t)
+			seed(t, h)
+			tag := etag(t, h, rt.get)
+
+			codes := make([]int, workers)
+			var wg sync.WaitGroup
+			for i := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					codes[i] = doIfMatch(h, rt.method, rt.update, rt.form(i), tag).Code
+				}()
+			}
+			wg.Wait()
+
+			won := 0
+			for _, code := range codes {
+				switch code {
+				case http.StatusOK:
+					won++
+				case http.StatusPreconditionFailed:
+				default:
+					t.Errorf("status %d, want 200 or 412", code)
+				}
+			}
+			if won != 1 {
+				t.Errorf("%d updates won, want exactly one: %v", won, codes)
+			}
+			if got := etag(t, h, rt.get); got == tag {
+				t.Errorf("ETag %q did not change after the winning update", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"restapi/logging"
+	"restapi/model"
+	"restapi/utils"
+)
+
+// writeError отправляет ошибку модели клиенту в виде application/problem+json
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *utils.Problem
+	switch {
+	case errors.As(err, &p):
+	case errors.Is(err, model.ErrBookNotFound):
+		p = utils.NewProblem(http.StatusNotFound, utils.CodeBookNotFound, err.Error())
+	case errors.Is(err, model.ErrUserNotFound):
+		p = utils.NewProblem(http.StatusNotFound, utils.CodeUserNotFound, err.Error())
+	case errors.Is(err, model.ErrPurchaseNotFound):
+		p = utils.NewProblem(http.StatusNotFound, utils.CodePurchaseNotFound, err.Error())
+	case errors.Is(err, model.ErrInvalidRole):
+		p = utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRole, err.Error())
+	case errors.Is(err, model.ErrInvalidQuery):
+		p = utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidQuery, err.Error())
+	case errors.Is(err, model.ErrReferenced):
+		p = utils.NewProblem(http.StatusConflict, utils.CodeReferenced, err.Error())
+	case errors.Is(err, model.ErrVersionConflict):
+		p = utils.NewProblem(http.StatusPreconditionFailed, utils.CodeVersionMismatch, err.Error())
+	default:
+		// Клиент получает общий ответ, причина остается в журнале
+		logging.FromContext(r.Context()).Error("storage error", "err", err)
+		p = utils.NewProblem(http.StatusInternalServerError, utils.CodeStorage, "Got error while updating storage")
+	}
+	utils.WriteProblem(w, r, p)
+}
+
+// writeReferenceError отправляет ошибку создания или изменения покупки:
+// ссылка на несуществующие книгу или пользователя это ошибка данных запроса
+func writeReferenceError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, model.ErrBookNotFound) || errors.Is(err, model.ErrUserNotFound) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidReference, err.Error()))
+		return
+	}
+	writeError(w, r, err)
+}
+
+// writeBodyError отправляет ошибку разбора тела запроса: 413 для слишком
+// большого тела, остальные ошибки это 400
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *utils.Problem
+	if !errors.As(err, &p) {
+		p = utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, err.Error())
+	}
+	utils.WriteProblem(w, r, p)
+}
+
+// badRequest отправляет 400 с кодом code
+func badRequest(w http.ResponseWriter, r *http.Request, code, detail string) {
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, code, detail))
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"restapi/auth"
+	"restapi/model"
+	"restapi/repository"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// workers сколько горутин одновременно обращаются к одному маршруту
+const workers = 8
+
+// rounds сколько запросов подряд делает каждая горутина, чтобы запросы
+// разных маршрутов успели пересечься
+const rounds = 50
+
+// seeded сколько книг, пользователей и покупок создается до начала теста
+const seeded = 16
+
+// TestMain заменяет глобальный поставщик трассировки пустым. Поставщик по
+// умолчанию берет общий мьютекс при каждом спане, и этот мьютекс упорядочивает
+// запросы так, что детектор гонок перестает видеть несинхронный доступ к данным.
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	os.Exit(m.Run())
+}
+
+// newTestRouter собирает обработчики поверх хранилища в памяти на тех же
+// маршрутах /api/v2, что и сервер, но без проверки ключей и прав. Вместе с
+// маршрутами возвращается хранилище, чтобы проверить сохраненное состояние.
+func newTestRouter(t *testing.T) (http.Handler, repository.Repository) {
+	t.Helper()
+	sessions, err := auth.NewSessions(auth.SessionConfig{
+		KeyFile: filepath.Join(t.TempDir(), "jwt.key"),
+	}, repository.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewMemory()
+	handlers, err := NewHandlerManager(repo, model.DeleteCascade, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := mux.NewRouter().PathPrefix("/api/v2").Subrouter()
+	users, books, story := handlers["users"], handlers["books"], handlers["story"]
+
+	v2.Handle("/users/{id}", users).Methods("GET", "DELETE")
+	v2.Handle("/users/{action}", users).Methods("POST")
+	v2.Handle("/users/{id}/{action:credentials}", users).Methods("POST", "DELETE")
+	v2.Handle("/users", users).Methods("GET")
+
+	v2.Handle("/books/{action:search}", books).Methods("GET")
+	v2.Handle("/books/{id}", books).Methods("GET", "DELETE")
+	v2.Handle("/books/{action}", books).Methods("POST")
+	v2.Handle("/books", books).Methods("GET")
+
+	v2.Handle("/story/{action}/{id}", story).Methods("GET", "PUT", "DELETE")
+	v2.Handle("/story", story).Methods("GET", "POST")
+	return v2, repo
+}
+
+// seed заполняет библиотеку книгами, пользователями и покупками
+func seed(t *testing.T, h http.Handler) {
+	t.Helper()
+	for i := range seeded {
+		mustDo(t, h, "POST", "/api/v2/books/add", url.Values{
+			"name": {fmt.Sprintf("Book %d", i)}, "author": {"Author"}, "price": {"100"},
+		})
+		mustDo(t, h, "POST", "/api/v2/users/add", url.Values{
+			"name": {fmt.Sprintf("User %d", i)}, "surname": {"Surname"},
+		})
+	}
+	// Пользователи нумеруются с нуля, книги с единицы
+	for i := range seeded {
+		mustDo(t, h, "POST", "/api/v2/story", url.Values{
+			"book_id": {fmt.Sprint(i + 1)}, "user_id": {fmt.Sprint(i)},
+		})
+	}
+}
+
+// do выполняет запрос к h. Тело form отправляется формой, изменения
+// проходят с If-Match: *, чтобы параллельные запросы не спорили о версии.
+func do(h http.Handler, method, target string, form url.Values) *httptest.ResponseRecorder {
+	ifMatch := ""
+	if method != "GET" {
+		ifMatch = "*"
+	}
+	return doIfMatch(h, method, target, form, ifMatch)
+}
+
+// doIfMatch выполняет запрос с заголовком If-Match: ifMatch, пустая строка
+// отправляет запрос без заголовка
+func doIfMatch(h http.Handler, method, target string, form url.Values, ifMatch string) *httptest.ResponseRecorder {
+	// http.NewRequest, а не httptest.NewRequest: тот разбирает запрос через
+	// общий пул, который тоже упорядочивает горутины
+	r, _ := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// mustDo выполняет запрос и останавливает тест, если ответ не 200
+func mustDo(t *testing.T, h http.Handler, method, target string, form url.Values) {
+	t.Helper()
+	if w := do(h, method, target, form); w.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %s", method, target, w.Code, w.Body)
+	}
+}
+
+// route один маршрут под нагрузкой: i-й запрос и допустимые ответы
+type route struct {
+	name    string
+	method  string
+	target  func(i int) string
+	form    func(i int) url.Values
+	allowed []int
+	// workers и rounds для дорогого маршрута; 0 означает значения по умолчанию
+	workers, rounds int
+}
+
+// hammer запускает все маршруты одновременно, каждый в workers горутинах
+// по rounds запросов. Горутины всех маршрутов стартуют вместе, а не в
+// параллельных подтестах: число параллельных подтестов ограничено -parallel,
+// и на одном процессоре они шли бы по очереди. Гонки ищет детектор
+// (go test -race), сам тест проверяет коды ответов и то, что ответы JSON
+// разбираются. Возвращает число ответов 200 по именам маршрутов.
+func hammer(t *testing.T, h http.Handler, routes []route) map[string]int {
+	var wg sync.WaitGroup
+	succeeded := make([]atomic.Int64, len(routes))
+	for r, rt := range routes {
+		n, m := cmp.Or(rt.workers, workers), cmp.Or(rt.rounds, rounds)
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range m {
+					k := i*m + j
+					var form url.Values
+					if rt.form != nil {
+						form = rt.form(k)
+					}
+					target := rt.target(k)
+					w := do(h, rt.method, target, form)
+					if !slices.Contains(rt.allowed, w.Code) {
+						t.Errorf("%s: %s %s: status %d: %s", rt.name, rt.method, target, w.Code, w.Body)
+						return
+					}
+					if w.Code == http.StatusOK {
+						succeeded[r].Add(1)
+					}
+					if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") && !json.Valid(w.Body.Bytes()) {
+						t.Errorf("%s: %s %s: invalid JSON: %s", rt.name, rt.method, target, w.Body)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	res := make(map[string]int, len(routes))
+	for r, rt := range routes {
+		res[rt.name] = int(succeeded[r].Load())
+	}
+	return res
+}
+
+// checkSaved проверяет сохраненную коллекцию после нагрузки: идентификаторы
+// записей не повторяются, NextId больше любого из них, а записей ровно want.
+// Записи лежат в сохраненном объекте под ключом с именем коллекции.
+// Отрицательный want отключает проверку числа записей.
+func checkSaved(t *testing.T, repo repository.Repository, collection string, want int) {
+	t.Helper()
+	var saved map[string]json.RawMessage
+	if err := repo.Load(context.Background(), collection, &saved); err != nil {
+		t.Fatal(err)
+	}
+	var items []struct {
+		Id int `json:"id"`
+	}
+	var nextId int
+	if err := json.Unmarshal(saved[collection], &items); err != nil {
+		t.Fatalf("%s: %v", collection, err)
+	}
+	if err := json.Unmarshal(saved["next_id"], &nextId); err != nil {
+		t.Fatalf("%s: next_id: %v", collection, err)
+	}
+
+	if want >= 0 && len(items) != want {
+		t.Errorf("%s: %d records saved, want %d", collection, len(items), want)
+	}
+	seen := make(map[int]bool, len(items))
+	for _, item := range items {
+		if seen[item.Id] {
+			t.Errorf("%s: id %d saved twice", collection, item.Id)
+		}
+		seen[item.Id] = true
+		if item.Id >= nextId {
+			t.Errorf("%s: id %d is not below next_id %d", collection, item.Id, nextId)
+		}
+	}
+}
+
+// path возвращает target, одинаковый для всех запросов
+func path(target string) func(int) string {
+	return func(int) string { return target }
+}
+
+// pathId подставляет в target id записи, свой для каждого запроса
+func pathId(target string, first int) func(int) string {
+	return func(i int) string { return fmt.Sprintf(target, first+i%seeded) }
+}
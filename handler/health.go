@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"restapi/health"
+	"restapi/utils"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIVersions версии API, которые обслуживает сервер
+var APIVersions = []string{"v1", "v2"}
+
+// HealthHandler обработчик проверок для оркестратора. Маршруты не требуют
+// ключа API и не ограничиваются по частоте.
+type HealthHandler struct {
+	Health  *health.Health
+	Version string
+}
+
+// LivenessStatus ответ проверки жизнеспособности
+// @Description Процесс сервера жив и обрабатывает запросы
+type LivenessStatus struct {
+	Status string `json:"status" example:"ok"`
+	Uptime string `json:"uptime" example:"1h2m3s"`
+}
+
+// NewHealthHandler создает обработчик проверок h для сервера версии version
+func NewHealthHandler(h *health.Health, version string) http.Handler {
+	return &HealthHandler{Health: h, Version: version}
+}
+
+// ServeHTTP обрабатывает /healthz, /readyz и /version
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Ответы проверок всегда актуальны
+	w.Header().Set("Cache-Control", "no-store")
+	switch mux.Vars(r)["action"] {
+	case "healthz":
+		h.Liveness(w, r)
+	case "readyz":
+		h.Readiness(w, r)
+	case "version":
+		h.BuildInfo(w, r)
+	default:
+		utils.ErrNotFoundApi(w, r)
+	}
+}
+
+// SetDraining переводит проверку готовности в отказ на время остановки сервера
+func (h *HealthHandler) SetDraining() {
+	h.Health.SetDraining()
+}
+
+// Liveness отвечает 200, пока процесс способен обрабатывать запросы.
+// Состояние хранилища не проверяется: его сбой не лечится перезапуском.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(LivenessStatus{
+		Status: health.StatusOK,
+		Uptime: h.Health.Uptime().Truncate(time.Second).String(),
+	}))
+}
+
+// Readiness проверяет, что каждое хранилище читается и принимает запись.
+// При сбое любой проверки или во время остановки отвечает 503. Сервер,
+// запущенный только на чтение, остается готовым со статусом degraded.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Health.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK && report.Status != health.StatusDegraded {
+		status = http.StatusServiceUnavailable
+	}
+	utils.WriteJSON(w, r, status, utils.MarshalValue(report))
+}
+
+// BuildInfo возвращает версию, коммит и время сборки, версию Go и версии API
+func (h *HealthHandler) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(health.BuildVersion(h.Version, APIVersions, h.Health.Uptime())))
+}
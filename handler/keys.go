@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"restapi/auth"
+	"restapi/utils"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyHandler обработчик HTTP запросов для управления ключами API
+// @Description Выпуск, просмотр и отзыв ключей API
+type KeyHandler struct {
+	Keys *auth.KeyStore
+}
+
+// CreatedKey выпущенный ключ
+// @Description Описание нового ключа и сам ключ. Ключ показывается только один раз.
+type CreatedKey struct {
+	auth.KeyInfo
+	Token string `json:"token" example:"lk_Zx81Qm3rVYb0c2kP9n4T7wLd5sHfA6jE1gUoRiXyC8M"`
+}
+
+// NewKeyHandler создает обработчик ключей API
+func NewKeyHandler(keys *auth.KeyStore) http.Handler {
+	return &KeyHandler{Keys: keys}
+}
+
+// ServeHTTP обрабатывает входящие HTTP запросы для ключей
+func (h *KeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		h.ListKeys(w, r)
+	case r.Method == http.MethodGet:
+		h.GetKey(w, r, id)
+	case r.Method == http.MethodPost && id == "":
+		h.CreateKey(w, r)
+	case r.Method == http.MethodDelete && id != "":
+		h.RevokeKey(w, r, id)
+	default:
+		utils.ErrNotFoundApi(w, r)
+	}
+}
+
+// ListKeys возвращает все ключи API
+// @Summary Получить ключи API
+// @Description Возвращает описания всех ключей, включая отозванные и истекшие. Требует право admin:keys.
+// @Tags keys
+// @Produce json
+// @Success 200 {array} auth.KeyInfo "Ключи API"
+// @Failure 403 {object} utils.Problem "Нет права admin:keys"
+// @Router /keys [get]
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(h.Keys.List()))
+}
+
+// GetKey возвращает ключ API по идентификатору
+// @Summary Получить ключ API
+// @Description Возвращает описание ключа без секрета. Требует право admin:keys.
+// @Tags keys
+// @Produce json
+// @Param id path string true "ID ключа"
+// @Success 200 {object} auth.KeyInfo "Ключ API"
+// @Failure 403 {object} utils.Problem "Нет права admin:keys"
+// @Failure 404 {object} utils.Problem "Ключ не найден"
+// @Router /keys/{id} [get]
+func (h *KeyHandler) GetKey(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := h.Keys.Get(id)
+	if err != nil {
+		writeKeyError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(key))
+}
+
+// CreateKey выпускает новый ключ API
+// @Summary Выпустить ключ API
+// @Description Создает ключ с указанными правами. Права перечисляются через запятую
+// @Description в виде действие:ресурс (read, write, delete, admin и books, users, story, keys),
+// @Description звездочка заменяет любое действие или ресурс. Ключ возвращается только в этом ответе.
+// @Tags keys
+// @Accept application/x-www-form-urlencoded,json
+// @Produce json
+// @Param name formData string true "Имя ключа" example(frontend)
+// @Param scopes formData string true "Права ключа" example(read:books,write:story)
+// @Param expires_in formData string false "Срок действия, например 720h"
+// @Param expires_at formData string false "Момент истечения в RFC 3339"
+// @Success 201 {object} CreatedKey "Новый ключ"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 403 {object} utils.Problem "Нет права admin:keys"
+// @Router /keys [post]
+func (h *KeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	name := form.Get("name")
+	if name == "" {
+		badRequest(w, r, utils.CodeInvalidBody, "Key name is required")
+		return
+	}
+	scopes, err := auth.ParseScopes(form.Get("scopes"))
+	if err != nil {
+		writeKeyError(w, r, err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if v := form.Get("expires_in"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			badRequest(w, r, utils.CodeInvalidBody, "expires_in must be a positive duration such as 720h")
+			return
+		}
+		t := time.Now().Add(d).UTC()
+		expiresAt = &t
+	} else if v := form.Get("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			badRequest(w, r, utils.CodeInvalidBody, "expires_at must be an RFC 3339 timestamp")
+			return
+		}
+		t = t.UTC()
+		expiresAt = &t
+	}
+
+	key, token, err := h.Keys.Create(r.Context(), name, scopes, expiresAt)
+	if err != nil {
+		writeKeyError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, r, http.StatusCreated, utils.MarshalValue(CreatedKey{KeyInfo: key, Token: token}))
+}
+
+// RevokeKey отзывает ключ API
+// @Summary Отозвать ключ API
+// @Description Ключ перестает приниматься сразу, запись о нем остается. Требует право admin:keys.
+// @Tags keys
+// @Produce json
+// @Param id path string true "ID ключа"
+// @Success 200 {object} auth.KeyInfo "Отозванный ключ"
+// @Failure 403 {object} utils.Problem "Нет права admin:keys"
+// @Failure 404 {object} utils.Problem "Ключ не найден"
+// @Router /keys/{id} [delete]
+func (h *KeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := h.Keys.Revoke(r.Context(), id)
+	if err != nil {
+		writeKeyError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(key))
+}
+
+// writeKeyError отправляет ошибку хранилища ключей
+func writeKeyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeKeyNotFound, err.Error()))
+	case errors.Is(err, auth.ErrInvalidScope):
+		badRequest(w, r, utils.CodeInvalidScope, err.Error())
+	default:
+		writeError(w, r, err)
+	}
+}
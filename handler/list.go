@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"restapi/model"
+	"restapi/utils"
+	"strconv"
+	"strings"
+)
+
+// listParams параметры строки запроса, включающие постраничную выдачу.
+// Без них коллекции отдаются целиком той же формой, одной страницей.
+var listParams = []string{"limit", "offset", "cursor", "sort"}
+
+// wantsList проверяет, запросил ли клиент постраничную выдачу или фильтры
+func wantsList(r *http.Request, filters ...string) bool {
+	query := r.URL.Query()
+	for _, name := range append(listParams, filters...) {
+		if query.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePage разбирает limit, offset, cursor и sort. Сортировка по убыванию
+// задается минусом перед именем поля: sort=-price.
+func parsePage(r *http.Request) (model.Page, error) {
+	query := r.URL.Query()
+	var p model.Page
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if p.Limit, err = strconv.Atoi(v); err != nil || p.Limit < 0 {
+			return p, fmt.Errorf("%w: limit must be a positive integer", model.ErrInvalidQuery)
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil {
+			return p, fmt.Errorf("%w: offset must be an integer", model.ErrInvalidQuery)
+		}
+	}
+	p.Cursor = query.Get("cursor")
+	p.Sort, p.Desc = strings.CutPrefix(query.Get("sort"), "-")
+	return p, nil
+}
+
+func queryInt(r *http.Request, name string) (*int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an integer", model.ErrInvalidQuery, name)
+	}
+	return &n, nil
+}
+
+func queryFloat(r *http.Request, name string) (*float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be a number", model.ErrInvalidQuery, name)
+	}
+	return &n, nil
+}
+
+func queryBool(r *http.Request, name string) (*bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be true or false", model.ErrInvalidQuery, name)
+	}
+	return &b, nil
+}
+
+// writeList отправляет страницу коллекции с заголовками X-Total-Count и Link
+func writeList(w http.ResponseWriter, r *http.Request, list any, p model.Page, total int, next string) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if link := pageLinks(r, p, total, next); link != "" {
+		w.Header().Set("Link", link)
+	}
+	utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(list))
+}
+
+// pageLinks строит заголовок Link (RFC 8288) со ссылками на соседние страницы
+func pageLinks(r *http.Request, p model.Page, total int, next string) string {
+	link := func(rel string, set func(url.Values)) string {
+		u := *r.URL
+		query := u.Query()
+		query.Del("cursor")
+		query.Del("offset")
+		set(query)
+		u.RawQuery = query.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	var links []string
+	if p.Cursor != "" {
+		// Курсорная выдача умеет идти только вперед
+		if next != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", next) }))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	offset := func(n int) func(url.Values) {
+		return func(q url.Values) { q.Set("offset", strconv.Itoa(n)) }
+	}
+	links = append(links, link("first", offset(0)))
+	if p.Offset > 0 {
+		links = append(links, link("prev", offset(max(p.Offset-p.Limit, 0))))
+	}
+	if p.Offset+p.Limit < total {
+		links = append(links, link("next", offset(p.Offset+p.Limit)))
+		links = append(links, link("last", offset((total-1)/p.Limit*p.Limit)))
+	}
+	return strings.Join(links, ", ")
+}
@@ -1,15 +1,68 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"restapi/auth"
+	"restapi/metrics"
+	"restapi/model"
+	"restapi/repository"
 )
 
 type HandlerManager map[string]http.Handler
 
-func NewHandlerManager() HandlerManager {
-	return HandlerManager{
-		"books": NewBookHandler(),
-		"users": NewUserHandler(),
-		"story": NewPurchaseHandler(),
+// NewHandlerManager создает обработчики, работающие с переданным хранилищем.
+// Одни и те же обработчики можно запускать поверх файлов, памяти или SQL базы.
+// Если данные не удалось загрузить, вместе с ошибкой возвращаются обработчики
+// над тем, что прочитано, их можно запустить только на чтение.
+func NewHandlerManager(repo repository.Repository, policy model.DeletePolicy, sessions *auth.Sessions) (HandlerManager, error) {
+	books, booksErr := model.BooksInit(repo)
+	users, usersErr := model.UsersInit(repo)
+	story, storyErr := model.StoryInit(repo)
+	handlers, err := NewHandlerManagerFor(books, users, story, policy, sessions)
+	return handlers, errors.Join(booksErr, usersErr, storyErr, err)
+}
+
+// NewHandlerManagerFor создает обработчики поверх готовых реализаций моделей,
+// например, построчного хранилища SQLite. Ссылки покупок на книги и пользователей
+// проверяются, удаление подчиняется политике policy. Книги индексируются
+// для полнотекстового поиска. Пользователи входят по JWT через sessions,
+// их права определяются текущей ролью из users. Ошибка построения поискового
+// индекса возвращается вместе с готовыми обработчиками, как в NewHandlerManager.
+func NewHandlerManagerFor(books model.Books, users model.UserHandler, story model.StoryHandler, policy model.DeletePolicy, sessions *auth.Sessions) (HandlerManager, error) {
+	books, users, story = model.WithIntegrity(books, users, story, policy)
+	searchable, err := model.WithSearch(books)
+	observeLibrary(books, users, story)
+	if sessions != nil {
+		sessions.ResolveRoles(func(ctx context.Context, userId int) (string, error) {
+			u, err := users.FindUser(ctx, userId)
+			return u.Role, err
+		})
 	}
+	return HandlerManager{
+		"books": NewBookHandler(searchable),
+		"users": NewUserHandler(users, sessions),
+		"auth":  NewAuthHandler(sessions),
+		"story": NewPurchaseHandler(story),
+	}, err
+}
+
+// observeLibrary публикует число книг, пользователей и открытых аренд в метриках.
+// Показатели снимаются вне запроса, поэтому не попадают в трассировку.
+func observeLibrary(books model.Books, users model.UserHandler, story model.StoryHandler) {
+	ctx := context.Background()
+	one := model.Page{Limit: 1}
+	open := true
+	metrics.ObserveLibrary(metrics.LibraryStats{
+		Books: func() (int, error) { return books.GetCount(ctx), nil },
+		Users: func() (int, error) {
+			list, err := users.ListUsers(ctx, model.UserQuery{Page: one})
+			return list.Total, err
+		},
+		OpenLoans: func() (int, error) {
+			list, err := story.List(ctx, model.PurchaseQuery{Page: one, Active: &open})
+			return list.Total, err
+		},
+	})
 }
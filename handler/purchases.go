@@ -1,7 +1,7 @@
 package handler
 
 import (
-	"fmt"
+	"io"
 	"net/http"
 	"restapi/model"
 	"restapi/utils"
@@ -20,9 +20,9 @@ type PurchaseHandler struct {
 // @Summary Создать обработчик истории покупок
 // @Description Инициализирует и возвращает новый обработчик для работы с историей покупок/аренды
 // @Return http.Handler готовый обработчик HTTP запросов
-func NewPurchaseHandler() http.Handler {
+func NewPurchaseHandler(story model.StoryHandler) http.Handler {
 	var p PurchaseHandler
-	p.Purchase = model.StoryInit()
+	p.Purchase = story
 
 	return &p
 }
@@ -47,24 +47,26 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		idStr, ok := mux.Vars(r)["id"]
 		if !ok {
 			if idStr == "" {
-				w.Write(h.Purchase.GetAll())
+				h.GetAllPurchases(w, r)
 				return
 			}
 		}
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			badRequest(w, r, utils.CodeInvalidId, "Id must be an integer")
+			return
 		}
 		switch mux.Vars(r)["action"] {
 		case "id":
-			w.Header().Add("Content-Type", "Json")
-			w.Write(h.Purchase.GetById(id))
+			if purchase, err := h.Purchase.FindPurchase(r.Context(), id); err != nil {
+				writeError(w, r, err)
+			} else {
+				writeVersioned(w, r, purchase.Version, purchase.UpdatedAt, purchase)
+			}
 		case "book":
-			w.Header().Add("Content-Type", "Json")
-			w.Write(h.Purchase.GetByBook(id))
+			utils.WriteJSON(w, r, http.StatusOK, h.Purchase.GetByBook(r.Context(), id))
 		case "user":
-			w.Header().Add("Content-Type", "Json")
-			w.Write(h.Purchase.GetByUser(id))
+			utils.WriteJSON(w, r, http.StatusOK, h.Purchase.GetByUser(r.Context(), id))
 		default:
 			utils.ErrNotFoundApi(w, r)
 		}
@@ -75,18 +77,23 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			utils.ErrNotFoundApi(w, r)
+			badRequest(w, r, utils.CodeInvalidId, "Id must be an integer")
+			return
 		}
 		switch mux.Vars(r)["action"] {
 		case "update":
 			h.UpdatePurchase(w, r, id)
 		case "endpurchase":
-			err := h.Purchase.EndPurchase(id)
+			version, ok := ifMatch(w, r)
+			if !ok {
+				return
+			}
+			err := h.Purchase.EndPurchase(r.Context(), id, version)
 			if err != nil {
-				utils.ErrUpdatingStorage(w, r)
+				writeError(w, r, err)
 			} else {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("Purchase ended successfully!"))
+				setNextETag(w, version)
+				utils.WriteMessage(w, r, http.StatusOK, "Purchase ended successfully!")
 			}
 		default:
 			utils.ErrNotFoundApi(w, r)
@@ -95,33 +102,37 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			utils.ErrNotFoundApi(w, r)
+			badRequest(w, r, utils.CodeInvalidId, "Id must be an integer")
+			return
 		}
 		switch mux.Vars(r)["action"] {
 		case "id":
-			err := h.Purchase.DelPurchase(id)
+			version, ok := ifMatch(w, r)
+			if !ok {
+				return
+			}
+			err := h.Purchase.DelPurchase(r.Context(), id, version)
 			if err != nil {
-				utils.ErrUpdatingStorage(w, r)
+				writeError(w, r, err)
 			} else {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("Purchase deleted successfully!"))
+				utils.WriteMessage(w, r, http.StatusOK, "Purchase deleted successfully!")
 			}
 		case "book":
-			err := h.Purchase.DelPurchaseByBook(id)
+			err := h.Purchase.DelPurchaseByBook(r.Context(), id)
 			if err != nil {
-				utils.ErrUpdatingStorage(w, r)
+				writeError(w, r, err)
 			} else {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("Purchase deleted successfully!"))
+				utils.WriteMessage(w, r, http.StatusOK, "Purchase deleted successfully!")
 			}
 		case "user":
-			err := h.Purchase.DelPurchaseByUser(id)
+			err := h.Purchase.DelPurchaseByUser(r.Context(), id)
 			if err != nil {
-				utils.ErrUpdatingStorage(w, r)
+				writeError(w, r, err)
 			} else {
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("Purchase deleted successfully!"))
+				utils.WriteMessage(w, r, http.StatusOK, "Purchase deleted successfully!")
 			}
+		default:
+			utils.ErrNotFoundApi(w, r)
 		}
 	}
 }
@@ -130,96 +141,157 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // @Summary Добавить новую покупку/аренду
 // @Description Создает новую запись о покупке или аренде книги пользователем
 // @Tags purchases
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
 // @Param book_id formData int true "ID книги" example(1)
 // @Param user_id formData int true "ID пользователя" example(1)
 // @Success 200 {string} string "Loan succesfully added"
-// @Failure 400 {object} string "Неверные данные запроса"
-// @Failure 500 {object} string "Внутренняя ошибка сервера"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 500 {object} utils.Problem "Внутренняя ошибка сервера"
 // @Router /story [post]
 func (h *PurchaseHandler) AddPurchase(w http.ResponseWriter, r *http.Request) {
-	bookIdStr, userIdStr := r.FormValue("book_id"), r.FormValue("user_id")
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	bookIdStr, userIdStr := form.Get("book_id"), form.Get("user_id")
 	bookId, err := strconv.Atoi(bookIdStr)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Ошибка при обработке айди книги: %s", err.Error())
+		badRequest(w, r, utils.CodeInvalidId, "book_id must be an integer")
 		return
 	}
 	userId, err := strconv.Atoi(userIdStr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Ошибка при обработке айди пользователя: %s", err.Error())
+		badRequest(w, r, utils.CodeInvalidId, "user_id must be an integer")
 		return
 	}
 	loan := model.Purchase{
 		BookId: bookId,
 		UserId: userId,
 	}
-	err = h.Purchase.AddPurchase(loan)
+	err = h.Purchase.AddPurchase(r.Context(), loan)
 	if err != nil {
-		utils.ErrUpdatingStorage(w, r)
+		writeReferenceError(w, r, err)
 	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Loan succesfully added"))
+		utils.WriteMessage(w, r, http.StatusOK, "Loan succesfully added")
 	}
 }
 
 // UpdatePurchase обновляет информацию о покупке/аренде
 // @Summary Обновить информацию о покупке
-// @Description Обновляет данные существующей записи о покупке или аренде
+// @Description Обновляет данные существующей записи о покупке или аренде, если она не изменилась с чтения.
+// @Description Новый ETag записи возвращается в заголовке ответа.
 // @Tags purchases
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
 // @Param id path int true "ID записи о покупке" example(1)
+// @Param If-Match header string true "ETag записи из GET /story/id/{id} или *"
 // @Param book_id formData int false "Новый ID книги" example(2)
 // @Param user_id formData int false "Новый ID пользователя" example(3)
 // @Success 200 {string} string "Loan succesfully updated"
-// @Failure 404 {object} string "Запись не найдена"
-// @Failure 400 {object} string "Неверные данные запроса"
-// @Failure 500 {object} string "Внутренняя ошибка сервера"
+// @Header 200 {string} ETag "Новая версия записи"
+// @Failure 404 {object} utils.Problem "Запись не найдена"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 412 {object} utils.Problem "Запись изменилась"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Внутренняя ошибка сервера"
 // @Router /story/update/{id} [put]
 func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request, id int) {
-	bookIdStr, userIdStr := r.FormValue("book_id"), r.FormValue("user_id")
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	bookIdStr, userIdStr := form.Get("book_id"), form.Get("user_id")
 	bookId, err := strconv.Atoi(bookIdStr)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Ошибка при обработке айди книги: %s", err.Error())
+		badRequest(w, r, utils.CodeInvalidId, "book_id must be an integer")
 		return
 	}
 	userId, err := strconv.Atoi(userIdStr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Ошибка при обработке айди пользователя: %s", err.Error())
+		badRequest(w, r, utils.CodeInvalidId, "user_id must be an integer")
+		return
+	}
+	version, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 	loan := model.Purchase{
-		Id:     id,
-		BookId: bookId,
-		UserId: userId,
+		Id:      id,
+		BookId:  bookId,
+		UserId:  userId,
+		Version: version,
 	}
-	err = h.Purchase.UpdatePurchase(loan)
+	err = h.Purchase.UpdatePurchase(r.Context(), loan)
 	if err != nil {
-		utils.ErrUpdatingStorage(w, r)
+		writeReferenceError(w, r, err)
 	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Loan succesfully updated"))
+		setNextETag(w, version)
+		utils.WriteMessage(w, r, http.StatusOK, "Loan succesfully updated")
 	}
 }
 
-// Также добавьте эти методы с аннотациями (если они есть в вашем коде):
-
 // GetAllPurchases возвращает все записи о покупках
 // @Summary Получить все покупки
-// @Description Возвращает полный список всех записей о покупках/аренде
+// @Description Возвращает записи о покупках/аренде. Без параметров выборки история отдается целиком одной страницей,
+// @Description с любым из них ответ постраничный, с заголовками X-Total-Count и Link.
 // @Tags purchases
 // @Accept json
 // @Produce json
-// @Success 200 {array} model.Purchase "Список всех покупок"
+// @Param limit query int false "Размер страницы" default(50) maximum(1000)
+// @Param offset query int false "Смещение от начала выборки"
+// @Param cursor query string false "Курсор следующей страницы из next_cursor"
+// @Param sort query string false "Поле сортировки: id, book_id, user_id, start_at, end_at; минус для убывания" example(-start_at)
+// @Param book_id query int false "ID книги"
+// @Param user_id query int false "ID пользователя"
+// @Param active query bool false "true: только открытые аренды, false: только завершенные"
+// @Success 200 {object} model.PurchaseList "Страница покупок"
+// @Failure 400 {object} utils.Problem "Неверные параметры выборки"
 // @Router /story [get]
-// Примечание: Этот метод обрабатывается в ServeHTTP
+func (h *PurchaseHandler) GetAllPurchases(w http.ResponseWriter, r *http.Request) {
+	if !wantsList(r, "book_id", "user_id", "active") {
+		err := utils.StreamJSON(w, r, http.StatusOK, func(body io.Writer) error {
+			return h.Purchase.WriteAll(r.Context(), body)
+		})
+		if err != nil {
+			writeError(w, r, err)
+		}
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := model.PurchaseQuery{Page: page}
+	if q.BookId, err = queryInt(r, "book_id"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if q.UserId, err = queryInt(r, "user_id"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if q.Active, err = queryBool(r, "active"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := h.Purchase.List(r.Context(), q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page.Limit, page.Offset = list.Limit, list.Offset
+	writeList(w, r, list, page, list.Total, list.NextCursor)
+}
+
+// Также добавьте эти методы с аннотациями (если они есть в вашем коде):
 
 // GetPurchaseById возвращает запись о покупке по ID
 // @Summary Получить покупку по ID
@@ -228,8 +300,12 @@ func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request,
 // @Accept json
 // @Produce json
 // @Param id path int true "ID покупки" example(1)
+// @Param If-None-Match header string false "ETag известной клиенту версии"
 // @Success 200 {object} model.Purchase "Информация о покупке"
-// @Failure 404 {object} string "Покупка не найдена"
+// @Header 200 {string} ETag "Версия покупки"
+// @Header 200 {string} Last-Modified "Время последнего изменения"
+// @Success 304 "Покупка не изменилась"
+// @Failure 404 {object} utils.Problem "Покупка не найдена"
 // @Router /story/id/{id} [get]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
@@ -241,7 +317,7 @@ func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request,
 // @Produce json
 // @Param id path int true "ID книги" example(1)
 // @Success 200 {array} model.Purchase "Список покупок для книги"
-// @Failure 404 {object} string "Книга не найдена"
+// @Failure 404 {object} utils.Problem "Книга не найдена"
 // @Router /story/book/{id} [get]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
@@ -253,33 +329,40 @@ func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request,
 // @Produce json
 // @Param id path int true "ID пользователя" example(1)
 // @Success 200 {array} model.Purchase "Список покупок пользователя"
-// @Failure 404 {object} string "Пользователь не найдена"
+// @Failure 404 {object} utils.Problem "Пользователь не найдена"
 // @Router /story/user/{id} [get]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
 // EndPurchase завершает покупку/аренду
 // @Summary Завершить покупку
-// @Description Отмечает покупку/аренду как завершенную
+// @Description Отмечает покупку/аренду как завершенную, если она не изменилась с чтения
 // @Tags purchases
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID покупки" example(1)
+// @Param If-Match header string true "ETag покупки из GET /story/id/{id} или *"
 // @Success 200 {string} string "Purchase ended successfully!"
-// @Failure 404 {object} string "Покупка не найдена"
-// @Failure 500 {object} string "Ошибка обновления"
+// @Header 200 {string} ETag "Новая версия покупки"
+// @Failure 404 {object} utils.Problem "Покупка не найдена"
+// @Failure 412 {object} utils.Problem "Покупка изменилась"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка обновления"
 // @Router /story/endpurchase/{id} [put]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
 // DeletePurchase удаляет запись о покупке по ID
 // @Summary Удалить покупку по ID
-// @Description Удаляет запись о покупке по её идентификатору
+// @Description Удаляет запись о покупке по её идентификатору, если она не изменилась с чтения
 // @Tags purchases
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID покупки" example(1)
+// @Param If-Match header string true "ETag покупки из GET /story/id/{id} или *"
 // @Success 200 {string} string "Purchase deleted successfully!"
-// @Failure 404 {object} string "Покупка не найдена"
-// @Failure 500 {object} string "Ошибка удаления"
+// @Failure 404 {object} utils.Problem "Покупка не найдена"
+// @Failure 412 {object} utils.Problem "Покупка изменилась"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка удаления"
 // @Router /story/id/{id} [delete]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
@@ -288,11 +371,11 @@ func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request,
 // @Description Удаляет все записи о покупках/аренде для указанной книги
 // @Tags purchases
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID книги" example(1)
 // @Success 200 {string} string "Purchase deleted successfully!"
-// @Failure 404 {object} string "Книга не найдена"
-// @Failure 500 {object} string "Ошибка удаления"
+// @Failure 404 {object} utils.Problem "Книга не найдена"
+// @Failure 500 {object} utils.Problem "Ошибка удаления"
 // @Router /story/book/{id} [delete]
 // Примечание: Этот метод обрабатывается в ServeHTTP
 
@@ -301,10 +384,10 @@ func (h *PurchaseHandler) UpdatePurchase(w http.ResponseWriter, r *http.Request,
 // @Description Удаляет все записи о покупках/аренде для указанного пользователя
 // @Tags purchases
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID пользователя" example(1)
 // @Success 200 {string} string "Purchase deleted successfully!"
-// @Failure 404 {object} string "Пользователь не найдена"
-// @Failure 500 {object} string "Ошибка удаления"
+// @Failure 404 {object} utils.Problem "Пользователь не найдена"
+// @Failure 500 {object} utils.Problem "Ошибка удаления"
 // @Router /story/user/{id} [delete]
 // Примечание: Этот метод обрабатывается в ServeHTTP
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"restapi/repository"
+	"testing"
+)
+
+// TestPurchaseRoutesParallel обращается ко всем маршрутам истории одновременно
+func TestPurchaseRoutesParallel(t *testing.T) {
+	h, repo := newTestRouter(t)
+	seed(t, h)
+
+	ok, gone := []int{http.StatusOK}, []int{http.StatusOK, http.StatusNotFound}
+	// Новая покупка может сослаться на книгу, которая уже выдана или удалена
+	refs := []int{http.StatusOK, http.StatusNotFound, http.StatusConflict}
+	loan := func(i int) url.Values {
+		return url.Values{"book_id": {fmt.Sprint(1 + i%seeded)}, "user_id": {fmt.Sprint(i % seeded)}}
+	}
+	hammer(t, h, []route{
+		{name: "list", method: "GET", target: path("/api/v2/story"), allowed: ok},
+		{name: "page", method: "GET", target: path("/api/v2/story?limit=5&active=true"), allowed: ok},
+		{name: "get", method: "GET", target: pathId("/api/v2/story/id/%d", 0), allowed: gone},
+		{name: "by_book", method: "GET", target: pathId("/api/v2/story/book/%d", 1), allowed: ok},
+		{name: "by_user", method: "GET", target: pathId("/api/v2/story/user/%d", 0), allowed: ok},
+		{name: "add", method: "POST", target: path("/api/v2/story"), form: loan, allowed: refs},
+		{name: "update", method: "PUT", target: pathId("/api/v2/story/update/%d", 0), form: loan, allowed: refs},
+		{name: "end", method: "PUT", target: pathId("/api/v2/story/endpurchase/%d", 0), allowed: refs},
+		{name: "delete", method: "DELETE", target: pathId("/api/v2/story/id/%d", seeded/2), allowed: gone},
+		{name: "delete_by_book", method: "DELETE", target: pathId("/api/v2/story/book/%d", seeded/2), allowed: gone},
+		{name: "delete_by_user", method: "DELETE", target: pathId("/api/v2/story/user/%d", seeded/2), allowed: gone},
+	})
+	// Удаление по книге и пользователю стирает неизвестное число покупок,
+	// поэтому их число проверяет TestPurchaseCountParallel
+	checkSaved(t, repo, repository.Purchases, -1)
+}
+
+// TestPurchaseCountParallel добавляет и удаляет покупки одновременно с
+// чтением и проверяет, что сохранилось ровно столько, сколько должно
+func TestPurchaseCountParallel(t *testing.T) {
+	h, repo := newTestRouter(t)
+	seed(t, h)
+
+	ok, gone := []int{http.StatusOK}, []int{http.StatusOK, http.StatusNotFound}
+	succeeded := hammer(t, h, []route{
+		{name: "list", method: "GET", target: path("/api/v2/story"), allowed: ok},
+		{name: "add", method: "POST", target: path("/api/v2/story"), allowed: []int{http.StatusOK, http.StatusConflict},
+			form: func(i int) url.Values {
+				return url.Values{"book_id": {fmt.Sprint(1 + i%seeded)}, "user_id": {fmt.Sprint(i % seeded)}}
+			}},
+		{name: "delete", method: "DELETE", target: pathId("/api/v2/story/id/%d", seeded/2), allowed: gone},
+	})
+	checkSaved(t, repo, repository.Purchases, seeded+succeeded["add"]-succeeded["delete"])
+}
@@ -1,7 +1,11 @@
 package handler
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"restapi/auth"
+	"restapi/logging"
 	"restapi/model"
 	"restapi/utils"
 	"strconv"
@@ -13,15 +17,18 @@ import (
 // @Description Обработчик для работы с данными пользователей
 type UserHandler struct {
 	User model.UserHandler
+	// Sessions учетные данные пользователей для входа по JWT
+	Sessions *auth.Sessions
 }
 
 // NewUserHandler создает новый экземпляр UserHandler
 // @Summary Создать обработчик пользователей
 // @Description Инициализирует и возвращает новый обработчик для работы с пользователями
 // @Return http.Handler готовый обработчик HTTP запросов
-func NewUserHandler() http.Handler {
+func NewUserHandler(users model.UserHandler, sessions *auth.Sessions) http.Handler {
 	var u UserHandler
-	u.User = model.UsersInit()
+	u.User = users
+	u.Sessions = sessions
 	return &u
 }
 
@@ -33,6 +40,8 @@ func NewUserHandler() http.Handler {
 // @Router /users/add [post]
 // @Router /users/update [post]
 // @Router /users/{id} [delete]
+// @Router /users/{id}/credentials [post]
+// @Router /users/{id}/credentials [delete]
 func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -44,14 +53,20 @@ func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		act := mux.Vars(r)["action"]
 		switch act {
+		case "credentials":
+			h.SetCredentials(w, r, mux.Vars(r)["id"])
 		case "add":
 			h.AddUser(w, r)
 		case "update":
 			h.UpdateUser(w, r)
+		default:
+			utils.ErrNotFoundApi(w, r)
 		}
 	case http.MethodDelete:
 		id, ok := mux.Vars(r)["id"]
-		if ok {
+		if ok && mux.Vars(r)["action"] == "credentials" {
+			h.RemoveCredentials(w, r, id)
+		} else if ok {
 			h.RemoveUser(w, r, id)
 		} else {
 			utils.ErrNotFoundApi(w, r)
@@ -66,60 +81,130 @@ func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path int true "ID пользователя" minimum(1)
+// @Param If-None-Match header string false "ETag известной клиенту версии"
 // @Success 200 {object} model.User "Информация о пользователе"
-// @Failure 404 {object} string "Пользователь не найден"
-// @Failure 400 {object} string "Неверный ID"
+// @Header 200 {string} ETag "Версия пользователя"
+// @Header 200 {string} Last-Modified "Время последнего изменения"
+// @Success 304 "Пользователь не изменился"
+// @Failure 404 {object} utils.Problem "Пользователь не найден"
+// @Failure 400 {object} utils.Problem "Неверный ID"
 // @Router /users/{id} [get]
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request, idStr string) {
 	if id, err := strconv.Atoi(idStr); err != nil {
-		utils.ErrNotFoundApi(w, r)
+		badRequest(w, r, utils.CodeInvalidId, "User id must be an integer")
+	} else if user, err := h.User.FindUser(r.Context(), id); err != nil {
+		writeError(w, r, err)
 	} else {
-		w.Header().Add("Content-Type", "JSON")
-		w.Write(h.User.GetUser(id))
+		writeVersioned(w, r, user.Version, user.UpdatedAt, user)
 	}
 }
 
 // GetAllUsers возвращает список всех пользователей
 // @Summary Получить всех пользователей
-// @Description Возвращает полный список всех пользователей в системе
+// @Description Возвращает пользователей системы. Без параметров выборки список отдается целиком одной страницей,
+// @Description с любым из них ответ постраничный, с заголовками X-Total-Count и Link.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Success 200 {array} model.User "Список всех пользователей"
+// @Param limit query int false "Размер страницы" default(50) maximum(1000)
+// @Param offset query int false "Смещение от начала выборки"
+// @Param cursor query string false "Курсор следующей страницы из next_cursor"
+// @Param sort query string false "Поле сортировки: id, name, surname; минус для убывания" example(surname)
+// @Param name query string false "Имя пользователя"
+// @Param surname query string false "Фамилия пользователя"
+// @Success 200 {object} model.UserList "Страница пользователей"
+// @Failure 400 {object} utils.Problem "Неверные параметры выборки"
 // @Router /users [get]
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "JSON")
-	w.Write(h.User.GetAllUsers())
+	if !wantsList(r, "name", "surname") {
+		err := utils.StreamJSON(w, r, http.StatusOK, func(body io.Writer) error {
+			return h.User.WriteAllUsers(r.Context(), body)
+		})
+		if err != nil {
+			writeError(w, r, err)
+		}
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := model.UserQuery{
+		Page:    page,
+		Name:    r.URL.Query().Get("name"),
+		Surname: r.URL.Query().Get("surname"),
+	}
+
+	list, err := h.User.ListUsers(r.Context(), q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page.Limit, page.Offset = list.Limit, list.Offset
+	writeList(w, r, list, page, list.Total, list.NextCursor)
 }
 
 // UpdateUser обновляет информацию о пользователе
 // @Summary Обновить пользователя
-// @Description Обновляет данные существующего пользователя
+// @Description Обновляет данные существующего пользователя, если он не изменился с чтения.
+// @Description Новый ETag пользователя возвращается в заголовке ответа.
 // @Tags users
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
+// @Param If-Match header string true "ETag пользователя из GET /users/{id} или *"
 // @Param id formData int true "ID пользователя для обновления" minimum(1)
 // @Param name formData string false "Новое имя пользователя" example("Иван")
 // @Param surname formData string false "Новая фамилия пользователя" example("Иванов")
+// @Param role formData string false "Новая роль, меняет только администратор" Enums(admin, librarian, member)
 // @Success 200 {string} string "User updated successfully!"
-// @Failure 404 {object} string "Пользователь не найден"
-// @Failure 400 {object} string "Неверные данные запроса"
-// @Failure 500 {object} string "Ошибка обновления"
+// @Header 200 {string} ETag "Новая версия пользователя"
+// @Failure 404 {object} utils.Problem "Пользователь не найден"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 403 {object} utils.Problem "Нет права менять роль"
+// @Failure 412 {object} utils.Problem "Пользователь изменился"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка обновления"
 // @Router /users/update [post]
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
 	user := model.User{
-		Name:    r.FormValue("name"),
-		Surname: r.FormValue("surname"),
+		Name:    form.Get("name"),
+		Surname: form.Get("surname"),
 	}
-	if id, err := strconv.Atoi(r.FormValue("id")); err != nil {
-		utils.ErrNotFoundApi(w, r)
-	} else {
-		user.Id = id
-		if err := h.User.UpdateUser(user); err != nil {
-			utils.ErrUpdatingStorage(w, r)
+	if id, err := strconv.Atoi(form.Get("id")); err != nil {
+		badRequest(w, r, utils.CodeInvalidId, "User id is required")
+	} else if version, ok := ifMatch(w, r); ok {
+		user.Id, user.Version = id, version
+		if role := form.Get("role"); role != "" {
+			if user.Role, err = model.ParseRole(role); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			old, err := h.User.FindUser(r.Context(), id)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if old.Role != user.Role && !canAssignRoles(w, r) {
+				return
+			}
+			// Право проверено для прочитанной версии: если роль успели
+			// сменить, обновление получит 412, даже при If-Match: *
+			if user.Version == model.AnyVersion {
+				user.Version = old.Version
+			}
+		}
+		if err := h.User.UpdateUser(r.Context(), user); err != nil {
+			writeError(w, r, err)
 		} else {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("User updated successfully!"))
+			setNextETag(w, user.Version)
+			utils.WriteMessage(w, r, http.StatusOK, "User updated successfully!")
 		}
 	}
 }
@@ -128,50 +213,163 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Summary Добавить нового пользователя
 // @Description Создает нового пользователя в системе
 // @Tags users
-// @Accept application/x-www-form-urlencoded
-// @Produce plain
+// @Accept application/x-www-form-urlencoded,json
+// @Produce plain,json
 // @Param name formData string true "Имя пользователя" example("Алексей")
 // @Param surname formData string true "Фамилия пользователя" example("Петров")
+// @Param role formData string false "Роль, кроме member назначает только администратор" Enums(admin, librarian, member) default(member)
 // @Success 200 {string} string "User added successfully!"
-// @Failure 400 {object} string "Неверные данные запроса"
-// @Failure 409 {object} string "Пользователь уже существует"
-// @Failure 500 {object} string "Ошибка добавления"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 403 {object} utils.Problem "Нет права назначать роль"
+// @Failure 409 {object} utils.Problem "Пользователь уже существует"
+// @Failure 500 {object} utils.Problem "Ошибка добавления"
 // @Router /users/add [post]
 func (h *UserHandler) AddUser(w http.ResponseWriter, r *http.Request) {
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
 	newby := model.User{
-		Name:    r.FormValue("name"),
-		Surname: r.FormValue("surname"),
+		Name:    form.Get("name"),
+		Surname: form.Get("surname"),
 	}
-	err := h.User.AddUser(newby)
+	if newby.Role, err = model.ParseRole(form.Get("role")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if newby.Role != model.RoleMember && !canAssignRoles(w, r) {
+		return
+	}
+	err = h.User.AddUser(r.Context(), newby)
 	if err != nil {
-		utils.ErrUpdatingStorage(w, r)
+		writeError(w, r, err)
 	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("User added successfully!"))
+		utils.WriteMessage(w, r, http.StatusOK, "User added successfully!")
 	}
 }
 
 // RemoveUser удаляет пользователя
 // @Summary Удалить пользователя
-// @Description Удаляет пользователя из системы по его идентификатору
+// @Description Удаляет пользователя из системы по его идентификатору, если он не изменился с чтения
 // @Tags users
 // @Accept json
-// @Produce plain
+// @Produce plain,json
 // @Param id path int true "ID пользователя для удаления" minimum(1)
+// @Param If-Match header string true "ETag пользователя из GET /users/{id} или *"
 // @Success 200 {string} string "User removed successfully!"
-// @Failure 404 {object} string "Пользователь не найден"
-// @Failure 500 {object} string "Ошибка удаления"
+// @Failure 404 {object} utils.Problem "Пользователь не найден"
+// @Failure 409 {object} utils.Problem "На пользователя ссылаются покупки"
+// @Failure 412 {object} utils.Problem "Пользователь изменился"
+// @Failure 428 {object} utils.Problem "Нет заголовка If-Match"
+// @Failure 500 {object} utils.Problem "Ошибка удаления"
 // @Router /users/{id} [delete]
 func (h *UserHandler) RemoveUser(w http.ResponseWriter, r *http.Request, idStr string) {
 	if id, err := strconv.Atoi(idStr); err != nil {
-		utils.ErrNotFoundApi(w, r)
+		badRequest(w, r, utils.CodeInvalidId, "User id must be an integer")
+	} else if version, ok := ifMatch(w, r); !ok {
+		return
+	} else if err := h.User.RemoveUser(r.Context(), id, version); err != nil {
+		writeError(w, r, err)
 	} else {
-		err := h.User.RemoveUser(id)
-		if err != nil {
-			w.Write([]byte(err.Error()))
+		// Удаленный пользователь больше не может войти
+		if h.Sessions != nil {
+			if err := h.Sessions.RemoveCredentials(r.Context(), id); err != nil && !errors.Is(err, auth.ErrCredentialsNotFound) {
+				logging.FromContext(r.Context()).Error("credentials removal error", "user_id", id, "err", err)
+			}
+		}
+		utils.WriteMessage(w, r, http.StatusOK, "User removed successfully!")
+	}
+}
+
+// SetCredentials задает логин и пароль пользователя
+// @Summary Задать учетные данные пользователя
+// @Description Задает логин и пароль, с которыми пользователь входит через /auth/login.
+// @Description Права вошедшего пользователя определяются его ролью. Смена пароля отзывает все токены пользователя.
+// @Tags users
+// @Accept application/x-www-form-urlencoded,json
+// @Produce json
+// @Param id path int true "ID пользователя"
+// @Param login formData string true "Логин" example(ivanov)
+// @Param password formData string true "Пароль, не короче 8 символов"
+// @Success 200 {object} auth.CredentialInfo "Учетные данные без пароля"
+// @Failure 400 {object} utils.Problem "Неверные данные запроса"
+// @Failure 404 {object} utils.Problem "Пользователь не найден"
+// @Failure 409 {object} utils.Problem "Логин занят"
+// @Router /users/{id}/credentials [post]
+func (h *UserHandler) SetCredentials(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		badRequest(w, r, utils.CodeInvalidId, "User id must be an integer")
+		return
+	}
+	if h.Sessions == nil {
+		utils.ErrNotFoundApi(w, r)
+		return
+	}
+	form, err := utils.ParseBody(w, r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	if h.User.GetUser(r.Context(), id) == nil {
+		writeError(w, r, model.ErrUserNotFound)
+		return
+	}
+
+	cred, err := h.Sessions.SetCredentials(r.Context(), id, form.Get("login"), form.Get("password"))
+	switch {
+	case errors.Is(err, auth.ErrLoginRequired), errors.Is(err, auth.ErrWeakPassword):
+		writeBodyError(w, r, err)
+	case errors.Is(err, auth.ErrLoginTaken):
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, utils.CodeLoginTaken, err.Error()))
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		utils.WriteJSON(w, r, http.StatusOK, utils.MarshalValue(cred))
+	}
+}
+
+// RemoveCredentials запрещает пользователю вход по паролю
+// @Summary Удалить учетные данные пользователя
+// @Description Удаляет логин и пароль пользователя, все его токены перестают приниматься
+// @Tags users
+// @Produce plain,json
+// @Param id path int true "ID пользователя"
+// @Success 200 {string} string "Credentials removed successfully!"
+// @Failure 404 {object} utils.Problem "Учетные данные не найдены"
+// @Router /users/{id}/credentials [delete]
+func (h *UserHandler) RemoveCredentials(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		badRequest(w, r, utils.CodeInvalidId, "User id must be an integer")
+		return
+	}
+	if h.Sessions == nil {
+		utils.ErrNotFoundApi(w, r)
+		return
+	}
+	if err := h.Sessions.RemoveCredentials(r.Context(), id); err != nil {
+		if errors.Is(err, auth.ErrCredentialsNotFound) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeUserNotFound, err.Error()))
 		} else {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("User removed successfully!"))
+			writeError(w, r, err)
 		}
+		return
+	}
+	utils.WriteMessage(w, r, http.StatusOK, "Credentials removed successfully!")
+}
+
+// canAssignRoles проверяет право назначать роли и при его отсутствии отправляет 403
+func canAssignRoles(w http.ResponseWriter, r *http.Request) bool {
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if ok && p.Can(auth.AdminUsers) {
+		return true
+	}
+	code := utils.CodeInsufficientScope
+	if p.Type == auth.PrincipalUser {
+		code = utils.CodeInsufficientRole
 	}
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, code, "Assigning roles requires "+auth.AdminUsers))
+	return false
 }
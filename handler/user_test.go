@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"restapi/auth"
+	"restapi/model"
+	"restapi/repository"
+	"restapi/utils"
+	"strings"
+	"testing"
+)
+
+// TestUserRoutesParallel обращается ко всем маршрутам пользователей одновременно
+func TestUserRoutesParallel(t *testing.T) {
+	h, repo := newTestRouter(t)
+	seed(t, h)
+
+	ok, gone := []int{http.StatusOK}, []int{http.StatusOK, http.StatusNotFound}
+	succeeded := hammer(t, h, []route{
+		{name: "list", method: "GET", target: path("/api/v2/users"), allowed: ok},
+		{name: "page", method: "GET", target: path("/api/v2/users?limit=5&sort=-name"), allowed: ok},
+		{name: "get", method: "GET", target: pathId("/api/v2/users/%d", 0), allowed: gone},
+		{name: "add", method: "POST", target: path("/api/v2/users/add"), allowed: ok,
+			form: func(i int) url.Values {
+				return url.Values{"name": {fmt.Sprintf("New %d", i)}, "surname": {"Surname"}}
+			}},
+		{name: "update", method: "POST", target: path("/api/v2/users/update"), allowed: gone,
+			form: func(i int) url.Values {
+				return url.Values{"id": {fmt.Sprint(i % seeded)}, "name": {"Updated"}, "surname": {"Surname"}}
+			}},
+		// Хеширование пароля намеренно медленное, двух запросов достаточно
+		{name: "set_credentials", method: "POST", target: pathId("/api/v2/users/%d/credentials", 0), allowed: gone, workers: 2, rounds: 1,
+			form: func(i int) url.Values {
+				return url.Values{"login": {fmt.Sprintf("user%d", i%seeded)}, "password": {"correct horse"}}
+			}},
+		{name: "remove_credentials", method: "DELETE", target: pathId("/api/v2/users/%d/credentials", 0), allowed: gone},
+		{name: "delete", method: "DELETE", target: pathId("/api/v2/users/%d", seeded/2), allowed: gone},
+	})
+	// Каждый id удаляется успешно не больше одного раза
+	checkSaved(t, repo, repository.Users, seeded+succeeded["add"]-succeeded["delete"])
+}
+
+// racingUsers меняет пользователя сразу после того, как обработчик его
+// прочитал: так между проверкой права и записью вклинивается другой запрос
+type racingUsers struct {
+	model.UserHandler
+	race func(ctx context.Context)
+}
+
+func (u *racingUsers) FindUser(ctx context.Context, id int) (model.User, error) {
+	user, err := u.UserHandler.FindUser(ctx, id)
+	if u.race != nil {
+		u.race(ctx)
+		u.race = nil
+	}
+	return user, err
+}
+
+func TestUpdateUserRoleCheckedForCurrentVersion(t *testing.T) {
+	librarian := auth.Principal{Type: auth.PrincipalUser, UserId: 1, Role: model.RoleLibrarian, Scopes: auth.RoleScopes[model.RoleLibrarian]}
+	admin := auth.Principal{Type: auth.PrincipalUser, UserId: 1, Role: model.RoleAdmin, Scopes: auth.RoleScopes[model.RoleAdmin]}
+	promote := func(ctx context.Context, users model.UserHandler) {
+		users.UpdateUser(ctx, model.User{Id: 0, Name: "User", Surname: "Surname", Role: model.RoleAdmin, Version: model.AnyVersion})
+	}
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		role      string
+		race      bool
+		wantCode  int
+		wantRole  string
+	}{
+		{name: "та же роль", principal: librarian, role: model.RoleMember, wantCode: http.StatusOK, wantRole: model.RoleMember},
+		{name: "смена роли без права", principal: librarian, role: model.RoleAdmin, wantCode: http.StatusForbidden, wantRole: model.RoleMember},
+		{name: "смена роли администратором", principal: admin, role: model.RoleLibrarian, wantCode: http.StatusOK, wantRole: model.RoleLibrarian},
+		// Библиотекарь проверил роль member, а пользователя тем временем
+		// сделали администратором: запись с ролью member сняла бы права
+		{name: "роль сменилась после проверки", principal: librarian, role: model.RoleMember, race: true, wantCode: http.StatusPreconditionFailed, wantRole: model.RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users, err := model.UsersInit(repository.NewMemory())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := users.AddUser(ctx, model.User{Name: "User", Surname: "Surname"}); err != nil {
+				t.Fatal(err)
+			}
+			racing := &racingUsers{UserHandler: users}
+			if tt.race {
+				racing.race = func(ctx context.Context) { promote(ctx, users) }
+			}
+			h := &UserHandler{User: racing}
+
+			form := url.Values{"id": {"0"}, "name": {"Renamed"}, "surname": {"Surname"}, "role": {tt.role}}
+			r := httptest.NewRequest("POST", "/api/v2/users/update", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("If-Match", "*")
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			w := httptest.NewRecorder()
+			h.UpdateUser(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			user, err := users.FindUser(ctx, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != tt.wantRole {
+				t.Errorf("role %q, want %q", user.Role, tt.wantRole)
+			}
+			if tt.wantCode == http.StatusOK && w.Header().Get("ETag") != utils.ETag(user.Version) {
+				t.Errorf("ETag %q, want %q", w.Header().Get("ETag"), utils.ETag(user.Version))
+			}
+		})
+	}
+}
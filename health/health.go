@@ -0,0 +1,154 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"restapi/repository"
+	"sync"
+	"time"
+)
+
+// Статусы проверок
+const (
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusDraining = "draining"
+	StatusDegraded = "degraded"
+)
+
+// CacheTTL сколько переиспользуется результат проверки готовности.
+// Пробы оркестратора и случайные клиенты не нагружают хранилище чаще.
+const CacheTTL = time.Second
+
+// CheckTimeout сколько ждать одну проверку
+const CheckTimeout = 5 * time.Second
+
+// Check проверка одной зависимости, nil означает, что зависимость доступна
+type Check func(ctx context.Context) error
+
+// Result результат одной проверки
+// @Description Состояние зависимости сервера
+type Result struct {
+	Status     string  `json:"status" example:"ok"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms" example:"0.42"`
+}
+
+// Report результат проверки готовности
+// @Description Готовность сервера принимать запросы и состояние каждой зависимости
+type Report struct {
+	Status    string            `json:"status" example:"ok"`
+	Checks    map[string]Result `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health набор проверок готовности сервера
+type Health struct {
+	mu       sync.Mutex
+	checks   []namedCheck
+	draining bool
+	degraded map[string]error
+	last     *Report
+	started  time.Time
+}
+
+// New создает пустой набор проверок
+func New() *Health {
+	return &Health{started: time.Now()}
+}
+
+// Add добавляет проверку name
+func (h *Health) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name, check})
+}
+
+// Uptime сколько работает сервер
+func (h *Health) Uptime() time.Duration {
+	return time.Since(h.started)
+}
+
+// SetDraining помечает сервер останавливающимся: проверка готовности
+// отвечает отказом, чтобы балансировщик перестал присылать запросы
+func (h *Health) SetDraining() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.draining = true
+}
+
+// SetDegraded помечает сервер работающим с ограничениями из-за reason.
+// Готовность не снимается, но отчет получает статус degraded, а проверка
+// name показывает причину.
+func (h *Health) SetDegraded(name string, reason error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.degraded == nil {
+		h.degraded = make(map[string]error)
+	}
+	h.degraded[name] = reason
+	h.last = nil
+}
+
+// Ready выполняет все проверки. Результат кешируется на CacheTTL.
+func (h *Health) Ready(ctx context.Context) Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining {
+		return Report{Status: StatusDraining, Checks: map[string]Result{}, CheckedAt: time.Now().UTC()}
+	}
+	if h.last != nil && time.Since(h.last.CheckedAt) < CacheTTL {
+		return *h.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(h.checks))}
+	for _, c := range h.checks {
+		start := time.Now()
+		err := c.check(ctx)
+		res := Result{Status: StatusOK, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			res.Status, res.Error = StatusFailed, err.Error()
+			report.Status = StatusFailed
+		}
+		report.Checks[c.name] = res
+	}
+	for name, reason := range h.degraded {
+		report.Checks[name] = Result{Status: StatusDegraded, Error: reason.Error()}
+		if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	report.CheckedAt = time.Now().UTC()
+	h.last = &report
+	return report
+}
+
+// Repository проверяет, что коллекции repo читаются, а запись проходит.
+// Хранилища с repository.Prober проверяются без загрузки коллекций, остальные
+// загружают каждую коллекцию. repo лучше передавать без обертки метрик и
+// трассировки, чтобы пробы не смешивались с обращениями клиентов.
+func Repository(repo repository.Repository, collections ...string) Check {
+	return func(ctx context.Context) error {
+		if p, ok := repo.(repository.Prober); ok {
+			return p.Probe(ctx, collections...)
+		}
+		for _, c := range collections {
+			var raw json.RawMessage
+			if err := repo.Load(ctx, c, &raw); err != nil {
+				return fmt.Errorf("load %s: %w", c, err)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
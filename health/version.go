@@ -0,0 +1,57 @@
+package health
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Commit и BuildTime задаются при сборке:
+//
+//	go build -ldflags "-X restapi/health.Commit=$(git rev-parse HEAD) -X restapi/health.BuildTime=$(date -u +%FT%TZ)"
+//
+// Коммит по умолчанию берется из сведений о VCS, которые go build встраивает сам.
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Version сведения о сборке
+// @Description Версия сервера, сборка и поддерживаемые версии API
+type Version struct {
+	Version     string   `json:"version" example:"2.0.0"`
+	Commit      string   `json:"commit,omitempty" example:"22b1266"`
+	CommitTime  string   `json:"commit_time,omitempty" example:"2026-01-01T00:00:00Z"`
+	Modified    bool     `json:"modified,omitempty"`
+	BuildTime   string   `json:"build_time,omitempty" example:"2026-01-01T00:00:00Z"`
+	GoVersion   string   `json:"go_version" example:"go1.25.3"`
+	APIVersions []string `json:"api_versions" example:"v1,v2"`
+	Uptime      string   `json:"uptime" example:"1h2m3s"`
+}
+
+// BuildVersion собирает сведения о сборке сервера версии version
+func BuildVersion(version string, apiVersions []string, uptime time.Duration) Version {
+	v := Version{
+		Version:     version,
+		Commit:      Commit,
+		BuildTime:   BuildTime,
+		GoVersion:   runtime.Version(),
+		APIVersions: apiVersions,
+		Uptime:      uptime.Truncate(time.Second).String(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if v.Commit == "" {
+					v.Commit = s.Value
+				}
+			case "vcs.time":
+				v.CommitTime = s.Value
+			case "vcs.modified":
+				v.Modified = s.Value == "true"
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// New создает журнал уровня level в формате format (json или text)
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// ErrorLog журнал ошибок для http.Server: сбои TLS рукопожатий и чтения
+// запросов попадают в общий журнал с уровнем warn
+func ErrorLog() *log.Logger {
+	return slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
+}
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	loggerKey
+	annotationsKey
+)
+
+// WithRequestID сохраняет в контексте идентификатор запроса и журнал,
+// который добавляет его к каждой записи
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIdKey, id)
+	return WithAttrs(ctx, slog.String("request_id", id))
+}
+
+// WithAttrs добавляет поля ко всем записям журнала запроса
+func WithAttrs(ctx context.Context, attrs ...any) context.Context {
+	return context.WithValue(ctx, loggerKey, FromContext(ctx).With(attrs...))
+}
+
+// RequestID возвращает идентификатор запроса или пустую строку
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// FromContext возвращает журнал запроса, а вне запроса журнал по умолчанию
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Annotations поля, которые обработчики запроса добавляют к записи журнала доступа
+type Annotations struct {
+	attrs []slog.Attr
+}
+
+// WithAnnotations готовит в контексте место для полей журнала доступа
+func WithAnnotations(ctx context.Context) (context.Context, *Annotations) {
+	a := &Annotations{}
+	return context.WithValue(ctx, annotationsKey, a), a
+}
+
+// Annotate добавляет поля к записи журнала доступа текущего запроса.
+// Вне запроса вызов ничего не делает.
+func Annotate(ctx context.Context, attrs ...slog.Attr) {
+	if a, ok := ctx.Value(annotationsKey).(*Annotations); ok {
+		a.attrs = append(a.attrs, attrs...)
+	}
+}
+
+// Attrs возвращает добавленные поля
+func (a *Annotations) Attrs() []slog.Attr {
+	return a.attrs
+}
@@ -1,7 +1,28 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"restapi/auth"
+	"restapi/config"
+	"restapi/docs"
+	"restapi/handler"
+	"restapi/health"
+	"restapi/logging"
+	"restapi/metrics"
+	"restapi/model"
+	"restapi/ratelimit"
+	"restapi/repository"
+	"restapi/repository/sqlite"
 	"restapi/server"
+	"restapi/tracing"
+	"syscall"
 )
 
 // @title Library Management REST API
@@ -13,7 +34,19 @@ import (
 // - **v2**: Расширенные операции (+ DELETE)
 //
 // ### Аутентификация:
-// Все запросы требуют API ключ в заголовке X-API-Key
+// Все запросы требуют API ключ в заголовке X-API-Key. Ключ имеет набор прав
+// вида действие:ресурс (read:books, write:users, delete:story, admin:keys),
+// ключи выпускаются и отзываются через /keys.
+// Пользователи с паролем могут войти через /auth/login и передавать
+// JWT в заголовке Authorization: Bearer. При HTTPS с проверкой клиентских
+// сертификатов ключ можно заменить сертификатом.
+// Частота запросов одного клиента ограничена, превышение возвращает 429
+// с заголовками RateLimit-* и Retry-After.
+//
+// ### Настройка:
+// Файл YAML (-config или LIBRARY_CONFIG), переменные окружения LIBRARY_*
+// и флаги командной строки, флаги имеют наивысший приоритет.
+// Права пользователя определяются его ролью: admin, librarian или member.
 // @termsOfService http://swagger.io/terms/
 // @contact.name API Support
 // @contact.email support@libraryapi.com
@@ -22,13 +55,212 @@ import (
 // @license.url https://opensource.org/licenses/MIT
 // @host localhost:8080
 // @BasePath /api/v2
-// @schemes http
+// @schemes http https
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name X-API-Key
 // @description API Key Authentication
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT из /auth/login в виде "Bearer <access_token>"
 func main() {
-	server := server.NewServer("8080")
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	importJSON := fs.Bool("import-json", false, "перенести данные из JSON файлов хранилища в базу SQLite и выйти")
+	cfg, err := config.Load(fs, os.Args[1:])
+	if err != nil {
+		// Формат журнала еще неизвестен, ошибка пишется как есть
+		log.Fatalf("❌ Config error:\n%s", err)
+	}
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalf("❌ Config error: %s", err)
+	}
+	slog.SetDefault(logger)
+
+	// Каталог хранилища создается до первого обращения к нему: в нем же
+	// лежат файл трассировки, ключ JWT, база SQLite и сертификат для разработки
+	if err := os.MkdirAll(cfg.Storage.Dir, 0o755); err != nil {
+		fatal("❌ Storage error", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		File:           cfg.Tracing.File,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+		ServiceName:    cfg.Tracing.ServiceName,
+		ServiceVersion: docs.SwaggerInfo.Version,
+	})
+	if err != nil {
+		fatal("❌ Tracing error", err)
+	}
+	policy, _ := model.ParseDeletePolicy(cfg.Storage.DeletePolicy)
+
+	// Хранилища, которые закрываются после остановки сервера, в обратном порядке
+	var closers []func() error
+
+	if *importJSON && cfg.Storage.Backend != "sqlite" {
+		fatal("❌ -import-json requires -backend sqlite", nil)
+	}
+
+	// Проверки готовности обращаются к хранилищам без оберток, чтобы пробы
+	// не попадали в метрики и трассировку
+	fileRepo := repository.NewJSONFile(cfg.Storage.Dir)
+	files := repository.WithTracing(metrics.Repository(fileRepo, "json"), "json")
+
+	// Ключи API и учетные данные пользователей хранятся рядом с данными
+	authRepo, authProbe := files, fileRepo
+	var db *sql.DB
+	if cfg.Storage.Backend == "sqlite" {
+		if db, err = sqlite.Open(cfg.Storage.DB); err != nil {
+			fatal("❌ SQLite error", err)
+		}
+		closers = append(closers, db.Close)
+		if *importJSON {
+			if err := sqlite.ImportJSON(context.Background(), db, files); err != nil {
+				fatal("❌ Import error", err)
+			}
+			slog.Info("✅ JSON storage imported", "db", cfg.Storage.DB)
+			return
+		}
+		sqlRepo, err := repository.NewSQL(db)
+		if err != nil {
+			fatal("❌ SQLite error", err)
+		}
+		authRepo, authProbe = repository.WithTracing(metrics.Repository(sqlRepo, "sqlite"), "sqlite"), sqlRepo
+	}
+
+	keys, err := auth.NewKeyStore(authRepo)
+	if err != nil {
+		fatal("❌ API keys error", err)
+	}
+	token, err := keys.Bootstrap(context.Background(), cfg.Auth.BootstrapKey)
+	if err != nil {
+		fatal("❌ API keys error", err)
+	}
+	if token != "" && cfg.Auth.BootstrapKey == "" {
+		// Сгенерированный ключ показывается один раз, в хранилище остается только хеш
+		slog.Warn("🔑 Bootstrap admin API key (shown once, store it now)", "key", token)
+	}
+
+	sessions, err := auth.NewSessions(auth.SessionConfig{
+		Alg:        cfg.Auth.JWTAlg,
+		KeyFile:    cfg.Auth.JWTKey,
+		AccessTTL:  cfg.Auth.AccessTTL,
+		RefreshTTL: cfg.Auth.RefreshTTL,
+	}, authRepo)
+	if err != nil {
+		fatal("❌ JWT error", err)
+	}
+
+	// Ограничения уже проверены config.Load
+	defaultRule, _ := ratelimit.ParseRule(cfg.RateLimit.Default)
+	routes, _ := ratelimit.ParseRoutes(cfg.RateLimit.Routes)
+	limiter := ratelimit.New(defaultRule, routes)
+	if cfg.RateLimit.DailyQuota > 0 {
+		quota, err := ratelimit.NewQuota(cfg.RateLimit.DailyQuota, authRepo)
+		if err != nil {
+			fatal("❌ Quota error", err)
+		}
+		limiter.UseQuota(quota)
+		closers = append(closers, quota.Close)
+	}
+
+	var (
+		books                        model.Books
+		users                        model.UserHandler
+		story                        model.StoryHandler
+		booksErr, usersErr, storyErr error
+	)
+	if db != nil {
+		books, users, story = sqlite.NewBooks(db), sqlite.NewUsers(db), sqlite.NewStory(db)
+	} else {
+		books, booksErr = model.BooksInit(files)
+		users, usersErr = model.UsersInit(files)
+		story, storyErr = model.StoryInit(files)
+	}
+	handlers, err := handler.NewHandlerManagerFor(books, users, story, policy, sessions)
+	loadErr := errors.Join(booksErr, usersErr, storyErr, err)
+	if loadErr != nil {
+		if !cfg.Storage.ReadOnlyOnError {
+			fatal("❌ Storage load error (use -read-only-on-error to start read-only)", loadErr)
+		}
+		slog.Warn("⚠️ Storage load error, starting read-only", "err", loadErr)
+	}
+
+	// Каждое изменение сохраняется сразу, а перед выходом хранилища
+	// сохраняются еще раз, чтобы снимки на диске совпали с тем, что видели
+	// клиенты. Данные, прочитанные частично, не сохраняются, чтобы не
+	// затереть поврежденные файлы.
+	saves := []func(context.Context) error{keys.Save, sessions.Save}
+	if loadErr == nil {
+		saves = append(saves, books.Save, users.Save, story.Save)
+	}
+	for _, save := range saves {
+		closers = append(closers, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+			defer cancel()
+			return save(ctx)
+		})
+	}
+
+	// Первый SIGINT или SIGTERM останавливает сервер плавно, второй завершает процесс сразу
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Готовность: каждое хранилище читается и принимает запись
+	checks := health.New()
+	checks.Add("auth_storage", health.Repository(authProbe,
+		repository.APIKeys, repository.Credentials, repository.RevokedTokens))
+	if db != nil {
+		checks.Add("database", func(ctx context.Context) error { return db.PingContext(ctx) })
+	} else if loadErr == nil {
+		checks.Add("storage", health.Repository(fileRepo,
+			repository.Books, repository.Users, repository.Purchases))
+	}
+	if loadErr != nil {
+		// Сервер остается готовым отвечать на чтение, причина видна в /readyz
+		checks.SetDegraded("read_only", loadErr)
+	}
+	handlers["health"] = handler.NewHealthHandler(checks, docs.SwaggerInfo.Version)
+
+	server := server.NewServer(cfg, handlers, keys, sessions, limiter)
+	if loadErr != nil {
+		server.SetReadOnly(loadErr)
+	}
 	server.Init()
-	server.StartServer()
+	code := 0
+	if err := server.StartServer(ctx); err != nil {
+		slog.Error("❌ Server error", "err", err)
+		code = 1
+	}
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i](); err != nil {
+			slog.Error("❌ Storage close error", "err", err)
+			code = 1
+		}
+	}
+	// Спаны последних запросов отправляются до выхода
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("❌ Tracing shutdown error", "err", err)
+	}
+	cancel()
+	slog.Info("👋 Server stopped")
+	os.Exit(code)
+}
+
+// fatal пишет ошибку запуска в журнал и завершает процесс
+func fatal(msg string, err error) {
+	if err != nil {
+		slog.Error(msg, "err", err)
+	} else {
+		slog.Error(msg)
+	}
+	os.Exit(1)
 }
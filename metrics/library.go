@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LibraryStats источники доменных показателей. Значения запрашиваются
+// при каждом снятии метрик, поэтому всегда совпадают с хранилищем.
+type LibraryStats struct {
+	Books     func() (int, error)
+	Users     func() (int, error)
+	OpenLoans func() (int, error)
+}
+
+var (
+	booksDesc     = prometheus.NewDesc(Namespace+"_books", "Books in the catalogue.", nil, nil)
+	usersDesc     = prometheus.NewDesc(Namespace+"_users", "Registered users.", nil, nil)
+	openLoansDesc = prometheus.NewDesc(Namespace+"_open_loans", "Purchases that have not ended yet.", nil, nil)
+	statsErrors   = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "stats_errors_total",
+		Help:      "Failures to read domain gauges from storage.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(statsErrors)
+}
+
+type libraryCollector struct {
+	stats LibraryStats
+}
+
+func (c libraryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- booksDesc
+	ch <- usersDesc
+	ch <- openLoansDesc
+}
+
+func (c libraryCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, g := range []struct {
+		desc  *prometheus.Desc
+		value func() (int, error)
+	}{
+		{booksDesc, c.stats.Books},
+		{usersDesc, c.stats.Users},
+		{openLoansDesc, c.stats.OpenLoans},
+	} {
+		if g.value == nil {
+			continue
+		}
+		n, err := g.value()
+		if err != nil {
+			// Показатель пропускается, а не отдается нулем
+			statsErrors.Inc()
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, float64(n))
+	}
+}
+
+var (
+	libraryMu sync.Mutex
+	library   prometheus.Collector
+)
+
+// ObserveLibrary публикует доменные показатели stats. Повторный вызов
+// заменяет предыдущие источники.
+func ObserveLibrary(stats LibraryStats) {
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	if library != nil {
+		Registry.Unregister(library)
+	}
+	library = libraryCollector{stats}
+	Registry.MustRegister(library)
+}
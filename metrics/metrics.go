@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+	"restapi/utils"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace префикс метрик приложения
+const Namespace = "library"
+
+// RouteUnmatched метка запросов, не подошедших ни к одному маршруту.
+// Сырой путь в метку не попадает, иначе число рядов не ограничено.
+const RouteUnmatched = "unmatched"
+
+// Registry реестр метрик сервера вместе с метриками Go и процесса
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "code"})
+
+	duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	responseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_response_size_bytes",
+		Help:      "HTTP response body size by method and route template.",
+		Buckets:   prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"method", "route"})
+
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served by route template.",
+	}, []string{"route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, duration, responseSize, inFlight,
+	)
+}
+
+// Handler отдает метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Instrument считает запросы к router. Маршрут определяется шаблоном пути
+// gorilla/mux, например /api/v2/books/{id}, а не самим путем.
+func Instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := utils.RouteTemplate(router, r)
+		if !ok {
+			route = RouteUnmatched
+		}
+
+		gauge := inFlight.WithLabelValues(route)
+		gauge.Inc()
+		defer gauge.Dec()
+
+		rec := utils.NewResponseRecorder(w)
+		start := time.Now()
+		router.ServeHTTP(rec, r)
+
+		requests.WithLabelValues(r.Method, route, strconv.Itoa(rec.Status)).Inc()
+		duration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		responseSize.WithLabelValues(r.Method, route).Observe(float64(rec.Size))
+	})
+}
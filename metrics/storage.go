@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"restapi/repository"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Storage load and save latency by backend and collection.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"backend", "op", "collection"})
+
+	storageFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "storage_operation_failures_total",
+		Help:      "Failed storage loads and saves by backend and collection.",
+	}, []string{"backend", "op", "collection"})
+)
+
+func init() {
+	Registry.MustRegister(storageDuration, storageFailures)
+}
+
+// instrumentedRepository измеряет время и ошибки обращений к хранилищу
+type instrumentedRepository struct {
+	repo    repository.Repository
+	backend string
+}
+
+// Repository оборачивает repo, чтобы каждое чтение и сохранение коллекции
+// попадало в метрики с меткой backend
+func Repository(repo repository.Repository, backend string) repository.Repository {
+	return &instrumentedRepository{repo: repo, backend: backend}
+}
+
+func (r *instrumentedRepository) Load(ctx context.Context, collection string, v any) error {
+	return r.observe("load", collection, func() error { return r.repo.Load(ctx, collection, v) })
+}
+
+func (r *instrumentedRepository) Save(ctx context.Context, collection string, v any) error {
+	return r.observe("save", collection, func() error { return r.repo.Save(ctx, collection, v) })
+}
+
+func (r *instrumentedRepository) observe(op, collection string, f func() error) error {
+	start := time.Now()
+	err := f()
+	ObserveStorage(r.backend, op, collection, time.Since(start), err)
+	return err
+}
+
+// ObserveStorage учитывает обращение к хранилищу, которое не проходит через
+// Repository, например SQL запрос хранилищ SQLite. op принимает значения
+// load или save, как у Repository.
+func ObserveStorage(backend, op, collection string, d time.Duration, err error) {
+	storageDuration.WithLabelValues(backend, op, collection).Observe(d.Seconds())
+	if err != nil {
+		storageFailures.WithLabelValues(backend, op, collection).Inc()
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"restapi/auth"
+	"restapi/logging"
+	"restapi/utils"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HeaderRequestID заголовок с идентификатором запроса
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIdLen длина, сверх которой присланный идентификатор заменяется своим
+const maxRequestIdLen = 128
+
+// RequestID берет идентификатор запроса из заголовка X-Request-ID или
+// создает новый. Идентификатор возвращается в ответе, попадает в контекст,
+// в каждую запись журнала о запросе и в ответы с ошибкой.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestId допускает только печатные символы без пробелов, чтобы
+// присланное значение не ломало журнал и заголовки
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog записывает в журнал каждый запрос к router: метод, шаблон
+// маршрута, статус, размер ответа, длительность и субъект запроса.
+// Строка запроса не пишется, в ней может быть api_key. Ответы 5xx
+// пишутся с уровнем error, запросы к маршрутам quiet (проверки
+// оркестратора, метрики) с уровнем debug.
+func AccessLog(router *mux.Router, quiet ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			route, matched := utils.RouteTemplate(router, r)
+			ctx, notes := logging.WithAnnotations(r.Context())
+			rec := utils.NewResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			level := slog.LevelInfo
+			switch {
+			case rec.Status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case matched && slices.Contains(quiet, route):
+				level = slog.LevelDebug
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+			}
+			if matched {
+				attrs = append(attrs, slog.String("route", route))
+			}
+			attrs = append(attrs,
+				slog.Int("status", rec.Status),
+				slog.Int("bytes", rec.Size),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote", r.RemoteAddr),
+			)
+			attrs = append(attrs, notes.Attrs()...)
+			logging.FromContext(ctx).LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+// LogPrincipal добавляет в журнал доступа субъект запроса: имя ключа API,
+// номер пользователя или имя клиентского сертификата. Ставится после
+// проверки ключа, токена и сертификата.
+func LogPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+			switch p.Type {
+			case auth.PrincipalAPIKey:
+				logging.Annotate(r.Context(), slog.String("api_key", p.Name), slog.String("api_key_id", p.Id))
+			case auth.PrincipalUser:
+				logging.Annotate(r.Context(), slog.Int("user_id", p.UserId))
+			case auth.PrincipalClientCert:
+				logging.Annotate(r.Context(), slog.String("client_cert", p.Name))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,15 +1,26 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"restapi/auth"
+	"restapi/utils"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
-// Middleware для проверки API ключа
-func APIKeyMiddleware(validAPIKey string) mux.MiddlewareFunc {
+// Middleware для проверки API ключа: ключ ищется в хранилище keys,
+// найденный ключ сохраняется в контексте запроса. Запросы, уже
+// прошедшие проверку Bearer токена, пропускаются без ключа.
+func APIKeyMiddleware(keys *auth.KeyStore) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.PrincipalFromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Получаем API ключ из заголовка
 			apiKey := r.Header.Get("X-API-Key")
 
@@ -19,14 +30,74 @@ func APIKeyMiddleware(validAPIKey string) mux.MiddlewareFunc {
 			}
 
 			// Проверяем ключ
-			if apiKey != validAPIKey {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(`{"error": "Invalid or missing API key"}`))
+			key, err := keys.Authenticate(apiKey)
+			if err != nil {
+				code, detail := utils.CodeInvalidAPIKey, "Invalid or missing API key"
+				switch {
+				case errors.Is(err, auth.ErrKeyExpired):
+					code, detail = utils.CodeExpiredAPIKey, "API key has expired"
+				case errors.Is(err, auth.ErrKeyRevoked):
+					code, detail = utils.CodeRevokedAPIKey, "API key has been revoked"
+				}
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, code, detail))
 				return
 			}
 			// Ключ верный, продолжаем обработку
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), key.Principal())))
+		})
+	}
+}
+
+// RequireScope пропускает запрос, только если ключ или токен запроса имеет право scope
+func RequireScope(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.PrincipalFromContext(r.Context())
+			if !ok || !p.Can(scope) {
+				forbidden(w, r, p, scope)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// RequireOwnScope пропускает запрос с правом scope, а также запрос
+// пользователя с правом scope:own к его собственным записям. Номер
+// пользователя, которому принадлежат записи, берется из переменной пути idVar.
+func RequireOwnScope(scope, idVar string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				forbidden(w, r, p, scope)
+				return
+			}
+			id, err := strconv.Atoi(mux.Vars(r)[idVar])
+			if err != nil {
+				// Такой номер не совпадет с пользователем, нужно полное право
+				id = -1
+			}
+			switch {
+			case p.CanOwn(scope, id):
+				next.ServeHTTP(w, r)
+			case p.Type == auth.PrincipalUser && auth.GrantsOwn(p.Scopes, scope):
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden,
+					utils.CodeNotOwner, "Role "+p.Role+" may only access its own records"))
+			default:
+				forbidden(w, r, p, scope)
+			}
+		})
+	}
+}
+
+// forbidden отправляет 403: ключу API не хватает права, пользователю — роли
+func forbidden(w http.ResponseWriter, r *http.Request, p auth.Principal, scope string) {
+	if p.Type == auth.PrincipalUser {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden,
+			utils.CodeInsufficientRole, "Role "+p.Role+" does not allow "+scope))
+		return
+	}
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden,
+		utils.CodeInsufficientScope, "Credentials lack scope "+scope))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"restapi/auth"
+	"restapi/utils"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BearerMiddleware проверяет JWT из заголовка Authorization: Bearer и сохраняет
+// пользователя в контексте запроса. Запросы без заголовка передаются дальше,
+// например в APIKeyMiddleware.
+func BearerMiddleware(sessions *auth.Sessions) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !found || !strings.EqualFold(scheme, "Bearer") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			p, err := sessions.Authenticate(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				code := utils.CodeInvalidToken
+				if errors.Is(err, auth.ErrTokenRevoked) {
+					code = utils.CodeRevokedToken
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, code, err.Error()))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"restapi/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// ClientCertMiddleware принимает проверенный клиентский сертификат TLS вместо
+// ключа API: субъектом запроса становится владелец сертификата с правами scopes.
+// Сертификат проверяет сам TLS сервер по tls.client_ca, поэтому здесь учитываются
+// только цепочки, которые он подтвердил. Запросы, уже прошедшие проверку Bearer
+// токена, и запросы без сертификата передаются дальше.
+func ClientCertMiddleware(scopes []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.PrincipalFromContext(r.Context()); ok || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			sum := sha256.Sum256(cert.Raw)
+			p := auth.Principal{
+				Type:   auth.PrincipalClientCert,
+				Id:     hex.EncodeToString(sum[:8]),
+				Name:   cert.Subject.CommonName,
+				Scopes: scopes,
+				UserId: -1,
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Кодировки сжатия ответов
+const (
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// Encodings поддерживаемые кодировки
+var Encodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+// CheckEncodings проверяет список кодировок из настроек
+func CheckEncodings(encodings []string) error {
+	for _, e := range encodings {
+		if !slices.Contains(Encodings, e) {
+			return fmt.Errorf("unknown encoding %q, supported: %s", e, strings.Join(Encodings, ", "))
+		}
+	}
+	return nil
+}
+
+// CompressOptions настройки сжатия ответов
+type CompressOptions struct {
+	// Encodings разрешенные кодировки в порядке предпочтения сервера
+	Encodings []string
+	// MinSize ответы меньше этого размера отправляются без сжатия:
+	// выигрыш не окупает заголовки и работу процессора
+	MinSize int
+}
+
+// encoder общий интерфейс сжимающих writer'ов, их можно переиспользовать через Reset
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders пулы сжимающих writer'ов: их буферы занимают сотни килобайт,
+// создавать их на каждый ответ дорого
+var encoders = map[string]*sync.Pool{
+	EncodingZstd: {New: func() any {
+		// Одна горутина и экономный режим памяти: ответы сжимаются параллельно
+		// в разных запросах, а не внутри одного
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return e
+	}},
+	EncodingBrotli: {New: func() any {
+		// Уровень 4 сжимает лучше gzip примерно с той же скоростью
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// Compress сжимает ответы кодировкой, которую клиент принимает в
+// Accept-Encoding. Ответ копится до MinSize байт: короткие ответы уходят
+// как есть. Уже сжатые ответы, частичные (206), без тела и ответы на HEAD
+// не трогаются. Потоковые ответы сжимаются на лету, Flush отправляет
+// накопленное клиенту.
+func Compress(opts CompressOptions) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), opts.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: opts.MinSize, status: http.StatusOK}
+			// При панике обработчика накопленное не отправляется: ответ
+			// с ошибкой пишет Recover
+			completed := false
+			defer func() {
+				if completed {
+					cw.close()
+				} else {
+					cw.discard()
+				}
+			}()
+			next.ServeHTTP(cw, r)
+			completed = true
+		})
+	}
+}
+
+// negotiateEncoding выбирает из offers кодировку с наибольшим весом q в
+// заголовке Accept-Encoding, при равном весе по порядку offers. * задает
+// вес кодировок, не названных явно.
+func negotiateEncoding(header string, offers []string) string {
+	if header == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, ok := weights[offer]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// compressWriter откладывает выбор между сжатием и обычной отправкой до
+// MinSize байт тела, Flush или конца ответа
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		// Промежуточные ответы 1xx уходят сразу
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !cw.decided {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush отправляет накопленное клиенту. Потоковый ответ сжимается,
+// даже если до Flush набралось меньше MinSize байт.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide отправляет статус и заголовки и накопленное тело, сжатое, если
+// compress и ответ подходит для сжатия
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compress && compressible(cw.status, h) {
+		e := encoders[cw.encoding].Get().(encoder)
+		e.Reset(cw.ResponseWriter)
+		cw.enc = e
+		// С Content-Encoding net/http уже не определяет тип по телу,
+		// поэтому тип определяется здесь по несжатому началу ответа
+		if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// Сжатое представление отличается побайтно, строгий ETag становится слабым
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close завершает ответ: короткий отправляется без сжатия, сжимающий
+// writer дописывает окончание потока и возвращается в пул
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) >= cw.minSize && len(cw.buf) > 0)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// discard бросает недописанный ответ: накопленное тело не отправляется,
+// сжимающий writer возвращается в пул без окончания потока
+func (cw *compressWriter) discard() {
+	cw.decided, cw.buf = true, nil
+	if cw.enc != nil {
+		cw.enc.Reset(nil)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// compressible сообщает, стоит ли сжимать ответ со статусом status и заголовками h
+func compressible(status int, h http.Header) bool {
+	switch {
+	case status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "":
+		return false
+	}
+	ct := h.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg"),
+		strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"),
+		strings.HasPrefix(ct, "application/zip"), strings.HasPrefix(ct, "application/gzip"),
+		strings.HasPrefix(ct, "application/zstd"):
+		return false
+	}
+	return true
+}
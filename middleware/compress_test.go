@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	all := []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	tests := []struct {
+		name   string
+		header string
+		offers []string
+		want   string
+	}{
+		{name: "без заголовка", header: "", offers: all, want: ""},
+		{name: "одна кодировка", header: "gzip", offers: all, want: "gzip"},
+		{name: "порядок сервера при равном весе", header: "gzip, br, zstd", offers: all, want: "zstd"},
+		{name: "вес клиента важнее порядка", header: "zstd;q=0.5, br;q=0.8, gzip", offers: all, want: "gzip"},
+		{name: "q=0 запрещает кодировку", header: "zstd;q=0, br;q=0", offers: all, want: ""},
+		{name: "звездочка", header: "*", offers: all, want: "zstd"},
+		{name: "звездочка не отменяет явный запрет", header: "*, zstd;q=0", offers: all, want: "br"},
+		{name: "регистр и пробелы", header: " GZip ; Q=0.9 ", offers: all, want: "gzip"},
+		{name: "неизвестные кодировки", header: "deflate, compress", offers: all, want: ""},
+		{name: "только разрешенные сервером", header: "zstd, gzip;q=0.5", offers: []string{EncodingGzip}, want: "gzip"},
+		{name: "identity не сжимает", header: "identity", offers: all, want: ""},
+		{name: "неверный вес считается 1", header: "br;q=abc, gzip;q=0.9", offers: all, want: "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header, tt.offers); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// decode распаковывает тело ответа в кодировке encoding
+func decode(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+	data, err := decodeBody(encoding, body)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+	return data
+}
+
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case EncodingBrotli:
+		r = brotli.NewReader(r)
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return io.ReadAll(r)
+}
+
+func TestCompress(t *testing.T) {
+	const minSize = 100
+	big := strings.Repeat(`{"id":1,"name":"Book","author":"Author"},`, 20)
+	tests := []struct {
+		name       string
+		method     string
+		accept     string
+		status     int
+		header     map[string]string
+		body       string
+		wantEnc    string
+		wantETag   string
+		wantLength bool
+		wantNoVary bool
+		wantType   string
+	}{
+		{name: "большой ответ", accept: "gzip", body: big, wantEnc: "gzip"},
+		{name: "brotli", accept: "br", body: big, wantEnc: "br"},
+		{name: "zstd", accept: "zstd, br, gzip", body: big, wantEnc: "zstd"},
+		{name: "короче MinSize", accept: "gzip", body: big[:minSize-1]},
+		{name: "ровно MinSize", accept: "gzip", body: big[:minSize], wantEnc: "gzip"},
+		{name: "клиент не принимает сжатие", accept: "", body: big},
+		{name: "HEAD", method: http.MethodHead, accept: "gzip",
+			header: map[string]string{"Content-Length": "820"}, wantLength: true},
+		{name: "частичный ответ", accept: "gzip", status: http.StatusPartialContent,
+			header: map[string]string{"Content-Range": "bytes 0-819/2000"}, body: big},
+		{name: "без тела", accept: "gzip", status: http.StatusNoContent},
+		{name: "не изменился", accept: "gzip", status: http.StatusNotModified,
+			header: map[string]string{"ETag": `"7"`}, wantETag: `"7"`},
+		{name: "уже сжатый", accept: "gzip",
+			header: map[string]string{"Content-Encoding": "br"}, body: big, wantEnc: "br"},
+		{name: "картинка", accept: "gzip",
+			header: map[string]string{"Content-Type": "image/png"}, body: big},
+		{name: "svg сжимается", accept: "gzip",
+			header: map[string]string{"Content-Type": "image/svg+xml"}, body: big, wantEnc: "gzip"},
+		{name: "строгий ETag становится слабым", accept: "gzip",
+			header: map[string]string{"ETag": `"3"`}, body: big, wantEnc: "gzip", wantETag: `W/"3"`},
+		{name: "слабый ETag остается", accept: "gzip",
+			header: map[string]string{"ETag": `W/"3"`}, body: big, wantEnc: "gzip", wantETag: `W/"3"`},
+		{name: "ETag без сжатия не меняется", accept: "gzip",
+			header: map[string]string{"ETag": `"3"`}, body: "{}", wantETag: `"3"`},
+		{name: "Content-Length сжатого удаляется", accept: "gzip",
+			header: map[string]string{"Content-Length": strconv.Itoa(len(big))}, body: big, wantEnc: "gzip"},
+		{name: "Content-Length несжатого остается", accept: "gzip",
+			header: map[string]string{"Content-Length": "2"}, body: "{}", wantLength: true},
+		{name: "тип определяется по несжатому телу", accept: "gzip",
+			body: "<!DOCTYPE html><html>" + big, wantEnc: "gzip", wantType: "text/html; charset=utf-8"},
+		{name: "ошибка сжимается", accept: "gzip", status: http.StatusInternalServerError,
+			header: map[string]string{"Content-Type": "application/problem+json"}, body: big, wantEnc: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(CompressOptions{Encodings: Encodings, MinSize: minSize})(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					for k, v := range tt.header {
+						w.Header().Set(k, v)
+					}
+					if tt.status != 0 {
+						w.WriteHeader(tt.status)
+					}
+					// Тело пишется частями, как пишет потоковый JSON
+					for body := tt.body; body != ""; {
+						n := min(len(body), 16)
+						io.WriteString(w, body[:n])
+						body = body[n:]
+					}
+				}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			wantStatus := tt.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Errorf("status %d, want %d", w.Code, wantStatus)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEnc {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEnc)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", got, tt.wantETag)
+			}
+			if got := w.Header().Get("Content-Length") != ""; got != tt.wantLength {
+				t.Errorf("Content-Length = %q, want present %v", w.Header().Get("Content-Length"), tt.wantLength)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if tt.wantType != "" && w.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.wantType)
+			}
+
+			body := w.Body.Bytes()
+			if tt.header["Content-Encoding"] == "" {
+				body = decode(t, tt.wantEnc, body)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+// Потоковый ответ сжимается сразу на Flush, даже если набралось меньше
+// MinSize, и клиент получает начало ответа до его конца
+func TestCompressFlush(t *testing.T) {
+	release := make(chan struct{})
+	h := Compress(CompressOptions{Encodings: []string{EncodingGzip}, MinSize: 1 << 10})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "<html>first")
+			http.NewResponseController(w).Flush()
+			<-release
+			io.WriteString(w, " second</html>")
+		}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want sniffed from the uncompressed body", got)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("<html>first"))
+	if _, err := io.ReadFull(zr, first); err != nil || string(first) != "<html>first" {
+		t.Fatalf("first chunk %q, %v", first, err)
+	}
+	close(release)
+	rest, err := io.ReadAll(zr)
+	if err != nil || string(rest) != " second</html>" {
+		t.Errorf("rest %q, %v", rest, err)
+	}
+}
+
+// Сжимающие writer'ы из пула не смешивают ответы параллельных запросов
+func TestCompressParallel(t *testing.T) {
+	h := Compress(CompressOptions{Encodings: Encodings, MinSize: 10})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, strings.Repeat(r.URL.Query().Get("v"), 500))
+		}))
+	done := make(chan error)
+	for i := range 30 {
+		go func() {
+			encoding := Encodings[i%len(Encodings)]
+			v := strconv.Itoa(i)
+			r := httptest.NewRequest(http.MethodGet, "/?v="+v, nil)
+			r.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			got, err := decodeBody(encoding, w.Body.Bytes())
+			if err == nil && string(got) != strings.Repeat(v, 500) {
+				err = fmt.Errorf("%s response %d mixed with another", encoding, i)
+			}
+			done <- err
+		}()
+	}
+	for range 30 {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AnyOrigin разрешает доступ страницам любого источника
+const AnyOrigin = "*"
+
+// CORSOptions какие страницы других источников могут обращаться к API из браузера
+type CORSOptions struct {
+	// Origins источники вида https://app.example.com, https://*.example.com или *
+	Origins []string
+	Methods []string
+	// Headers заголовки, которые браузер может передать в запросе
+	Headers []string
+	// ExposeHeaders заголовки ответа, которые видит скрипт страницы
+	ExposeHeaders []string
+	// Credentials разрешает запросы с cookie и клиентскими сертификатами
+	Credentials bool
+	// MaxAge сколько браузер помнит ответ на предварительный запрос
+	MaxAge time.Duration
+}
+
+// SplitList разбирает список через запятую, пустые элементы пропускаются
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// CheckOrigin проверяет источник из настроек: *, scheme://host[:port] или
+// scheme://*.domain[:port] для всех поддоменов domain
+func CheckOrigin(origin string) error {
+	if origin == AnyOrigin {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("origin %q must look like https://host[:port]", origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("origin %q must not contain path, query or credentials", origin)
+	}
+	if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+		return fmt.Errorf("origin %q: * is allowed only as the first label, as in https://*.example.com", origin)
+	}
+	return nil
+}
+
+// CORS отвечает на предварительные запросы OPTIONS сам, до проверки ключа
+// API и маршрутизации, и добавляет заголовки Access-Control-* к ответам
+// для разрешенных источников. Запросы без Origin проходят без изменений.
+func CORS(opts CORSOptions) mux.MiddlewareFunc {
+	anyOrigin := slices.Contains(opts.Origins, AnyOrigin) && !opts.Credentials
+	methods := strings.Join(opts.Methods, ", ")
+	headers := strings.Join(opts.Headers, ", ")
+	expose := strings.Join(opts.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			if !anyOrigin {
+				// Ответ зависит от источника, кеши не должны отдавать его другим страницам
+				h.Add("Vary", "Origin")
+			}
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			allowed := origin != "" && allowOrigin(opts.Origins, origin)
+			if allowed {
+				if anyOrigin {
+					h.Set("Access-Control-Allow-Origin", AnyOrigin)
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if opts.Credentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if allowed && expose != "" {
+					h.Set("Access-Control-Expose-Headers", expose)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Чужому источнику предварительный запрос отвечает без разрешений,
+			// браузер сам не пропустит основной запрос
+			if allowed {
+				h.Set("Access-Control-Allow-Methods", methods)
+				if headers != "" {
+					h.Set("Access-Control-Allow-Headers", headers)
+				}
+				if opts.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// allowOrigin сравнивает источник запроса с разрешенными без учета регистра
+func allowOrigin(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		switch {
+		case p == AnyOrigin || p == origin:
+			return true
+		case strings.Contains(p, "://*."):
+			// https://*.example.com подходит для https://app.example.com,
+			// но не для https://example.com
+			scheme, domain, _ := strings.Cut(p, "://*")
+			prefix := scheme + "://"
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(prefix)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
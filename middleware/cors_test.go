@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	opts := CORSOptions{
+		Origins:       []string{"https://app.example.com", "https://*.example.org"},
+		Methods:       []string{"GET", "POST", "DELETE"},
+		Headers:       []string{"Content-Type", "X-API-Key"},
+		ExposeHeaders: []string{"ETag", "X-Request-ID"},
+		MaxAge:        10 * time.Minute,
+	}
+	anyOrigin := opts
+	anyOrigin.Origins = []string{AnyOrigin}
+	credentials := anyOrigin
+	credentials.Credentials = true
+
+	tests := []struct {
+		name       string
+		opts       CORSOptions
+		method     string
+		origin     string
+		reqMethod  string
+		wantNext   bool
+		wantStatus int
+		// want ожидаемые заголовки ответа, пустое значение означает отсутствие
+		want map[string]string
+		vary []string
+	}{
+		{
+			name: "предварительный запрос разрешенного источника", opts: opts,
+			method: http.MethodOptions, origin: "https://app.example.com", reqMethod: "DELETE",
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, POST, DELETE",
+				"Access-Control-Allow-Headers": "Content-Type, X-API-Key",
+				"Access-Control-Max-Age":       "600",
+				// Заголовки ответа нужны только основному запросу
+				"Access-Control-Expose-Headers":    "",
+				"Access-Control-Allow-Credentials": "",
+			},
+			vary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name: "предварительный запрос чужого источника", opts: opts,
+			method: http.MethodOptions, origin: "https://evil.example.net", reqMethod: "DELETE",
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+			vary: []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name: "поддомен по шаблону", opts: opts,
+			method: http.MethodOptions, origin: "https://Shop.Example.org", reqMethod: "GET",
+			wantStatus: http.StatusNoContent,
+			want:       map[string]string{"Access-Control-Allow-Origin": "https://Shop.Example.org"},
+		},
+		{
+			name: "шаблон не разрешает сам домен", opts: opts,
+			method: http.MethodOptions, origin: "https://example.org", reqMethod: "GET",
+			wantStatus: http.StatusNoContent,
+			want:       map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "шаблон не разрешает другую схему", opts: opts,
+			method: http.MethodOptions, origin: "http://shop.example.org", reqMethod: "GET",
+			wantStatus: http.StatusNoContent,
+			want:       map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "OPTIONS без Access-Control-Request-Method не предварительный", opts: opts,
+			method: http.MethodOptions, origin: "https://app.example.com",
+			wantNext: true, wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name: "основной запрос", opts: opts,
+			method: http.MethodGet, origin: "https://app.example.com",
+			wantNext: true, wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "ETag, X-Request-ID",
+				"Access-Control-Allow-Methods":  "",
+			},
+			vary: []string{"Origin"},
+		},
+		{
+			name: "основной запрос чужого источника", opts: opts,
+			method: http.MethodGet, origin: "https://evil.example.net",
+			wantNext: true, wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":   "",
+				"Access-Control-Expose-Headers": "",
+			},
+		},
+		{
+			name: "запрос без Origin", opts: opts,
+			method: http.MethodOptions, reqMethod: "GET",
+			wantNext: true, wantStatus: http.StatusOK,
+			want: map[string]string{"Access-Control-Allow-Origin": ""},
+			vary: []string{"Origin"},
+		},
+		{
+			name: "любой источник", opts: anyOrigin,
+			method: http.MethodOptions, origin: "https://any.site", reqMethod: "POST",
+			wantStatus: http.StatusNoContent,
+			want:       map[string]string{"Access-Control-Allow-Origin": "*"},
+			// Ответ одинаков для всех источников
+			vary: []string{"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		},
+		{
+			name: "любой источник с cookie", opts: credentials,
+			method: http.MethodGet, origin: "https://any.site",
+			wantNext: true, wantStatus: http.StatusOK,
+			// Браузер отвергает * вместе с Allow-Credentials, поэтому источник повторяется
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://any.site",
+				"Access-Control-Allow-Credentials": "true",
+			},
+			vary: []string{"Origin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			r := httptest.NewRequest(tt.method, "/api/v1/books", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			w := httptest.NewRecorder()
+			CORS(tt.opts)(next).ServeHTTP(w, r)
+
+			if called != tt.wantNext || w.Code != tt.wantStatus {
+				t.Errorf("status %d, next called %v; want %d, %v", w.Code, called, tt.wantStatus, tt.wantNext)
+			}
+			for name, want := range tt.want {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if tt.vary != nil && !slices.Equal(w.Header().Values("Vary"), tt.vary) {
+				t.Errorf("Vary = %q, want %q", w.Header().Values("Vary"), tt.vary)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		valid  bool
+	}{
+		{origin: "*", valid: true},
+		{origin: "https://app.example.com", valid: true},
+		{origin: "http://localhost:3000", valid: true},
+		{origin: "https://*.example.com", valid: true},
+		{origin: "https://*.example.com:8443", valid: true},
+		{origin: "app.example.com"},
+		{origin: "https://app.example.com/"},
+		{origin: "https://app.example.com/path"},
+		{origin: "https://app.example.com?x=1"},
+		{origin: "https://user@app.example.com"},
+		{origin: "https://app.*.example.com"},
+		{origin: "https://*.*.example.com"},
+	}
+	for _, tt := range tests {
+		if err := CheckOrigin(tt.origin); (err == nil) != tt.valid {
+			t.Errorf("CheckOrigin(%q) = %v, want valid %v", tt.origin, err, tt.valid)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{s: "", want: nil},
+		{s: " , ,", want: nil},
+		{s: "GET", want: []string{"GET"}},
+		{s: " GET, POST ,,DELETE ", want: []string{"GET", "POST", "DELETE"}},
+	}
+	for _, tt := range tests {
+		if got := SplitList(tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitList(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
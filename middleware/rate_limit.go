@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"restapi/auth"
+	"restapi/ratelimit"
+	"restapi/utils"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RateLimit ограничивает частоту запросов клиента. Клиентом считается ключ
+// API или пользователь из контекста запроса, без них — IP адрес. Ответ
+// содержит заголовки RateLimit-* по draft-ietf-httpapi-ratelimit-headers,
+// отклоненный запрос получает 429 и Retry-After.
+func RateLimit(limiter *ratelimit.Limiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if gate, ok := r.Context().Value(limitGateKey{}).(*limitGate); ok {
+				gate.charged = true
+			}
+			route := routeTemplate(r)
+			d := limiter.Allow(clientKey(r), r.Method, route)
+			setRateLimitHeaders(w.Header(), d)
+			if !d.Allowed {
+				tooManyRequests(w, r, d)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitGateKey ключ контекста, под которым PreAuthRateLimit ждет учета запроса
+type limitGateKey struct{}
+
+// limitGate отмечает, что RateLimit уже учел запрос по клиенту
+type limitGate struct {
+	charged bool
+}
+
+// PreAuthRateLimit ставится перед проверкой ключей и токенов, пока клиент
+// известен только по IP адресу. Если маркеры IP адреса исчерпаны, запрос
+// отклоняется до проверки учетных данных. Запрос, который так и не дошел
+// до RateLimit, например отклоненный с неверным ключом, расходует маркер
+// IP адреса, поэтому подбор ключей ограничивается так же, как обычные
+// запросы. Запросы с известным клиентом учитывает только RateLimit.
+func PreAuthRateLimit(limiter *ratelimit.Limiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, route := clientKey(r), routeTemplate(r)
+			if d := limiter.Check(client, r.Method, route); !d.Allowed {
+				setRateLimitHeaders(w.Header(), d)
+				tooManyRequests(w, r, d)
+				return
+			}
+
+			gate := &limitGate{}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), limitGateKey{}, gate)))
+			if !gate.charged {
+				limiter.Allow(client, r.Method, route)
+			}
+		})
+	}
+}
+
+// routeTemplate возвращает шаблон маршрута, по которому ищется ограничение
+func routeTemplate(r *http.Request) string {
+	if cur := mux.CurrentRoute(r); cur != nil {
+		if tpl, err := cur.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// tooManyRequests отвечает 429 на запрос, отклоненный решением d
+func tooManyRequests(w http.ResponseWriter, r *http.Request, d ratelimit.Decision) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+	code, detail := utils.CodeRateLimited, "Rate limit "+d.Rule.String()+" exceeded"
+	if d.QuotaExceeded {
+		code, detail = utils.CodeQuotaExceeded, "Daily quota of "+strconv.Itoa(d.Quota)+" requests exceeded"
+	}
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusTooManyRequests, code, detail))
+}
+
+// clientKey возвращает идентификатор клиента, по которому ведется учет запросов
+func clientKey(r *http.Request) string {
+	if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return p.Type + ":" + p.Id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// setRateLimitHeaders сообщает клиенту действующие ограничения. Limit,
+// Remaining и Reset относятся к тому из них, что ближе к исчерпанию.
+func setRateLimitHeaders(h http.Header, d ratelimit.Decision) {
+	var policies []string
+	limit, remaining, reset := 0, 0, time.Duration(0)
+	if !d.Rule.Off() {
+		policies = append(policies, d.Rule.Policy())
+		limit, remaining, reset = d.Rule.Requests, d.Remaining, d.Reset
+	}
+	if d.Quota > 0 {
+		policies = append(policies, ratelimit.Rule{Requests: d.Quota, Per: 24 * time.Hour}.Policy())
+		if limit == 0 || d.QuotaExceeded || d.QuotaRemaining < remaining {
+			limit, remaining, reset = d.Quota, d.QuotaRemaining, d.QuotaReset
+		}
+	}
+	if limit == 0 {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	for _, p := range policies {
+		h.Add("RateLimit-Policy", p)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
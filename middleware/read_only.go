@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"restapi/utils"
+)
+
+// ReadOnly пропускает только чтение: GET, HEAD и OPTIONS. Остальные
+// запросы получают 503 с кодом read_only. Ставится, когда данные
+// загрузились с ошибкой: запись поверх них затерла бы то, что осталось
+// в хранилище.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusServiceUnavailable, utils.CodeReadOnly,
+				"server is running in read-only mode"))
+		}
+	})
+}
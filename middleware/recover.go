@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"restapi/logging"
+	"restapi/utils"
+	"runtime/debug"
+)
+
+// discardOnPanic заголовки ответа обработчика, которые удаляются перед
+// ответом 500
+var discardOnPanic = []string{"ETag", "Last-Modified", "Content-Type", "Content-Encoding", "Content-Length"}
+
+// Recover перехватывает панику обработчика: в журнал пишется ее значение
+// и стек вместе с идентификатором запроса, клиент получает 500 с кодом
+// internal_error. Если ответ уже начат, соединение обрывается, чтобы
+// клиент не принял недописанный ответ за целый.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := utils.NewResponseRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Обработчик сам прервал ответ, это не ошибка сервера
+				panic(v)
+			}
+			logging.FromContext(r.Context()).Error("panic",
+				"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rec.Written() {
+				panic(http.ErrAbortHandler)
+			}
+			// Заголовки, которые обработчик успел задать для своего ответа,
+			// к ответу с ошибкой не относятся
+			for _, h := range discardOnPanic {
+				w.Header().Del(h)
+			}
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal,
+				"internal server error"))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"restapi/utils"
+	"strings"
+	"testing"
+)
+
+// serveRecovered выполняет запрос через h и возвращает ответ и значение
+// паники, вышедшей из h
+func serveRecovered(h http.Handler, r *http.Request) (w *httptest.ResponseRecorder, panicked any) {
+	w = httptest.NewRecorder()
+	defer func() { panicked = recover() }()
+	h.ServeHTTP(w, r)
+	return w, nil
+}
+
+func TestRecover(t *testing.T) {
+	// Перехваченные паники пишутся в журнал по умолчанию, в тесте он не нужен
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wrap    func(http.Handler) http.Handler
+		// wantPanic паника, которую Recover пропускает дальше, чтобы сервер
+		// оборвал соединение
+		wantPanic any
+	}{
+		{
+			name: "до ответа",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"7"`)
+				w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+				w.Header().Set("Content-Type", "application/json")
+				panic("boom")
+			},
+		},
+		{
+			name: "в сжатом ответе, не дописанном до MinSize",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"books":[`))
+				panic("boom")
+			},
+			wrap: Compress(CompressOptions{Encodings: Encodings, MinSize: 1024}),
+		},
+		{
+			name: "после начала ответа",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic("boom")
+			},
+			wantPanic: http.ErrAbortHandler,
+		},
+		{
+			name: "обработчик сам прервал ответ",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic(http.ErrAbortHandler)
+			},
+			wantPanic: http.ErrAbortHandler,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Handler = tt.handler
+			if tt.wrap != nil {
+				h = tt.wrap(h)
+			}
+			r := httptest.NewRequest("GET", "/api/v2/books", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w, panicked := serveRecovered(Recover(h), r)
+			if panicked != tt.wantPanic {
+				t.Fatalf("panic = %v, want %v", panicked, tt.wantPanic)
+			}
+			if tt.wantPanic != nil {
+				return
+			}
+
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status %d, want 500", w.Code)
+			}
+			for _, h := range []string{"ETag", "Last-Modified", "Content-Encoding"} {
+				if v := w.Header().Get(h); v != "" {
+					t.Errorf("%s: %q left from the failed response", h, v)
+				}
+			}
+			if ct := w.Header().Get("Content-Type"); ct != utils.ContentProblem {
+				t.Errorf("Content-Type %q, want %q", ct, utils.ContentProblem)
+			}
+			var p utils.Problem
+			if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&p); err != nil || p.Code != utils.CodeInternal {
+				t.Errorf("body %q: %v, want problem %s", w.Body, err, utils.CodeInternal)
+			}
+		})
+	}
+}
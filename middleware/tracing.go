@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"restapi/logging"
+	"restapi/tracing"
+	"restapi/utils"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing открывает серверный спан на каждый запрос к router. Контекст
+// трассы берется из заголовка traceparent, поэтому спаны сервера попадают
+// в трассу вызывающего. Спан называется по шаблону маршрута, например
+// GET /api/v2/books/{id}, а идентификатор трассы добавляется в журнал запроса.
+func Tracing(router *mux.Router) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			name := r.Method
+			attrs := []attribute.KeyValue{
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("user_agent.original", r.UserAgent()),
+				attribute.String("request_id", logging.RequestID(ctx)),
+			}
+			if route, ok := utils.RouteTemplate(router, r); ok {
+				name += " " + route
+				attrs = append(attrs, attribute.String("http.route", route))
+			}
+			ctx, span := otel.Tracer(tracing.Name).Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = logging.WithAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+			}
+			rec := utils.NewResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(
+				attribute.Int("http.response.status_code", rec.Status),
+				attribute.Int("http.response.body.size", rec.Size))
+			if rec.Status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.Status))
+			}
+		})
+	}
+}
+
+// Traced выделяет работу middleware mw в отдельный спан name. Спан
+// закрывается, когда mw передает запрос дальше, или когда mw сам
+// отвечает клиенту, например 401 или 429.
+func Traced(name string, mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := tracing.Start(r.Context(), "middleware "+name)
+			passed := false
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				span.End()
+				passed = true
+				// Дальше запрос идет в спане запроса, а не в спане middleware,
+				// значения, добавленные mw в контекст, сохраняются
+				next.ServeHTTP(w, r.WithContext(trace.ContextWithSpan(r.Context(), parent)))
+			})).ServeHTTP(w, r.WithContext(ctx))
+			if !passed {
+				span.SetAttributes(attribute.Bool("http.request.rejected", true))
+				span.End()
+			}
+		})
+	}
+}
+
+// TraceHandler выделяет работу обработчика h в спан handler name
+func TraceHandler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "handler "+name)
+		defer span.End()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"restapi/tracing"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans подменяет глобальный провайдер спанов на записывающий в память
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		target      string
+		traceparent string
+		status      int
+		wantName    string
+		wantRoute   string
+		wantError   bool
+	}{
+		{name: "шаблон маршрута", target: "/api/v1/books/7", status: http.StatusOK, wantName: "GET /api/v1/books/{id}", wantRoute: "/api/v1/books/{id}"},
+		{name: "трасса вызывающего", target: "/api/v1/books/7", traceparent: "00-" + traceID + "-" + spanID + "-01", status: http.StatusOK, wantName: "GET /api/v1/books/{id}", wantRoute: "/api/v1/books/{id}"},
+		{name: "ошибка сервера", target: "/api/v1/books/7", status: http.StatusServiceUnavailable, wantName: "GET /api/v1/books/{id}", wantRoute: "/api/v1/books/{id}", wantError: true},
+		{name: "ошибка клиента не ошибка спана", target: "/api/v1/books/7", status: http.StatusNotFound, wantName: "GET /api/v1/books/{id}", wantRoute: "/api/v1/books/{id}"},
+		{name: "неизвестный путь", target: "/secret/123", status: http.StatusNotFound, wantName: "GET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recordSpans(t)
+			router := mux.NewRouter()
+			var inner trace.SpanContext
+			router.HandleFunc("/api/v1/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+				inner = trace.SpanContextFromContext(r.Context())
+				w.WriteHeader(tt.status)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+			// Как и на сервере, Tracing оборачивает весь маршрутизатор
+			Tracing(router)(router).ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := rec.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != tt.wantName || span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("span %q of kind %v, want server span %q", span.Name(), span.SpanKind(), tt.wantName)
+			}
+			if got := spanAttr(span, "http.route").AsString(); got != tt.wantRoute {
+				t.Errorf("http.route = %q, want %q", got, tt.wantRoute)
+			}
+			if got := spanAttr(span, "http.response.status_code").AsInt64(); got != int64(tt.status) {
+				t.Errorf("status attribute = %d, want %d", got, tt.status)
+			}
+			if got := span.Status().Code == codes.Error; got != tt.wantError {
+				t.Errorf("span status %v, want error %v", span.Status(), tt.wantError)
+			}
+			if tt.traceparent != "" {
+				if span.SpanContext().TraceID().String() != traceID || span.Parent().SpanID().String() != spanID {
+					t.Errorf("span trace %s parent %s, want trace %s parent %s",
+						span.SpanContext().TraceID(), span.Parent().SpanID(), traceID, spanID)
+				}
+			}
+			if tt.wantRoute != "" && inner.SpanID() != span.SpanContext().SpanID() {
+				t.Error("handler does not run inside the request span")
+			}
+		})
+	}
+}
+
+// Спан middleware закрывается до обработчика, а обработчик работает в
+// спане запроса; отказ middleware отмечается в его спане
+func TestTraced(t *testing.T) {
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-API-Key") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	tests := []struct {
+		name         string
+		key          string
+		wantRejected bool
+		wantSpans    []string
+	}{
+		{name: "пропущен", key: "12345", wantSpans: []string{"middleware api_key", "handler books", "GET"}},
+		{name: "отклонен", wantRejected: true, wantSpans: []string{"middleware api_key", "GET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := recordSpans(t)
+			var handlerParent trace.SpanID
+			books := TraceHandler("books", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerParent = trace.SpanFromContext(r.Context()).SpanContext().SpanID()
+			}))
+			h := Tracing(mux.NewRouter())(Traced("api_key", reject)(books))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := rec.Ended()
+			if len(spans) != len(tt.wantSpans) {
+				t.Fatalf("got %d spans, want %v", len(spans), tt.wantSpans)
+			}
+			byName := map[string]sdktrace.ReadOnlySpan{}
+			for i, span := range spans {
+				if span.Name() != tt.wantSpans[i] {
+					t.Errorf("span %d = %q, want %q", i, span.Name(), tt.wantSpans[i])
+				}
+				byName[span.Name()] = span
+			}
+			request := byName["GET"].SpanContext().SpanID()
+			if mw := byName["middleware api_key"]; mw.Parent().SpanID() != request {
+				t.Error("middleware span is not a child of the request span")
+			}
+			if got := spanAttr(byName["middleware api_key"], "http.request.rejected").AsBool(); got != tt.wantRejected {
+				t.Errorf("rejected = %v, want %v", got, tt.wantRejected)
+			}
+			if handler, ok := byName["handler books"]; ok {
+				if handler.Parent().SpanID() != request {
+					t.Error("handler span is not a child of the request span")
+				}
+				if handlerParent != handler.SpanContext().SpanID() {
+					t.Error("handler does not run inside its own span")
+				}
+			}
+		})
+	}
+}
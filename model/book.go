@@ -1,9 +1,15 @@
 package model
 
 import (
-	"encoding/json"
-	"os"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"restapi/repository"
 	"restapi/utils"
+	"strings"
+	"sync"
+	"time"
 
 	_ "restapi/docs" // Импорт сгенерированной документации
 )
@@ -15,6 +21,9 @@ type BookModel struct {
 	Name   string  `json:"name"`
 	Author string  `json:"author"`
 	Price  float64 `json:"price"`
+	// Version растет с каждым изменением книги, из нее строится ETag
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
 }
 
 // Library представляет библиотеку книг
@@ -22,94 +31,197 @@ type BookModel struct {
 type Library struct {
 	Books      []BookModel `json:"books"`
 	TotalBooks int         `json:"total"`
+	// NextId следующий идентификатор книги, идентификаторы не используются повторно
+	NextId int `json:"next_id"`
+
+	// mu защищает Books и TotalBooks: net/http обслуживает каждый запрос
+	// в отдельной горутине
+	mu   sync.RWMutex
+	repo repository.Repository
 }
 
 // Books представляет интерфейс для работы с книгами
 type Books interface {
-	Get() error
-	Save() error
-	AddBook(book BookModel)
-	RemoveBook(id int)
-	UpdateBook(book BookModel)
-	GetBook(id int) []byte
-	GetAllBooks() []byte
-	GetCount() int
+	Get(ctx context.Context) error
+	Save(ctx context.Context) error
+	AddBook(ctx context.Context, book BookModel) error
+	// RemoveBook удаляет книгу, если ее версия равна version.
+	// Несовпадение версии дает ErrVersionConflict, AnyVersion отключает проверку.
+	RemoveBook(ctx context.Context, id, version int) error
+	// UpdateBook обновляет книгу, если ее версия равна book.Version,
+	// и увеличивает версию
+	UpdateBook(ctx context.Context, book BookModel) error
+	GetBook(ctx context.Context, id int) []byte
+	FindBook(ctx context.Context, id int) (BookModel, error)
+	// WriteAllBooks пишет всю библиотеку в w потоком JSON
+	WriteAllBooks(ctx context.Context, w io.Writer) error
+	ListBooks(ctx context.Context, q BookQuery) (BookList, error)
+	GetCount(ctx context.Context) int
 }
 
-func BooksInit() Books {
-	var l Library
-	err := l.Get()
-	if err != nil {
-		panic(err)
+// BooksInit загружает библиотеку из repo. Если ее не удалось прочитать,
+// вместе с ошибкой возвращается пустая библиотека, с которой сервер может
+// работать только на чтение.
+func BooksInit(repo repository.Repository) (Books, error) {
+	l := Library{repo: repo}
+	if err := l.Get(context.Background()); err != nil {
+		return &l, fmt.Errorf("load books: %w", err)
 	}
-	return &l
+	return &l, nil
 }
 
-func (l *Library) Get() error {
-	data, err := os.ReadFile("./storage/books.json")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+func (l *Library) Get(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.repo.Load(ctx, repository.Books, l); err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, &l)
-	if err != nil {
-		return err
+	for i := range l.Books {
+		l.Books[i].Version = initialVersion(l.Books[i].Version)
+	}
+	if l.RepairIds() {
+		return l.save(ctx)
 	}
-
 	return nil
 }
-func (l *Library) GetBook(id int) []byte {
+
+// RepairIds исправляет повторяющиеся идентификаторы книг и последовательность NextId.
+// Вызывающий должен держать l.mu.
+func (l *Library) RepairIds() bool {
+	ids := make([]*int, len(l.Books))
+	for i := range l.Books {
+		ids[i] = &l.Books[i].Id
+	}
+	changed := repairIds(ids, &l.NextId, 1)
+	if l.TotalBooks != len(l.Books) {
+		l.TotalBooks = len(l.Books)
+		changed = true
+	}
+	return changed
+}
+func (l *Library) GetBook(ctx context.Context, id int) []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	for _, book := range l.Books {
 		if book.Id == id {
-			return utils.MarshalThis(book)
+			return utils.MarshalThis(ctx, book)
 		}
 	}
 	return nil
 }
-func (l *Library) GetAllBooks() []byte {
-	return utils.MarshalThis(l)
+func (l *Library) FindBook(ctx context.Context, id int) (BookModel, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, book := range l.Books {
+		if book.Id == id {
+			return book, nil
+		}
+	}
+	return BookModel{}, ErrBookNotFound
+}
+func (l *Library) WriteAllBooks(ctx context.Context, w io.Writer) error {
+	// Изменения не трогают опубликованный срез, а заменяют его новым,
+	// поэтому под блокировкой берется только заголовок среза: медленный
+	// клиент не задерживает изменения, и книги не копируются
+	l.mu.RLock()
+	books, total := l.Books, l.TotalBooks
+	l.mu.RUnlock()
+
+	s := utils.NewJSONStream(ctx, w)
+	BeginList(s, "books")
+	for i, book := range books {
+		s.Elem(i, book)
+	}
+	EndList(s, total)
+	return s.Close()
 }
-func (l *Library) GetCount() int {
+func (l *Library) GetCount(ctx context.Context) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return l.TotalBooks
 }
-func (l *Library) Save() error {
-	data, err := json.Marshal(l)
-	if err != nil {
-		return err
-	}
+func (l *Library) Save(ctx context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.save(ctx)
+}
 
-	if err := os.WriteFile("./storage/books.json", data, 0644); err != nil {
+// save сохраняет библиотеку, вызывающий должен держать l.mu
+func (l *Library) save(ctx context.Context) error {
+	return l.repo.Save(ctx, repository.Books, l)
+}
+
+// commit заменяет книги и сохраняет библиотеку. Если сохранить не удалось,
+// прежние книги и NextId возвращаются: клиент получает ошибку, и изменения
+// не видно следующим запросам. Вызывающий должен держать l.mu.
+func (l *Library) commit(ctx context.Context, books []BookModel, nextId int) error {
+	prevBooks, prevTotal, prevNext := l.Books, l.TotalBooks, l.NextId
+	l.Books, l.TotalBooks, l.NextId = books, len(books), nextId
+	if err := l.save(ctx); err != nil {
+		l.Books, l.TotalBooks, l.NextId = prevBooks, prevTotal, prevNext
 		return err
 	}
-
 	return nil
 }
-func (l *Library) AddBook(book BookModel) {
-	book.Id = l.TotalBooks + 1
-	l.Books = append(l.Books, book)
-	l.TotalBooks++
-	l.Save()
+func (l *Library) AddBook(ctx context.Context, book BookModel) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	book.Id = max(l.NextId, 1)
+	book.Version, book.UpdatedAt = 1, time.Now()
+	return l.commit(ctx, append(l.Books, book), book.Id+1)
 }
-func (l *Library) RemoveBook(id int) {
+func (l *Library) RemoveBook(ctx context.Context, id, version int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	for i, book := range l.Books {
 		if book.Id == id {
-			if i == len(l.Books)-1 {
-				l.Books = l.Books[:i]
-			} else {
-				l.Books = append(l.Books[:i], l.Books[i+1:]...)
+			if err := checkVersion(book.Version, version); err != nil {
+				return err
 			}
+			return l.commit(ctx, removed(l.Books, i), l.NextId)
 		}
 	}
-	l.TotalBooks--
-	l.Save()
+	return ErrBookNotFound
 }
-func (l *Library) UpdateBook(book BookModel) {
+func (l *Library) UpdateBook(ctx context.Context, book BookModel) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	for i, b := range l.Books {
 		if b.Id == book.Id {
-			l.Books[i] = book
-			l.Save()
+			if err := checkVersion(b.Version, book.Version); err != nil {
+				return err
+			}
+			book.Version, book.UpdatedAt = b.Version+1, time.Now()
+			return l.commit(ctx, replaced(l.Books, i, book), l.NextId)
 		}
 	}
+	return ErrBookNotFound
+}
+
+// bookCompare сравнение книг по полям сортировки
+var bookCompare = map[string]func(a, b BookModel) int{
+	"id":     func(a, b BookModel) int { return cmp.Compare(a.Id, b.Id) },
+	"name":   func(a, b BookModel) int { return strings.Compare(a.Name, b.Name) },
+	"author": func(a, b BookModel) int { return strings.Compare(a.Author, b.Author) },
+	"price":  func(a, b BookModel) int { return cmp.Compare(a.Price, b.Price) },
+}
+
+func (l *Library) ListBooks(ctx context.Context, q BookQuery) (BookList, error) {
+	if err := q.Normalize(BookSortFields); err != nil {
+		return BookList{}, err
+	}
+	match := func(b BookModel) bool {
+		return (q.Author == "" || strings.EqualFold(b.Author, q.Author)) &&
+			(q.PriceMin == nil || b.Price >= *q.PriceMin) &&
+			(q.PriceMax == nil || b.Price <= *q.PriceMax)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	books, total, next, err := listPage(l.Books, q.Page, match, bookCompare,
+		func(b BookModel) int { return b.Id })
+	if err != nil {
+		return BookList{}, err
+	}
+	return BookList{Books: books, Total: total, Limit: q.Limit, Offset: q.Offset, NextCursor: next}, nil
 }
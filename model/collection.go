@@ -0,0 +1,20 @@
+package model
+
+import "slices"
+
+// Изменения коллекций не трогают текущий срез, а строят новый: при ошибке
+// сохранения прежний срез просто возвращается на место, а WriteAll отдает
+// полученный под блокировкой срез без копирования. Элементы на месте
+// меняет только Get при загрузке, до появления читателей.
+
+// replaced возвращает копию items, в которой i-й элемент заменен на v
+func replaced[T any](items []T, i int, v T) []T {
+	res := slices.Clone(items)
+	res[i] = v
+	return res
+}
+
+// removed возвращает копию items без i-го элемента
+func removed[T any](items []T, i int) []T {
+	return slices.Concat(items[:i], items[i+1:])
+}
@@ -0,0 +1,15 @@
+package model
+
+import "errors"
+
+var (
+	ErrBookNotFound     = errors.New("book not found")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrPurchaseNotFound = errors.New("purchase not found")
+	// ErrReferenced возвращается при удалении книги или пользователя,
+	// на которых ссылаются покупки, если действует политика DeleteRestrict
+	ErrReferenced = errors.New("referenced by purchases")
+	// ErrVersionConflict возвращается, если запись изменилась после того,
+	// как клиент прочитал ее версию
+	ErrVersionConflict = errors.New("version conflict")
+)
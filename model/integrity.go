@@ -0,0 +1,194 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DeletePolicy определяет, что происходит с покупками при удалении книги или пользователя
+type DeletePolicy string
+
+const (
+	// DeleteRestrict запрещает удалять книги и пользователей, у которых есть покупки
+	DeleteRestrict DeletePolicy = "restrict"
+	// DeleteCascade удаляет покупки вместе с книгой или пользователем
+	DeleteCascade DeletePolicy = "cascade"
+	// DeleteOrphan оставляет покупки в истории, помечая их как осиротевшие
+	DeleteOrphan DeletePolicy = "orphan"
+)
+
+// ParseDeletePolicy разбирает политику удаления из строки
+func ParseDeletePolicy(s string) (DeletePolicy, error) {
+	switch p := DeletePolicy(s); p {
+	case DeleteRestrict, DeleteCascade, DeleteOrphan:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown delete policy %q", s)
+}
+
+// WithIntegrity связывает книги, пользователей и историю покупок проверками ссылок.
+// Покупки можно создать только для существующих книги и пользователя, а удаление
+// книги или пользователя подчиняется политике policy. Работает поверх любого хранилища.
+func WithIntegrity(books Books, users UserHandler, story StoryHandler, policy DeletePolicy) (Books, UserHandler, StoryHandler) {
+	// Один мьютекс на все три коллекции: проверка ссылки и запись
+	// должны выполняться атомарно относительно удалений
+	mu := &sync.Mutex{}
+	return &integrityBooks{Books: books, story: story, policy: policy, mu: mu},
+		&integrityUsers{UserHandler: users, story: story, policy: policy, mu: mu},
+		&integrityStory{StoryHandler: story, books: books, users: users, mu: mu}
+}
+
+type integrityBooks struct {
+	Books
+	story  StoryHandler
+	policy DeletePolicy
+	mu     *sync.Mutex
+}
+
+// UpdateBook идет под общим мьютексом, чтобы версия книги не изменилась
+// между проверкой в RemoveBook и удалением
+func (b *integrityBooks) UpdateBook(ctx context.Context, book BookModel) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Books.UpdateBook(ctx, book)
+}
+
+func (b *integrityBooks) RemoveBook(ctx context.Context, id, version int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Версия проверяется до того, как политика тронет покупки
+	book, err := b.Books.FindBook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(book.Version, version); err != nil {
+		return err
+	}
+
+	var apply func(context.Context, int) error
+	switch b.policy {
+	case DeleteCascade:
+		apply = b.story.DelPurchaseByBook
+	case DeleteOrphan:
+		apply = b.story.OrphanByBook
+	default:
+		if b.story.CountByBook(ctx, id) > 0 {
+			return fmt.Errorf("book %d: %w", id, ErrReferenced)
+		}
+	}
+	return removeWithPolicy(ctx, b.story, PurchaseQuery{BookId: &id}, id, apply,
+		func() error { return b.Books.RemoveBook(ctx, id, version) })
+}
+
+type integrityUsers struct {
+	UserHandler
+	story  StoryHandler
+	policy DeletePolicy
+	mu     *sync.Mutex
+}
+
+func (u *integrityUsers) UpdateUser(ctx context.Context, user User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.UserHandler.UpdateUser(ctx, user)
+}
+
+func (u *integrityUsers) RemoveUser(ctx context.Context, id, version int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, err := u.UserHandler.FindUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(user.Version, version); err != nil {
+		return err
+	}
+
+	var apply func(context.Context, int) error
+	switch u.policy {
+	case DeleteCascade:
+		apply = u.story.DelPurchaseByUser
+	case DeleteOrphan:
+		apply = u.story.OrphanByUser
+	default:
+		if u.story.CountByUser(ctx, id) > 0 {
+			return fmt.Errorf("user %d: %w", id, ErrReferenced)
+		}
+	}
+	return removeWithPolicy(ctx, u.story, PurchaseQuery{UserId: &id}, id, apply,
+		func() error { return u.UserHandler.RemoveUser(ctx, id, version) })
+}
+
+// removeWithPolicy применяет к покупкам записи id политику apply и затем
+// удаляет саму запись. Покупки, выбранные q, запоминаются заранее: если
+// запись удалить не удалось, они возвращаются в прежнее состояние, чтобы у
+// оставшейся книги или пользователя не пропала история. Пустой apply
+// означает, что покупки не меняются. Вызывающий должен держать общий мьютекс.
+func removeWithPolicy(ctx context.Context, story StoryHandler, q PurchaseQuery, id int,
+	apply func(context.Context, int) error, remove func() error) error {
+	if apply == nil {
+		return remove()
+	}
+	var before []Purchase
+	q.Limit = MaxLimit
+	for {
+		list, err := story.List(ctx, q)
+		if err != nil {
+			return err
+		}
+		before = append(before, list.Purchases...)
+		if list.NextCursor == "" {
+			break
+		}
+		q.Cursor = list.NextCursor
+	}
+
+	if err := apply(ctx, id); err != nil {
+		return err
+	}
+	if err := remove(); err != nil {
+		if restoreErr := story.RestorePurchases(ctx, before); restoreErr != nil {
+			return errors.Join(err, fmt.Errorf("restore purchases: %w", restoreErr))
+		}
+		return err
+	}
+	return nil
+}
+
+type integrityStory struct {
+	StoryHandler
+	books Books
+	users UserHandler
+	mu    *sync.Mutex
+}
+
+// checkRefs проверяет, что книга и пользователь покупки существуют
+func (s *integrityStory) checkRefs(ctx context.Context, p Purchase) error {
+	if s.books.GetBook(ctx, p.BookId) == nil {
+		return fmt.Errorf("book %d: %w", p.BookId, ErrBookNotFound)
+	}
+	if s.users.GetUser(ctx, p.UserId) == nil {
+		return fmt.Errorf("user %d: %w", p.UserId, ErrUserNotFound)
+	}
+	return nil
+}
+
+func (s *integrityStory) AddPurchase(ctx context.Context, p Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkRefs(ctx, p); err != nil {
+		return err
+	}
+	return s.StoryHandler.AddPurchase(ctx, p)
+}
+
+func (s *integrityStory) UpdatePurchase(ctx context.Context, p Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkRefs(ctx, p); err != nil {
+		return err
+	}
+	return s.StoryHandler.UpdatePurchase(ctx, p)
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"restapi/repository"
+	"testing"
+)
+
+var errSave = errors.New("save failed")
+
+// failingRepo хранит коллекции в памяти, но возвращает ошибку на failAt-м
+// сохранении после того, как тест его взвел
+type failingRepo struct {
+	repository.Repository
+	saves, failAt int
+}
+
+func (r *failingRepo) Save(ctx context.Context, collection string, v any) error {
+	r.saves++
+	if r.saves == r.failAt {
+		return errSave
+	}
+	return r.Repository.Save(ctx, collection, v)
+}
+
+// failOn взводит ошибку на n-м следующем сохранении
+func (r *failingRepo) failOn(n int) {
+	r.saves, r.failAt = 0, n
+}
+
+// newLibrary создает книгу 1, пользователя 0 и две покупки: одна ссылается
+// на книгу и пользователя, другая на вторую книгу
+func newLibrary(t *testing.T, policy DeletePolicy) (*failingRepo, Books, UserHandler, StoryHandler) {
+	t.Helper()
+	ctx := context.Background()
+	repo := &failingRepo{Repository: repository.NewMemory()}
+	books, err := BooksInit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := UsersInit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	story, err := StoryInit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, u, s := WithIntegrity(books, users, story, policy)
+	for _, name := range []string{"first", "second"} {
+		if err := b.AddBook(ctx, BookModel{Name: name, Author: "Author", Price: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.AddUser(ctx, User{Name: "User", Surname: "Surname"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range []int{1, 2} {
+		if err := s.AddPurchase(ctx, Purchase{BookId: book, UserId: 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, b, u, s
+}
+
+func allPurchases(t *testing.T, s StoryHandler) []Purchase {
+	t.Helper()
+	list, err := s.List(context.Background(), PurchaseQuery{Page: Page{Limit: MaxLimit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list.Purchases
+}
+
+// Политика сохраняет покупки первым сохранением, удаление записи падает на
+// втором: покупки должны вернуться, а книга или пользователь остаться
+func TestRemoveRestoresPurchasesWhenParentSaveFails(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DeletePolicy
+		remove func(Books, UserHandler) error
+		exists func(Books, UserHandler) bool
+	}{
+		{
+			name:   "каскадное удаление книги",
+			policy: DeleteCascade,
+			remove: func(b Books, _ UserHandler) error { return b.RemoveBook(context.Background(), 1, AnyVersion) },
+			exists: func(b Books, _ UserHandler) bool { return b.GetBook(context.Background(), 1) != nil },
+		},
+		{
+			name:   "книга с осиротевшими покупками",
+			policy: DeleteOrphan,
+			remove: func(b Books, _ UserHandler) error { return b.RemoveBook(context.Background(), 1, AnyVersion) },
+			exists: func(b Books, _ UserHandler) bool { return b.GetBook(context.Background(), 1) != nil },
+		},
+		{
+			name:   "каскадное удаление пользователя",
+			policy: DeleteCascade,
+			remove: func(_ Books, u UserHandler) error { return u.RemoveUser(context.Background(), 0, AnyVersion) },
+			exists: func(_ Books, u UserHandler) bool { return u.GetUser(context.Background(), 0) != nil },
+		},
+		{
+			name:   "пользователь с осиротевшими покупками",
+			policy: DeleteOrphan,
+			remove: func(_ Books, u UserHandler) error { return u.RemoveUser(context.Background(), 0, AnyVersion) },
+			exists: func(_ Books, u UserHandler) bool { return u.GetUser(context.Background(), 0) != nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, b, u, s := newLibrary(t, tt.policy)
+			before := allPurchases(t, s)
+
+			repo.failOn(2)
+			if err := tt.remove(b, u); !errors.Is(err, errSave) {
+				t.Fatalf("remove error = %v, want %v", err, errSave)
+			}
+			if !tt.exists(b, u) {
+				t.Error("record was removed although its save failed")
+			}
+			if got := allPurchases(t, s); !reflect.DeepEqual(got, before) {
+				t.Errorf("purchases = %+v, want %+v", got, before)
+			}
+
+			// Восстановленное состояние тоже сохранено: после перезагрузки из
+			// хранилища покупки те же
+			story, err := StoryInit(repo.Repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := allPurchases(t, story); len(got) != len(before) {
+				t.Errorf("saved purchases = %+v, want %+v", got, before)
+			}
+		})
+	}
+}
+
+func TestRemoveAppliesPolicy(t *testing.T) {
+	tests := []struct {
+		policy  DeletePolicy
+		wantErr error
+		want    int
+		orphans int
+	}{
+		{policy: DeleteRestrict, wantErr: ErrReferenced, want: 2},
+		{policy: DeleteCascade, want: 1},
+		{policy: DeleteOrphan, want: 2, orphans: 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			_, b, _, s := newLibrary(t, tt.policy)
+			if err := b.RemoveBook(context.Background(), 1, AnyVersion); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RemoveBook error = %v, want %v", err, tt.wantErr)
+			}
+			got := allPurchases(t, s)
+			orphans := 0
+			for _, p := range got {
+				if p.Orphaned {
+					orphans++
+				}
+			}
+			if len(got) != tt.want || orphans != tt.orphans {
+				t.Errorf("got %d purchases, %d orphaned; want %d, %d", len(got), orphans, tt.want, tt.orphans)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package model
 
 import (
-	"encoding/json"
-	"errors"
-	"os"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"restapi/repository"
 	"restapi/utils"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -14,77 +18,129 @@ type Purchase struct {
 	UserId int       `json:"user_id"`
 	TookAt time.Time `json:"start_at"`
 	EndAt  time.Time `json:"end_at"`
+	// Orphaned отмечает покупки, книга или пользователь которых удалены
+	// при политике DeleteOrphan
+	Orphaned bool `json:"orphaned,omitempty"`
+	// Version растет с каждым изменением покупки, из нее строится ETag
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
 }
 
 type Story struct {
 	Purchases []Purchase `json:"purchases"`
 	Total     int        `json:"total"`
+	// NextId следующий идентификатор покупки: идентификаторы не меняются и не используются повторно
+	NextId int `json:"next_id"`
+
+	// mu защищает Purchases и Total от параллельных запросов
+	mu   sync.RWMutex
+	repo repository.Repository
 }
 
 type StoryHandler interface {
-	Get()
-	Save() error
-	GetAll() []byte
-	GetByUser(int) []byte
-	GetByBook(int) []byte
-	GetById(int) []byte
-	AddPurchase(Purchase) error
-	EndPurchase(int) error
-	DelPurchase(int) error
-	DelPurchaseByBook(int) error
-	DelPurchaseByUser(int) error
-	UpdatePurchase(Purchase) error
-}
-
-func StoryInit() StoryHandler {
-	var s Story
-	s.Get()
-	return &s
-}
-func (s *Story) Get() {
-	if file, err := os.ReadFile("./storage/purchases.json"); err != nil {
-		if os.IsNotExist(err) {
-			return
-		}
-		return
-	} else {
-		err = json.Unmarshal(file, &s)
-		if err != nil {
-			return
-		}
-	}
+	Get(context.Context) error
+	Save(context.Context) error
+	// WriteAll пишет всю историю покупок в w потоком JSON
+	WriteAll(context.Context, io.Writer) error
+	List(context.Context, PurchaseQuery) (PurchaseList, error)
+	GetByUser(context.Context, int) []byte
+	GetByBook(context.Context, int) []byte
+	GetById(context.Context, int) []byte
+	FindPurchase(context.Context, int) (Purchase, error)
+	AddPurchase(context.Context, Purchase) error
+	// EndPurchase и DelPurchase меняют покупку, только если ее версия равна
+	// переданной. Несовпадение дает ErrVersionConflict, AnyVersion отключает проверку.
+	EndPurchase(ctx context.Context, id, version int) error
+	DelPurchase(ctx context.Context, id, version int) error
+	DelPurchaseByBook(context.Context, int) error
+	DelPurchaseByUser(context.Context, int) error
+	// UpdatePurchase обновляет покупку, если ее версия равна p.Version,
+	// и увеличивает версию
+	UpdatePurchase(context.Context, Purchase) error
+	CountByBook(context.Context, int) int
+	CountByUser(context.Context, int) int
+	OrphanByBook(context.Context, int) error
+	OrphanByUser(context.Context, int) error
+	// RestorePurchases возвращает покупки в переданное состояние: записи с
+	// теми же id заменяются, удаленные добавляются обратно
+	RestorePurchases(context.Context, []Purchase) error
+}
 
+// StoryInit загружает историю покупок из repo. Если историю не удалось
+// прочитать, вместе с ошибкой возвращается пустая история, с которой
+// сервер может работать только на чтение.
+func StoryInit(repo repository.Repository) (StoryHandler, error) {
+	s := Story{repo: repo}
+	if err := s.Get(context.Background()); err != nil {
+		return &s, fmt.Errorf("load purchases: %w", err)
+	}
+	return &s, nil
+}
+func (s *Story) Get(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.repo.Load(ctx, repository.Purchases, s); err != nil {
+		return err
+	}
+	for i := range s.Purchases {
+		s.Purchases[i].Version = initialVersion(s.Purchases[i].Version)
+	}
+	if s.RepairIds() {
+		return s.save(ctx)
+	}
+	return nil
 }
-func (s *Story) AddPurchase(p Purchase) error {
-	p.Id = s.Total
-	p.TookAt = time.Now()
 
-	s.Purchases = append(s.Purchases, p)
-	s.Total++
+// RepairIds исправляет повторяющиеся идентификаторы покупок и последовательность NextId.
+// Вызывающий должен держать s.mu.
+func (s *Story) RepairIds() bool {
+	ids := make([]*int, len(s.Purchases))
+	for i := range s.Purchases {
+		ids[i] = &s.Purchases[i].Id
+	}
+	changed := repairIds(ids, &s.NextId, 0)
+	if s.Total != len(s.Purchases) {
+		s.Total = len(s.Purchases)
+		changed = true
+	}
+	return changed
+}
 
-	return s.Save()
+// commit заменяет покупки и сохраняет историю. Если сохранить не удалось,
+// прежние покупки и NextId возвращаются. Вызывающий должен держать s.mu.
+func (s *Story) commit(ctx context.Context, purchases []Purchase, nextId int) error {
+	prevPurchases, prevTotal, prevNext := s.Purchases, s.Total, s.NextId
+	s.Purchases, s.Total, s.NextId = purchases, len(purchases), nextId
+	if err := s.save(ctx); err != nil {
+		s.Purchases, s.Total, s.NextId = prevPurchases, prevTotal, prevNext
+		return err
+	}
+	return nil
 }
-func (s *Story) DelPurchase(id int) error {
+func (s *Story) AddPurchase(ctx context.Context, p Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Id = s.NextId
+	p.TookAt = time.Now()
+	p.Version, p.UpdatedAt = 1, p.TookAt
+	return s.commit(ctx, append(s.Purchases, p), p.Id+1)
+}
+func (s *Story) DelPurchase(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for i, pur := range s.Purchases {
 		if pur.Id == id {
-			if i == len(s.Purchases)-1 {
-				s.Purchases = s.Purchases[:i]
-			} else {
-				s.Purchases = append(s.Purchases[:i], s.Purchases[i:]...)
-				s.Total--
-			}
-			s.CheckStory()
-			err := s.Save()
-			if err != nil {
+			if err := checkVersion(pur.Version, version); err != nil {
 				return err
-			} else {
-				return nil
 			}
+			return s.commit(ctx, removed(s.Purchases, i), s.NextId)
 		}
 	}
-	return errors.New("there is no that id")
+	return ErrPurchaseNotFound
 }
-func (s *Story) DelPurchaseByBook(id int) error {
+func (s *Story) DelPurchaseByBook(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	temp := []Purchase{}
 	for _, pur := range s.Purchases {
 		if pur.BookId != id {
@@ -92,109 +148,218 @@ func (s *Story) DelPurchaseByBook(id int) error {
 
 		}
 	}
-	s.Purchases = temp
-	s.Total = len(s.Purchases)
-	s.CheckStory()
-	err := s.Save()
-	if err != nil {
-		return err
-	} else {
-		return nil
-	}
+	return s.commit(ctx, temp, s.NextId)
 }
 
-func (s *Story) DelPurchaseByUser(id int) error {
+func (s *Story) DelPurchaseByUser(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	temp := []Purchase{}
 	for _, pur := range s.Purchases {
 		if pur.UserId != id {
 			temp = append(temp, pur)
 		}
 	}
-	s.Purchases = temp
-	s.Total = len(s.Purchases)
-	s.CheckStory()
-	err := s.Save()
-	if err != nil {
-		return err
-	} else {
-		return nil
-	}
+	return s.commit(ctx, temp, s.NextId)
 }
 
-func (s *Story) EndPurchase(id int) error {
+func (s *Story) EndPurchase(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for i, pur := range s.Purchases {
 		if pur.Id == id {
-			s.Purchases[i].EndAt = time.Now()
-			err := s.Save()
-			if err != nil {
+			if err := checkVersion(pur.Version, version); err != nil {
 				return err
-			} else {
-				return nil
 			}
-
+			now := time.Now()
+			pur.EndAt = now
+			pur.Version, pur.UpdatedAt = pur.Version+1, now
+			return s.commit(ctx, replaced(s.Purchases, i, pur), s.NextId)
 		}
 	}
-	return errors.New("there is no id")
+	return ErrPurchaseNotFound
 }
-func (s *Story) GetAll() []byte {
-	return utils.MarshalThis(s.Purchases)
+func (s *Story) WriteAll(ctx context.Context, w io.Writer) error {
+	s.mu.RLock()
+	purchases := s.Purchases
+	s.mu.RUnlock()
+
+	js := utils.NewJSONStream(ctx, w)
+	BeginList(js, "purchases")
+	for i, p := range purchases {
+		js.Elem(i, p)
+	}
+	EndList(js, len(purchases))
+	return js.Close()
 }
-func (s *Story) GetByBook(id int) []byte {
+func (s *Story) GetByBook(ctx context.Context, id int) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var res []Purchase
 	for _, pur := range s.Purchases {
 		if pur.BookId == id {
 			res = append(res, pur)
 		}
 	}
-	return utils.MarshalThis(res)
+	return utils.MarshalThis(ctx, res)
 }
-func (s *Story) GetById(id int) []byte {
+func (s *Story) GetById(ctx context.Context, id int) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, pur := range s.Purchases {
 		if pur.Id == id {
-			return utils.MarshalThis(pur)
+			return utils.MarshalThis(ctx, pur)
 		}
 	}
 	return nil
 }
-func (s *Story) GetByUser(id int) []byte {
+func (s *Story) FindPurchase(ctx context.Context, id int) (Purchase, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, pur := range s.Purchases {
+		if pur.Id == id {
+			return pur, nil
+		}
+	}
+	return Purchase{}, ErrPurchaseNotFound
+}
+func (s *Story) GetByUser(ctx context.Context, id int) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var res []Purchase
 	for _, pur := range s.Purchases {
 		if pur.UserId == id {
 			res = append(res, pur)
 		}
 	}
-	return utils.MarshalThis(res)
+	return utils.MarshalThis(ctx, res)
 }
-func (s *Story) Save() error {
-	data, err := json.Marshal(s)
-	if err != nil {
-		return err
+func (s *Story) Save(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.save(ctx)
+}
+
+// save сохраняет историю, вызывающий должен держать s.mu
+func (s *Story) save(ctx context.Context) error {
+	return s.repo.Save(ctx, repository.Purchases, s)
+}
+func (s *Story) UpdatePurchase(ctx context.Context, p Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pur := range s.Purchases {
+		if pur.Id == p.Id {
+			if err := checkVersion(pur.Version, p.Version); err != nil {
+				return err
+			}
+			// Даты аренды и отметка осиротевшей покупки не меняются
+			// при обновлении книги или пользователя
+			p.TookAt, p.EndAt, p.Orphaned = pur.TookAt, pur.EndAt, pur.Orphaned
+			p.Version, p.UpdatedAt = pur.Version+1, time.Now()
+			return s.commit(ctx, replaced(s.Purchases, i, p), s.NextId)
+		}
 	}
 
-	if err := os.WriteFile("./storage/purchases.json", data, 0644); err != nil {
-		return err
+	return ErrPurchaseNotFound
+}
+
+func (s *Story) CountByBook(ctx context.Context, id int) int {
+	return s.count(func(p Purchase) bool { return p.BookId == id })
+}
+
+func (s *Story) CountByUser(ctx context.Context, id int) int {
+	return s.count(func(p Purchase) bool { return p.UserId == id })
+}
+
+func (s *Story) count(match func(Purchase) bool) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, pur := range s.Purchases {
+		if match(pur) {
+			n++
+		}
 	}
+	return n
+}
 
-	return nil
+func (s *Story) OrphanByBook(ctx context.Context, id int) error {
+	return s.orphan(ctx, func(p Purchase) bool { return p.BookId == id })
 }
-func (s *Story) UpdatePurchase(p Purchase) error {
+
+func (s *Story) OrphanByUser(ctx context.Context, id int) error {
+	return s.orphan(ctx, func(p Purchase) bool { return p.UserId == id })
+}
+
+// orphan помечает подходящие покупки осиротевшими
+func (s *Story) orphan(ctx context.Context, match func(Purchase) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purchases []Purchase
+	now := time.Now()
 	for i, pur := range s.Purchases {
-		if pur.Id == p.Id {
-			s.Purchases[i] = p
-			err := s.Save()
-			if err != nil {
-				return err
+		if match(pur) && !pur.Orphaned {
+			if purchases == nil {
+				purchases = slices.Clone(s.Purchases)
 			}
-			return nil
+			purchases[i].Orphaned = true
+			purchases[i].Version++
+			purchases[i].UpdatedAt = now
+		}
+	}
+	if purchases == nil {
+		return nil
+	}
+	return s.commit(ctx, purchases, s.NextId)
+}
+
+func (s *Story) RestorePurchases(ctx context.Context, purchases []Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := make(map[int]Purchase, len(purchases))
+	for _, p := range purchases {
+		saved[p.Id] = p
+	}
+	res := make([]Purchase, 0, len(s.Purchases)+len(purchases))
+	for _, pur := range s.Purchases {
+		if p, ok := saved[pur.Id]; ok {
+			pur = p
+			delete(saved, p.Id)
 		}
+		res = append(res, pur)
 	}
+	for _, p := range saved {
+		res = append(res, p)
+	}
+	slices.SortFunc(res, purchaseCompare["id"])
+	return s.commit(ctx, res, s.NextId)
+}
 
-	return errors.New("not found")
+// purchaseCompare сравнение покупок по полям сортировки
+var purchaseCompare = map[string]func(a, b Purchase) int{
+	"id":       func(a, b Purchase) int { return cmp.Compare(a.Id, b.Id) },
+	"book_id":  func(a, b Purchase) int { return cmp.Compare(a.BookId, b.BookId) },
+	"user_id":  func(a, b Purchase) int { return cmp.Compare(a.UserId, b.UserId) },
+	"start_at": func(a, b Purchase) int { return compareTime(a.TookAt, b.TookAt) },
+	"end_at":   func(a, b Purchase) int { return compareTime(a.EndAt, b.EndAt) },
 }
 
-func (s *Story) CheckStory() {
-	for i := range s.Purchases {
-		s.Purchases[i].Id = i
+func (s *Story) List(ctx context.Context, q PurchaseQuery) (PurchaseList, error) {
+	if err := q.Normalize(PurchaseSortFields); err != nil {
+		return PurchaseList{}, err
+	}
+	match := func(p Purchase) bool {
+		return (q.BookId == nil || p.BookId == *q.BookId) &&
+			(q.UserId == nil || p.UserId == *q.UserId) &&
+			(q.Active == nil || p.EndAt.IsZero() == *q.Active)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	purchases, total, next, err := listPage(s.Purchases, q.Page, match, purchaseCompare,
+		func(p Purchase) int { return p.Id })
+	if err != nil {
+		return PurchaseList{}, err
 	}
-	s.Total = len(s.Purchases)
+	return PurchaseList{Purchases: purchases, Total: total, Limit: q.Limit, Offset: q.Offset, NextCursor: next}, nil
 }
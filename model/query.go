@@ -0,0 +1,228 @@
+package model
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"restapi/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// ErrInvalidQuery возвращается при неверных параметрах выборки
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Page параметры постраничной выборки и сортировки коллекции.
+// Cursor, если задан, заменяет Offset: выборка продолжается
+// сразу после записи, из которой курсор был получен.
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor string
+	Sort   string
+	Desc   bool
+}
+
+// BookQuery выборка книг
+type BookQuery struct {
+	Page
+	Author   string
+	PriceMin *float64
+	PriceMax *float64
+}
+
+// UserQuery выборка пользователей
+type UserQuery struct {
+	Page
+	Name    string
+	Surname string
+}
+
+// PurchaseQuery выборка истории покупок
+type PurchaseQuery struct {
+	Page
+	BookId *int
+	UserId *int
+	// Active отбирает открытые (true) или завершенные (false) аренды
+	Active *bool
+}
+
+// BeginList и EndList окружают поток элементов коллекции полями страницы.
+// Коллекция целиком отдается той же формой, что и BookList, UserList и
+// PurchaseList: все записи одной страницей, limit равен total.
+func BeginList(s *utils.JSONStream, field string) {
+	s.Raw(`{"` + field + `":[`)
+}
+
+func EndList(s *utils.JSONStream, total int) {
+	s.Raw(`],"total":`)
+	s.Value(total)
+	s.Raw(`,"limit":`)
+	s.Value(total)
+	s.Raw(`,"offset":0}`)
+}
+
+// BookList страница книг
+// @Description Страница коллекции книг
+type BookList struct {
+	Books      []BookModel `json:"books"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// UserList страница пользователей
+// @Description Страница коллекции пользователей
+type UserList struct {
+	Users      []User `json:"users"`
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PurchaseList страница истории покупок
+// @Description Страница истории покупок
+type PurchaseList struct {
+	Purchases  []Purchase `json:"purchases"`
+	Total      int        `json:"total"`
+	Limit      int        `json:"limit"`
+	Offset     int        `json:"offset"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Поля, по которым можно сортировать коллекции. Имена совпадают с JSON тегами.
+var (
+	BookSortFields     = []string{"id", "name", "author", "price"}
+	UserSortFields     = []string{"id", "name", "surname"}
+	PurchaseSortFields = []string{"id", "book_id", "user_id", "start_at", "end_at"}
+)
+
+// Normalize проверяет параметры и подставляет значения по умолчанию
+func (p *Page) Normalize(fields []string) error {
+	if p.Sort == "" {
+		p.Sort = "id"
+	}
+	if !slices.Contains(fields, p.Sort) {
+		return fmt.Errorf("%w: cannot sort by %q, use one of %s", ErrInvalidQuery, p.Sort, strings.Join(fields, ", "))
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+	if p.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// cursor содержимое непрозрачного курсора: поле сортировки, направление
+// и значения ключа последней выданной записи
+type cursor struct {
+	Sort string          `json:"s"`
+	Desc bool            `json:"d"`
+	Key  json.RawMessage `json:"k"`
+}
+
+// EncodeCursor создает курсор, указывающий на запись item.
+// В курсор попадают только id и поле сортировки.
+func EncodeCursor(p Page, item any) string {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return ""
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+	key, _ := json.Marshal(map[string]json.RawMessage{
+		"id":   fields["id"],
+		p.Sort: fields[p.Sort],
+	})
+	data, _ = json.Marshal(cursor{Sort: p.Sort, Desc: p.Desc, Key: key})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor восстанавливает из курсора ключ записи в into.
+// Курсор действителен только для той же сортировки.
+func DecodeCursor(p Page, into any) error {
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != p.Sort || c.Desc != p.Desc {
+		return fmt.Errorf("%w: cursor was issued for another sort order", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(c.Key, into); err != nil {
+		return fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// listPage фильтрует, сортирует и режет items согласно p.
+// compare сравнивает записи по полям сортировки, id возвращает идентификатор.
+// Возвращает страницу, общее число подходящих записей и курсор следующей страницы.
+func listPage[T any](items []T, p Page, match func(T) bool,
+	compare map[string]func(a, b T) int, id func(T) int) ([]T, int, string, error) {
+
+	res := []T{}
+	for _, item := range items {
+		if match(item) {
+			res = append(res, item)
+		}
+	}
+	total := len(res)
+
+	byField := compare[p.Sort]
+	order := func(a, b T) int {
+		c := byField(a, b)
+		if c == 0 {
+			c = cmp.Compare(id(a), id(b))
+		}
+		if p.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortStableFunc(res, order)
+
+	if p.Cursor != "" {
+		var after T
+		if err := DecodeCursor(p, &after); err != nil {
+			return nil, 0, "", err
+		}
+		i, _ := slices.BinarySearchFunc(res, after, order)
+		for i < len(res) && order(res[i], after) <= 0 {
+			i++
+		}
+		res = res[i:]
+	} else {
+		res = res[min(p.Offset, len(res)):]
+	}
+
+	next := ""
+	if len(res) > p.Limit {
+		res = res[:p.Limit]
+		next = EncodeCursor(p, res[len(res)-1])
+	}
+	return res, total, next, nil
+}
+
+func compareTime(a, b time.Time) int {
+	return a.Compare(b)
+}
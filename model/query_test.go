@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"restapi/repository"
+	"testing"
+)
+
+// newBooks создает библиотеку с книгами 1..len(prices) с заданными ценами
+func newBooks(t *testing.T, prices ...float64) Books {
+	t.Helper()
+	books, err := BooksInit(repository.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range prices {
+		if err := books.AddBook(context.Background(), BookModel{Name: "Book", Author: "Author", Price: price}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return books
+}
+
+func bookIds(books []BookModel) []int {
+	ids := []int{}
+	for _, b := range books {
+		ids = append(ids, b.Id)
+	}
+	return ids
+}
+
+func TestListBooksPage(t *testing.T) {
+	books := newBooks(t, 300, 100, 200, 100, 300, 100)
+	tests := []struct {
+		name  string
+		page  Page
+		want  []int
+		next  bool
+		total int
+	}{
+		{name: "по умолчанию по id", page: Page{}, want: []int{1, 2, 3, 4, 5, 6}, total: 6},
+		{name: "первая страница", page: Page{Limit: 2}, want: []int{1, 2}, next: true, total: 6},
+		{name: "смещение", page: Page{Limit: 2, Offset: 4}, want: []int{5, 6}, total: 6},
+		{name: "смещение за концом", page: Page{Limit: 2, Offset: 10}, want: []int{}, total: 6},
+		{name: "равные цены по id", page: Page{Sort: "price"}, want: []int{2, 4, 6, 3, 1, 5}, total: 6},
+		{name: "по убыванию цены", page: Page{Sort: "price", Desc: true, Limit: 4}, want: []int{5, 1, 3, 6}, next: true, total: 6},
+		{name: "лимит больше максимума", page: Page{Limit: MaxLimit + 1}, want: []int{1, 2, 3, 4, 5, 6}, total: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := books.ListBooks(context.Background(), BookQuery{Page: tt.page})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bookIds(list.Books); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+			if (list.NextCursor != "") != tt.next || list.Total != tt.total {
+				t.Errorf("next cursor %q, total %d; want cursor %v, total %d", list.NextCursor, list.Total, tt.next, tt.total)
+			}
+		})
+	}
+}
+
+// Обход по курсорам выдает каждую запись ровно один раз в порядке сортировки
+func TestListBooksCursorWalk(t *testing.T) {
+	books := newBooks(t, 300, 100, 200, 100, 300, 100, 200)
+	for _, page := range []Page{
+		{Limit: 3},
+		{Limit: 2, Sort: "price"},
+		{Limit: 2, Sort: "price", Desc: true},
+		{Limit: 1, Sort: "name"},
+	} {
+		full, err := books.ListBooks(context.Background(), BookQuery{Page: Page{Sort: page.Sort, Desc: page.Desc}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for pages := 0; ; pages++ {
+			if pages > len(full.Books) {
+				t.Fatalf("%+v: cursor walk does not end", page)
+			}
+			list, err := books.ListBooks(context.Background(), BookQuery{Page: page})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, bookIds(list.Books)...)
+			if list.NextCursor == "" {
+				break
+			}
+			page.Cursor = list.NextCursor
+		}
+		if want := bookIds(full.Books); !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: walked %v, want %v", page, got, want)
+		}
+	}
+}
+
+// Курсор, в отличие от смещения, не сдвигается, когда перед ним
+// появляются или исчезают записи
+func TestListBooksCursorStable(t *testing.T) {
+	ctx := context.Background()
+	books := newBooks(t, 100, 200, 300, 400)
+	page := Page{Limit: 2, Sort: "price"}
+	first, err := books.ListBooks(ctx, BookQuery{Page: page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := books.AddBook(ctx, BookModel{Name: "Cheap", Price: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.RemoveBook(ctx, 1, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	page.Cursor = first.NextCursor
+	second, err := books.ListBooks(ctx, BookQuery{Page: page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bookIds(second.Books); !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Errorf("page after cursor = %v, want [3 4]", got)
+	}
+}
+
+func TestListBooksInvalidPage(t *testing.T) {
+	books := newBooks(t, 100, 200, 300)
+	list, err := books.ListBooks(context.Background(), BookQuery{Page: Page{Limit: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		page Page
+	}{
+		{name: "неизвестное поле", page: Page{Sort: "isbn"}},
+		{name: "отрицательное смещение", page: Page{Offset: -1}},
+		{name: "испорченный курсор", page: Page{Cursor: "not a cursor"}},
+		{name: "курсор не в base64 json", page: Page{Cursor: "e30x"}},
+		{name: "курсор другой сортировки", page: Page{Sort: "price", Cursor: list.NextCursor}},
+		{name: "курсор другого направления", page: Page{Desc: true, Cursor: list.NextCursor}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := books.ListBooks(context.Background(), BookQuery{Page: tt.page}); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("error = %v, want ErrInvalidQuery", err)
+			}
+		})
+	}
+}
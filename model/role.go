@@ -0,0 +1,33 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Роли пользователей
+const (
+	// RoleAdmin управляет пользователями, их ролями и ключами API
+	RoleAdmin = "admin"
+	// RoleLibrarian ведет каталог книг и выдачу
+	RoleLibrarian = "librarian"
+	// RoleMember читатель, видит каталог и свою историю
+	RoleMember = "member"
+)
+
+// Roles все роли от старшей к младшей
+var Roles = []string{RoleAdmin, RoleLibrarian, RoleMember}
+
+// ErrInvalidRole возвращается при неизвестной роли
+var ErrInvalidRole = errors.New("invalid role")
+
+// ParseRole проверяет роль, пустая строка означает RoleMember
+func ParseRole(s string) (string, error) {
+	switch s {
+	case "":
+		return RoleMember, nil
+	case RoleAdmin, RoleLibrarian, RoleMember:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w %q, use admin, librarian or member", ErrInvalidRole, s)
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"restapi/search"
+	"strings"
+	"sync"
+)
+
+// Веса полей книги в полнотекстовом поиске
+const (
+	searchNameWeight   = 2
+	searchAuthorWeight = 1
+)
+
+// BookHit найденная книга
+// @Description Книга из результатов поиска и ее релевантность
+type BookHit struct {
+	Book  BookModel `json:"book"`
+	Score float64   `json:"score"`
+}
+
+// BookSearchResult страница результатов поиска
+// @Description Результаты полнотекстового поиска книг, от самых релевантных
+type BookSearchResult struct {
+	Query  string    `json:"query"`
+	Hits   []BookHit `json:"hits"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+}
+
+// BookSearcher полнотекстовый поиск по названию и автору книги
+type BookSearcher interface {
+	SearchBooks(ctx context.Context, query string, p Page) (BookSearchResult, error)
+}
+
+// SearchableBooks дополняет хранилище книг индексом полнотекстового поиска.
+// Индекс строится при создании и обновляется при добавлении, изменении и удалении книг,
+// поэтому все изменения должны проходить через эту обертку.
+type SearchableBooks struct {
+	Books
+
+	// mu упорядочивает изменения: книга попадает в индекс
+	// в том же порядке, в каком она записана в хранилище
+	mu    sync.RWMutex
+	index *search.Index
+	books map[int]BookModel
+}
+
+// WithSearch строит индекс по всем книгам из books. Если книги не удалось
+// прочитать, вместе с ошибкой возвращается обертка с неполным индексом.
+func WithSearch(books Books) (*SearchableBooks, error) {
+	s := &SearchableBooks{Books: books, index: search.NewIndex(), books: map[int]BookModel{}}
+
+	// Индекс строится при запуске, вне запроса
+	ctx := context.Background()
+	q := BookQuery{Page: Page{Limit: MaxLimit}}
+	for {
+		list, err := books.ListBooks(ctx, q)
+		if err != nil {
+			return s, fmt.Errorf("build search index: %w", err)
+		}
+		for _, b := range list.Books {
+			s.add(b)
+		}
+		if list.NextCursor == "" {
+			return s, nil
+		}
+		q.Cursor = list.NextCursor
+	}
+}
+
+// add индексирует книгу, вызывающий должен держать s.mu
+func (s *SearchableBooks) add(b BookModel) {
+	s.books[b.Id] = b
+	s.index.Add(b.Id,
+		search.Field{Text: b.Name, Weight: searchNameWeight},
+		search.Field{Text: b.Author, Weight: searchAuthorWeight})
+}
+
+func (s *SearchableBooks) AddBook(ctx context.Context, book BookModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Books.AddBook(ctx, book); err != nil {
+		return err
+	}
+	// Идентификатор назначает хранилище: последняя добавленная книга имеет наибольший id
+	last, err := s.Books.ListBooks(ctx, BookQuery{Page: Page{Limit: 1, Desc: true}})
+	if err != nil {
+		return err
+	}
+	if len(last.Books) > 0 {
+		s.add(last.Books[0])
+	}
+	return nil
+}
+
+func (s *SearchableBooks) UpdateBook(ctx context.Context, book BookModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Books.UpdateBook(ctx, book); err != nil {
+		return err
+	}
+	// Версию и время изменения назначает хранилище
+	updated, err := s.Books.FindBook(ctx, book.Id)
+	if err != nil {
+		return err
+	}
+	s.add(updated)
+	return nil
+}
+
+func (s *SearchableBooks) RemoveBook(ctx context.Context, id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Books.RemoveBook(ctx, id, version); err != nil {
+		return err
+	}
+	delete(s.books, id)
+	s.index.Remove(id)
+	return nil
+}
+
+func (s *SearchableBooks) SearchBooks(ctx context.Context, query string, p Page) (BookSearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return BookSearchResult{}, fmt.Errorf("%w: search query must not be empty", ErrInvalidQuery)
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	p.Limit = min(p.Limit, MaxLimit)
+	if p.Offset < 0 {
+		return BookSearchResult{}, fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits := s.index.Search(query)
+	res := BookSearchResult{Query: query, Hits: []BookHit{}, Total: len(hits), Limit: p.Limit, Offset: p.Offset}
+	for _, h := range hits[min(p.Offset, len(hits)):min(p.Offset+p.Limit, len(hits))] {
+		res.Hits = append(res.Hits, BookHit{Book: s.books[h.ID], Score: h.Score})
+	}
+	return res, nil
+}
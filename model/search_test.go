@@ -0,0 +1,123 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func hitIds(res BookSearchResult) []int {
+	ids := []int{}
+	for _, h := range res.Hits {
+		ids = append(ids, h.Book.Id)
+	}
+	return ids
+}
+
+func newSearchable(t *testing.T) *SearchableBooks {
+	t.Helper()
+	books := newBooks(t)
+	ctx := context.Background()
+	// Одна книга уже в хранилище до построения индекса
+	if err := books.AddBook(ctx, BookModel{Name: "Война и мир", Author: "Лев Толстой"}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := WithSearch(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []BookModel{
+		{Name: "Анна Каренина", Author: "Лев Толстой"},
+		{Name: "Толстой", Author: "Павел Басинский"},
+	} {
+		if err := s.AddBook(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestSearchBooks(t *testing.T) {
+	s := newSearchable(t)
+	tests := []struct {
+		name    string
+		query   string
+		page    Page
+		want    []int
+		total   int
+		wantErr error
+	}{
+		{name: "книга из хранилища", query: "войны", want: []int{1}, total: 1},
+		{name: "название выше автора", query: "толстой", want: []int{3, 2, 1}, total: 3},
+		{name: "страница", query: "толстой", page: Page{Limit: 1, Offset: 1}, want: []int{2}, total: 3},
+		{name: "смещение за концом", query: "толстой", page: Page{Offset: 5}, want: []int{}, total: 3},
+		{name: "нет совпадений", query: "пушкин", want: []int{}},
+		{name: "пустой запрос", query: "  ", wantErr: ErrInvalidQuery},
+		{name: "отрицательное смещение", query: "лев", page: Page{Offset: -1}, wantErr: ErrInvalidQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.SearchBooks(context.Background(), tt.query, tt.page)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := hitIds(res); !reflect.DeepEqual(got, tt.want) || res.Total != tt.total {
+				t.Errorf("hits %v of %d, want %v of %d", got, res.Total, tt.want, tt.total)
+			}
+		})
+	}
+}
+
+// Изменения книг сразу видны в поиске, а найденная книга совпадает с
+// хранилищем, включая версию
+func TestSearchBooksFollowsChanges(t *testing.T) {
+	ctx := context.Background()
+	s := newSearchable(t)
+
+	book, err := s.FindBook(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Name = "Воскресение"
+	if err := s.UpdateBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveBook(ctx, 1, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{query: "каренина", want: []int{}},
+		{query: "воскресение", want: []int{2}},
+		{query: "войны", want: []int{}},
+		{query: "толстой", want: []int{3, 2}},
+	}
+	for _, tt := range tests {
+		res, err := s.SearchBooks(ctx, tt.query, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hitIds(res); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: hits %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	stored, err := s.FindBook(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.SearchBooks(ctx, "воскресение", Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Book != stored {
+		t.Errorf("hit = %+v, want stored book %+v", res.Hits, stored)
+	}
+}
@@ -0,0 +1,36 @@
+package model
+
+// repairIds чинит идентификаторы коллекции и ее последовательность next.
+//
+// Старые версии хранилища выдавали идентификаторы из счетчика Total, поэтому после
+// удалений они повторялись. Повторные идентификаторы получают новые значения
+// из последовательности, а next сдвигается за максимальный выданный идентификатор,
+// но не меньше first. Возвращает true, если что-то изменилось.
+func repairIds(ids []*int, next *int, first int) bool {
+	changed := false
+	if *next < first {
+		*next = first
+		changed = true
+	}
+
+	seen := make(map[int]bool, len(ids))
+	var dups []*int
+	for _, id := range ids {
+		if seen[*id] {
+			dups = append(dups, id)
+			continue
+		}
+		seen[*id] = true
+		if *id >= *next {
+			*next = *id + 1
+			changed = true
+		}
+	}
+
+	for _, id := range dups {
+		*id = *next
+		*next++
+		changed = true
+	}
+	return changed
+}
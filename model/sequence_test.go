@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"reflect"
+	"restapi/repository"
+	"testing"
+)
+
+func TestRepairIds(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      []int
+		next     int
+		first    int
+		wantIds  []int
+		wantNext int
+		changed  bool
+	}{
+		{name: "пустая коллекция", ids: nil, next: 0, first: 1, wantIds: nil, wantNext: 1, changed: true},
+		{name: "исправная коллекция", ids: []int{1, 2, 4}, next: 7, first: 1, wantIds: []int{1, 2, 4}, wantNext: 7},
+		{name: "next отстает от идентификаторов", ids: []int{0, 1, 5}, next: 2, first: 0, wantIds: []int{0, 1, 5}, wantNext: 6, changed: true},
+		{name: "повтор после удаления", ids: []int{0, 1, 1}, next: 2, first: 0, wantIds: []int{0, 1, 2}, wantNext: 3, changed: true},
+		{name: "повторы получают номера по порядку", ids: []int{3, 3, 1, 3}, next: 4, first: 1, wantIds: []int{3, 4, 1, 5}, wantNext: 6, changed: true},
+		{name: "повтор не занимает номер удаленной записи", ids: []int{1, 1}, next: 9, first: 1, wantIds: []int{1, 9}, wantNext: 10, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := append([]int(nil), tt.ids...)
+			ptrs := make([]*int, len(ids))
+			for i := range ids {
+				ptrs[i] = &ids[i]
+			}
+			next := tt.next
+			changed := repairIds(ptrs, &next, tt.first)
+			if !reflect.DeepEqual(ids, tt.wantIds) || next != tt.wantNext || changed != tt.changed {
+				t.Errorf("got ids %v, next %d, changed %v; want %v, %d, %v",
+					ids, next, changed, tt.wantIds, tt.wantNext, tt.changed)
+			}
+		})
+	}
+}
+
+// Файлы старых версий исправляются при загрузке и сразу сохраняются
+func TestInitRepairsStoredIds(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory()
+	saves := map[string]any{
+		repository.Books: &Library{TotalBooks: 2, Books: []BookModel{
+			{Id: 1, Name: "first"}, {Id: 1, Name: "second"},
+		}},
+		repository.Users: &Users{Total: 2, NextId: 2, Users: []User{
+			{Id: 0, Name: "first"}, {Id: 1, Name: "second"}, {Id: 1, Name: "third"},
+		}},
+		repository.Purchases: &Story{Total: 1, NextId: 1, Purchases: []Purchase{
+			{Id: 0, BookId: 1}, {Id: 0, BookId: 2},
+		}},
+	}
+	for name, v := range saves {
+		if err := repo.Save(ctx, name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := BooksInit(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UsersInit(repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StoryInit(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	var l Library
+	if err := repo.Load(ctx, repository.Books, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.NextId != 3 || l.TotalBooks != 2 || l.Books[1].Id != 2 {
+		t.Errorf("saved books = %+v, want second book renumbered to 2 and NextId 3", &l)
+	}
+	var u Users
+	if err := repo.Load(ctx, repository.Users, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.NextId != 3 || u.Total != 3 || u.Users[2].Id != 2 {
+		t.Errorf("saved users = %+v, want third user renumbered to 2 and NextId 3", &u)
+	}
+	var s Story
+	if err := repo.Load(ctx, repository.Purchases, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.NextId != 2 || s.Total != 2 || s.Purchases[1].Id != 1 {
+		t.Errorf("saved purchases = %+v, want second purchase renumbered to 1 and NextId 2", &s)
+	}
+}
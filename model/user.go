@@ -1,135 +1,229 @@
 package model
 
 import (
-	"encoding/json"
-	"errors"
+	"cmp"
+	"context"
+	"fmt"
 	"io"
-	"os"
+	"restapi/repository"
 	"restapi/utils"
+	"strings"
+	"sync"
+	"time"
 )
 
 type User struct {
 	Id      int    `json:"id"`
 	Name    string `json:"name"`
 	Surname string `json:"surname"`
+	// Role роль пользователя: admin, librarian или member
+	Role string `json:"role"`
+	// Version растет с каждым изменением пользователя, из нее строится ETag
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
 }
 
 type Users struct {
 	Users []User `json:"users"`
 	Total int    `json:"total"`
+	// NextId следующий идентификатор пользователя, идентификаторы не используются повторно
+	NextId int `json:"next_id"`
+
+	// mu защищает Users и Total от параллельных запросов
+	mu   sync.RWMutex
+	repo repository.Repository
 }
 
 type UserHandler interface {
-	Get() error
-	Save() error
-	AddUser(user User) error
-	UpdateUser(user User) error
-	RemoveUser(id int) error
-	GetUser(id int) []byte
-	GetAllUsers() []byte
-	GetCount() []byte
+	Get(ctx context.Context) error
+	Save(ctx context.Context) error
+	AddUser(ctx context.Context, user User) error
+	// UpdateUser обновляет пользователя, если его версия равна user.Version,
+	// и увеличивает версию
+	UpdateUser(ctx context.Context, user User) error
+	// RemoveUser удаляет пользователя, если его версия равна version.
+	// Несовпадение версии дает ErrVersionConflict, AnyVersion отключает проверку.
+	RemoveUser(ctx context.Context, id, version int) error
+	GetUser(ctx context.Context, id int) []byte
+	FindUser(ctx context.Context, id int) (User, error)
+	// WriteAllUsers пишет всех пользователей в w потоком JSON
+	WriteAllUsers(ctx context.Context, w io.Writer) error
+	ListUsers(ctx context.Context, q UserQuery) (UserList, error)
+	GetCount(ctx context.Context) []byte
 }
 
-func UsersInit() UserHandler {
-	var u Users
-	u.Get()
-	return &u
+// UsersInit загружает пользователей из repo. Если их не удалось прочитать,
+// вместе с ошибкой возвращается пустой список, с которым сервер может
+// работать только на чтение.
+func UsersInit(repo repository.Repository) (UserHandler, error) {
+	u := Users{repo: repo}
+	if err := u.Get(context.Background()); err != nil {
+		return &u, fmt.Errorf("load users: %w", err)
+	}
+	return &u, nil
 }
 
-func (u *Users) Get() error {
-	data, err := os.ReadFile("./storage/users.json")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+func (u *Users) Get(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.repo.Load(ctx, repository.Users, u); err != nil {
 		return err
 	}
-
-	if err := json.Unmarshal(data, &u); err != nil {
-		if err == io.EOF {
-			return nil
-		} else {
-			return err
+	// Пользователи, созданные до появления ролей, становятся читателями
+	for i := range u.Users {
+		if u.Users[i].Role == "" {
+			u.Users[i].Role = RoleMember
 		}
+		u.Users[i].Version = initialVersion(u.Users[i].Version)
+	}
+	if u.RepairIds() {
+		return u.save(ctx)
 	}
-
 	return nil
 }
 
-func (u *Users) Save() error {
-	if data, err := json.Marshal(u); err != nil {
-		return err
-	} else {
-		return os.WriteFile("./storage/users.json", data, 0644)
+// RepairIds исправляет повторяющиеся идентификаторы пользователей и последовательность NextId.
+// Вызывающий должен держать u.mu.
+func (u *Users) RepairIds() bool {
+	ids := make([]*int, len(u.Users))
+	for i := range u.Users {
+		ids[i] = &u.Users[i].Id
+	}
+	changed := repairIds(ids, &u.NextId, 0)
+	if u.Total != len(u.Users) {
+		u.Total = len(u.Users)
+		changed = true
 	}
+	return changed
 }
 
-func (u *Users) AddUser(user User) error {
-	if u.Users == nil {
-		user.Id = 0
-		u.Users = []User{user}
-		u.Total = 1
-	} else {
+func (u *Users) Save(ctx context.Context) error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.save(ctx)
+}
 
-		user.Id = u.Total
-		u.Users = append(u.Users, user)
-		u.Total++
-	}
+// save сохраняет пользователей, вызывающий должен держать u.mu
+func (u *Users) save(ctx context.Context) error {
+	return u.repo.Save(ctx, repository.Users, u)
+}
 
-	err := u.Save()
-	if err != nil {
-		return nil
-	} else {
+// commit заменяет пользователей и сохраняет их. Если сохранить не удалось,
+// прежние пользователи и NextId возвращаются. Вызывающий должен держать u.mu.
+func (u *Users) commit(ctx context.Context, users []User, nextId int) error {
+	prevUsers, prevTotal, prevNext := u.Users, u.Total, u.NextId
+	u.Users, u.Total, u.NextId = users, len(users), nextId
+	if err := u.save(ctx); err != nil {
+		u.Users, u.Total, u.NextId = prevUsers, prevTotal, prevNext
 		return err
 	}
+	return nil
 }
 
-func (u *Users) UpdateUser(user User) error {
+func (u *Users) AddUser(ctx context.Context, user User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if user.Role == "" {
+		user.Role = RoleMember
+	}
+	user.Id = u.NextId
+	user.Version, user.UpdatedAt = 1, time.Now()
+	return u.commit(ctx, append(u.Users, user), user.Id+1)
+}
+
+func (u *Users) UpdateUser(ctx context.Context, user User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 	for i, us := range u.Users {
 		if us.Id == user.Id {
-			if i == len(u.Users)-1 {
-				u.Users = u.Users[:i]
-			} else {
-				u.Users = append(u.Users[:i], u.Users[i+1:]...)
+			if err := checkVersion(us.Version, user.Version); err != nil {
+				return err
+			}
+			user.Version, user.UpdatedAt = us.Version+1, time.Now()
+			if user.Role == "" {
+				user.Role = us.Role
 			}
-			return nil
+			return u.commit(ctx, replaced(u.Users, i, user), u.NextId)
 		}
 	}
-	return errors.New("id not found")
+	return ErrUserNotFound
 }
 
-func (u *Users) RemoveUser(id int) error {
+func (u *Users) RemoveUser(ctx context.Context, id, version int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
 	for i, user := range u.Users {
 		if user.Id == id {
-			if i == len(u.Users)-1 {
-				u.Users = u.Users[:i]
-			} else {
-				u.Users = append(u.Users[:i], u.Users[i+1:]...)
+			if err := checkVersion(user.Version, version); err != nil {
+				return err
 			}
-			u.Total--
-			u.CheckUsers()
-			return nil
+			return u.commit(ctx, removed(u.Users, i), u.NextId)
 		}
 	}
 
-	return errors.New("User not found")
+	return ErrUserNotFound
 }
-func (u *Users) GetUser(id int) []byte {
+func (u *Users) GetUser(ctx context.Context, id int) []byte {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
 	for _, user := range u.Users {
 		if user.Id == id {
-			return utils.MarshalThis(user)
+			return utils.MarshalThis(ctx, user)
 		}
 	}
 	return nil
 }
-func (u *Users) GetAllUsers() []byte {
-	return utils.MarshalThis(u)
+func (u *Users) FindUser(ctx context.Context, id int) (User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	for _, user := range u.Users {
+		if user.Id == id {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
 }
-func (u *Users) GetCount() []byte {
-	return utils.MarshalThis(u.Total)
+func (u *Users) WriteAllUsers(ctx context.Context, w io.Writer) error {
+	u.mu.RLock()
+	users, total := u.Users, u.Total
+	u.mu.RUnlock()
+
+	s := utils.NewJSONStream(ctx, w)
+	BeginList(s, "users")
+	for i, user := range users {
+		s.Elem(i, user)
+	}
+	EndList(s, total)
+	return s.Close()
 }
-func (u *Users) CheckUsers() {
-	for i := range u.Users {
-		u.Users[i].Id = i
+func (u *Users) GetCount(ctx context.Context) []byte {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return utils.MarshalThis(ctx, u.Total)
+}
+
+// userCompare сравнение пользователей по полям сортировки
+var userCompare = map[string]func(a, b User) int{
+	"id":      func(a, b User) int { return cmp.Compare(a.Id, b.Id) },
+	"name":    func(a, b User) int { return strings.Compare(a.Name, b.Name) },
+	"surname": func(a, b User) int { return strings.Compare(a.Surname, b.Surname) },
+}
+
+func (u *Users) ListUsers(ctx context.Context, q UserQuery) (UserList, error) {
+	if err := q.Normalize(UserSortFields); err != nil {
+		return UserList{}, err
+	}
+	match := func(user User) bool {
+		return (q.Name == "" || strings.EqualFold(user.Name, q.Name)) &&
+			(q.Surname == "" || strings.EqualFold(user.Surname, q.Surname))
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	users, total, next, err := listPage(u.Users, q.Page, match, userCompare,
+		func(user User) int { return user.Id })
+	if err != nil {
+		return UserList{}, err
 	}
+	return UserList{Users: users, Total: total, Limit: q.Limit, Offset: q.Offset, NextCursor: next}, nil
 }
@@ -0,0 +1,18 @@
+package model
+
+// AnyVersion в запросах на изменение и удаление отключает проверку версии
+// (If-Match: *). Версии записей начинаются с 1.
+const AnyVersion = 0
+
+// checkVersion сравнивает текущую версию записи с ожидаемой клиентом
+func checkVersion(current, expected int) error {
+	if expected != AnyVersion && current != expected {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// initialVersion версия записей, сохраненных до появления версий
+func initialVersion(v int) int {
+	return max(v, 1)
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery через сколько вызовов Allow удаляются простаивающие корзины
+const sweepEvery = 1024
+
+// Decision результат проверки запроса
+type Decision struct {
+	Allowed bool
+	Rule    Rule
+	// Remaining сколько запросов клиент может сделать прямо сейчас
+	Remaining int
+	// Reset через сколько запас восполнится полностью
+	Reset time.Duration
+	// RetryAfter через сколько стоит повторить отклоненный запрос
+	RetryAfter time.Duration
+
+	// Quota суточная квота клиента, 0 если квоты нет
+	Quota          int
+	QuotaRemaining int
+	QuotaReset     time.Duration
+	// QuotaExceeded запрос отклонен из-за исчерпанной квоты
+	QuotaExceeded bool
+}
+
+// bucket корзина маркеров одного клиента на одном ограничении
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter ограничивает частоту запросов клиентов алгоритмом token bucket.
+// У каждого клиента своя корзина на каждое ограничение: общее действует
+// на все маршруты, для которых не задано собственное.
+type Limiter struct {
+	mu      sync.Mutex
+	def     Rule
+	routes  map[string]Rule
+	buckets map[string]*bucket
+	quota   *Quota
+	calls   int
+	now     func() time.Time
+}
+
+// New создает ограничитель с общим ограничением def и ограничениями
+// маршрутов routes из ParseRoutes
+func New(def Rule, routes map[string]Rule) *Limiter {
+	return &Limiter{
+		def:     def,
+		routes:  routes,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// UseQuota дополнительно ограничивает клиентов суточной квотой q
+func (l *Limiter) UseQuota(q *Quota) {
+	l.quota = q
+}
+
+// Quota возвращает суточную квоту или nil
+func (l *Limiter) Quota() *Quota {
+	return l.quota
+}
+
+// Routes возвращает маршруты, для которых заданы собственные ограничения
+func (l *Limiter) Routes() []string {
+	routes := make([]string, 0, len(l.routes))
+	for route := range l.routes {
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Rule возвращает ограничение маршрута route с методом method:
+// сначала ищется "METHOD route", затем route, иначе общее
+func (l *Limiter) Rule(method, route string) (string, Rule) {
+	if rule, ok := l.routes[method+" "+route]; ok {
+		return method + " " + route, rule
+	}
+	if rule, ok := l.routes[route]; ok {
+		return route, rule
+	}
+	return "", l.def
+}
+
+// Allow расходует один маркер клиента client на маршруте route и одну
+// единицу его суточной квоты
+func (l *Limiter) Allow(client, method, route string) Decision {
+	name, rule := l.Rule(method, route)
+	d := Decision{Allowed: true, Rule: rule}
+	if !rule.Off() {
+		var refund func()
+		if d, refund = l.take(client, name, rule); !d.Allowed {
+			return d
+		}
+		defer func() {
+			if !d.Allowed {
+				// Запрос не прошел по квоте, маркер возвращается
+				refund()
+			}
+		}()
+	}
+	if l.quota != nil {
+		d.Quota = l.quota.Limit()
+		d.Allowed, d.QuotaRemaining, d.QuotaReset = l.quota.Take(client)
+		if !d.Allowed {
+			d.QuotaExceeded, d.RetryAfter = true, d.QuotaReset
+		}
+	}
+	return d
+}
+
+// Check сообщает, пропустил бы Allow запрос клиента client сейчас, не
+// расходуя маркер. Суточная квота не проверяется.
+func (l *Limiter) Check(client, method, route string) Decision {
+	name, rule := l.Rule(method, route)
+	if rule.Off() {
+		return Decision{Allowed: true, Rule: rule}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(client, name, rule)
+	return decide(b, rule, b.tokens >= 1)
+}
+
+// take расходует маркер из корзины клиента на ограничении name
+func (l *Limiter) take(client, name string, rule Rule) (Decision, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(client, name, rule)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	capacity := float64(rule.Requests)
+	return decide(b, rule, allowed), func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		b.tokens = math.Min(capacity, b.tokens+1)
+	}
+}
+
+// refill возвращает корзину клиента на ограничении name, пополненную
+// к текущему моменту. Вызывающий должен держать l.mu.
+func (l *Limiter) refill(client, name string, rule Rule) *bucket {
+	now := l.now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	key := client + "\x00" + name
+	capacity := float64(rule.Requests)
+	rate := capacity / rule.Per.Seconds()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b
+}
+
+// decide описывает состояние корзины b после решения allowed
+func decide(b *bucket, rule Rule, allowed bool) Decision {
+	capacity := float64(rule.Requests)
+	rate := capacity / rule.Per.Seconds()
+	d := Decision{Allowed: allowed, Rule: rule}
+	if !allowed {
+		d.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((capacity - b.tokens) / rate)
+	return d
+}
+
+// sweep удаляет корзины, которые успели наполниться: они неотличимы от новых
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > l.maxPer() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// maxPer самый длинный период среди ограничений
+func (l *Limiter) maxPer() time.Duration {
+	per := l.def.Per
+	for _, rule := range l.routes {
+		per = max(per, rule.Per)
+	}
+	return per
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
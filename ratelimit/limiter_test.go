@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"restapi/repository"
+	"testing"
+	"time"
+)
+
+// clock часы, которые двигает тест
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newLimiter(def Rule, routes map[string]Rule) (*Limiter, *clock) {
+	c := &clock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := New(def, routes)
+	l.now = c.now
+	return l, c
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	l, c := newLimiter(Rule{Requests: 3, Per: time.Minute}, nil)
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		client     string
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{name: "полная корзина", client: "a", allowed: true, remaining: 2},
+		{name: "второй", client: "a", allowed: true, remaining: 1},
+		{name: "третий", client: "a", allowed: true, remaining: 0},
+		{name: "запас исчерпан", client: "a", allowed: false, remaining: 0, retryAfter: 20 * time.Second},
+		{name: "другой клиент", client: "b", allowed: true, remaining: 2},
+		{name: "маркер восполнился", advance: 20 * time.Second, client: "a", allowed: true, remaining: 0},
+		{name: "корзина не переполняется", advance: time.Hour, client: "a", allowed: true, remaining: 2},
+	}
+	for _, st := range steps {
+		c.t = c.t.Add(st.advance)
+		d := l.Allow(st.client, "GET", "/api/v2/books")
+		if d.Allowed != st.allowed || d.Remaining != st.remaining || d.RetryAfter != st.retryAfter {
+			t.Errorf("%s: Allow = allowed %v, remaining %d, retry after %v; want %v, %d, %v",
+				st.name, d.Allowed, d.Remaining, d.RetryAfter, st.allowed, st.remaining, st.retryAfter)
+		}
+	}
+}
+
+func TestLimiterRouteRules(t *testing.T) {
+	routes, err := ParseRoutes("post /api/v2/books/{action}=1/m; /api/v2/story = 2/s ;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := Rule{Requests: 60, Per: time.Minute}
+	l, _ := newLimiter(def, routes)
+	tests := []struct {
+		method, route string
+		name          string
+		want          Rule
+	}{
+		{method: "POST", route: "/api/v2/books/{action}", name: "POST /api/v2/books/{action}", want: Rule{1, time.Minute}},
+		{method: "GET", route: "/api/v2/books/{action}", want: def},
+		{method: "GET", route: "/api/v2/story", name: "/api/v2/story", want: Rule{2, time.Second}},
+		{method: "DELETE", route: "/api/v2/story", name: "/api/v2/story", want: Rule{2, time.Second}},
+		{method: "GET", route: "/api/v2/users", want: def},
+	}
+	for _, tt := range tests {
+		if name, rule := l.Rule(tt.method, tt.route); name != tt.name || rule != tt.want {
+			t.Errorf("Rule(%s, %s) = %q, %v; want %q, %v", tt.method, tt.route, name, rule, tt.name, tt.want)
+		}
+	}
+
+	// Корзины маршрутов не зависят друг от друга и от общей
+	if !l.Allow("a", "POST", "/api/v2/books/{action}").Allowed {
+		t.Fatal("first POST denied")
+	}
+	if l.Allow("a", "POST", "/api/v2/books/{action}").Allowed {
+		t.Error("second POST allowed by 1/m rule")
+	}
+	if !l.Allow("a", "GET", "/api/v2/books/{action}").Allowed {
+		t.Error("GET denied by the POST rule")
+	}
+}
+
+func TestLimiterCheckDoesNotConsume(t *testing.T) {
+	l, _ := newLimiter(Rule{Requests: 2, Per: time.Minute}, nil)
+	for range 5 {
+		if d := l.Check("a", "GET", "/"); !d.Allowed || d.Remaining != 2 {
+			t.Fatalf("Check = allowed %v, remaining %d; want true, 2", d.Allowed, d.Remaining)
+		}
+	}
+	l.Allow("a", "GET", "/")
+	l.Allow("a", "GET", "/")
+	if d := l.Check("a", "GET", "/"); d.Allowed {
+		t.Error("Check allowed a request after the bucket was emptied")
+	}
+}
+
+func TestLimiterOff(t *testing.T) {
+	l, _ := newLimiter(Rule{}, nil)
+	for range 100 {
+		if !l.Allow("a", "GET", "/").Allowed {
+			t.Fatal("request denied with limit off")
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{in: "60/m", want: Rule{60, time.Minute}},
+		{in: " 5/s ", want: Rule{5, time.Second}},
+		{in: "100/10s", want: Rule{100, 10 * time.Second}},
+		{in: "1000/d", want: Rule{1000, 24 * time.Hour}},
+		{in: "off"},
+		{in: "0"},
+		{in: "60", wantErr: true},
+		{in: "0/m", wantErr: true},
+		{in: "x/m", wantErr: true},
+		{in: "60/week", wantErr: true},
+		{in: "60/-1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRule(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRule(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Запрос, не прошедший по суточной квоте, не расходует маркер корзины
+func TestLimiterQuotaRefundsToken(t *testing.T) {
+	l, _ := newLimiter(Rule{Requests: 10, Per: time.Minute}, nil)
+	l.UseQuota(newQuota(t, 2, repository.NewMemory()))
+
+	for i := range 2 {
+		if d := l.Allow("a", "GET", "/"); !d.Allowed || d.Quota != 2 || d.QuotaRemaining != 1-i {
+			t.Fatalf("request %d: allowed %v, quota %d, remaining %d", i, d.Allowed, d.Quota, d.QuotaRemaining)
+		}
+	}
+	d := l.Allow("a", "GET", "/")
+	if d.Allowed || !d.QuotaExceeded || d.RetryAfter != d.QuotaReset {
+		t.Errorf("over quota: allowed %v, exceeded %v, retry after %v", d.Allowed, d.QuotaExceeded, d.RetryAfter)
+	}
+	if d := l.Check("a", "GET", "/"); d.Remaining != 8 {
+		t.Errorf("bucket has %d tokens after a request denied by quota, want 8", d.Remaining)
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"restapi/repository"
+	"sync"
+	"time"
+)
+
+// FlushInterval как часто счетчики квот сохраняются в хранилище.
+// Запросы, сделанные после последнего сохранения, при аварийной
+// остановке не учитываются.
+const FlushInterval = 10 * time.Second
+
+// Quota суточная квота запросов клиента. Сутки считаются по UTC,
+// счетчики переживают перезапуск сервера.
+type Quota struct {
+	Day    string         `json:"day"`
+	Counts map[string]int `json:"counts"`
+
+	mu    sync.Mutex
+	limit int
+	repo  repository.Repository
+	// changes растет с каждым изменением счетчиков, saved хранит его
+	// значение на момент последнего сохранения
+	changes, saved int
+	// flushMu не дает двум сохранениям записать копии в обратном порядке
+	flushMu sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+	now     func() time.Time
+}
+
+// NewQuota загружает счетчики из repo и раз в FlushInterval сохраняет их.
+// Каждый клиент может сделать не больше limit запросов в сутки.
+func NewQuota(limit int, repo repository.Repository) (*Quota, error) {
+	q := &Quota{limit: limit, repo: repo, done: make(chan struct{}), now: time.Now}
+	if err := repo.Load(context.Background(), repository.Quotas, q); err != nil {
+		return nil, err
+	}
+	q.rollover(q.now())
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.Flush(); err != nil {
+					slog.Error("❌ Quota save error", "err", err)
+				}
+			case <-q.done:
+				return
+			}
+		}
+	}()
+	return q, nil
+}
+
+// Limit суточная квота одного клиента
+func (q *Quota) Limit() int {
+	return q.limit
+}
+
+// Take учитывает запрос клиента client. Возвращает, уложился ли он в квоту,
+// сколько запросов осталось и когда квота обновится.
+func (q *Quota) Take(client string) (bool, int, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now().UTC()
+	q.rollover(now)
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now)
+
+	used := q.Counts[client]
+	if used >= q.limit {
+		return false, 0, reset
+	}
+	q.Counts[client] = used + 1
+	q.changes++
+	return true, q.limit - used - 1, reset
+}
+
+// rollover обнуляет счетчики с началом новых суток, вызывающий должен держать q.mu
+func (q *Quota) rollover(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if q.Day != day || q.Counts == nil {
+		q.Day, q.Counts = day, make(map[string]int)
+		q.changes++
+	}
+}
+
+// Flush сохраняет счетчики, если они изменились. Сохраняется копия, снятая
+// под q.mu: запись в хранилище идет без блокировки, и Take ее не ждет.
+func (q *Quota) Flush() error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	if q.changes == q.saved {
+		q.mu.Unlock()
+		return nil
+	}
+	changes := q.changes
+	snapshot := &Quota{Day: q.Day, Counts: maps.Clone(q.Counts)}
+	q.mu.Unlock()
+
+	if err := q.repo.Save(context.Background(), repository.Quotas, snapshot); err != nil {
+		return err
+	}
+	// Изменения, сделанные во время записи, останутся несохраненными
+	// до следующего Flush
+	q.mu.Lock()
+	q.saved = changes
+	q.mu.Unlock()
+	return nil
+}
+
+// Close останавливает периодическое сохранение и сохраняет счетчики
+func (q *Quota) Close() error {
+	close(q.done)
+	q.wg.Wait()
+	return q.Flush()
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"restapi/repository"
+	"testing"
+	"time"
+)
+
+func newQuota(t *testing.T, limit int, repo repository.Repository) *Quota {
+	t.Helper()
+	q, err := NewQuota(limit, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func TestQuotaRefill(t *testing.T) {
+	q := newQuota(t, 2, repository.NewMemory())
+	c := &clock{t: time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)}
+	q.mu.Lock()
+	q.now = c.now
+	q.mu.Unlock()
+
+	steps := []struct {
+		name      string
+		at        time.Time
+		allowed   bool
+		remaining int
+		reset     time.Duration
+	}{
+		{name: "первый", at: c.t, allowed: true, remaining: 1, reset: 6 * time.Hour},
+		{name: "второй", at: c.t, allowed: true, remaining: 0, reset: 6 * time.Hour},
+		{name: "квота исчерпана", at: c.t.Add(5 * time.Hour), allowed: false, remaining: 0, reset: time.Hour},
+		{name: "новые сутки UTC", at: time.Date(2026, 1, 2, 0, 0, 1, 0, time.UTC), allowed: true, remaining: 1, reset: 24*time.Hour - time.Second},
+		{name: "другой пояс, те же сутки UTC", at: time.Date(2026, 1, 2, 5, 0, 0, 0, time.FixedZone("MSK", 3*3600)), allowed: true, remaining: 0, reset: 22 * time.Hour},
+	}
+	for _, st := range steps {
+		c.t = st.at
+		allowed, remaining, reset := q.Take("a")
+		if allowed != st.allowed || remaining != st.remaining || reset != st.reset {
+			t.Errorf("%s: Take = %v, %d, %v; want %v, %d, %v",
+				st.name, allowed, remaining, reset, st.allowed, st.remaining, st.reset)
+		}
+	}
+}
+
+func TestQuotaSurvivesRestart(t *testing.T) {
+	repo := repository.NewMemory()
+	q, err := NewQuota(3, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Take("a")
+	q.Take("a")
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = newQuota(t, 3, repo)
+	if allowed, remaining, _ := q.Take("a"); !allowed || remaining != 0 {
+		t.Errorf("after restart Take = %v, %d; want true, 0", allowed, remaining)
+	}
+	if allowed, remaining, _ := q.Take("b"); !allowed || remaining != 2 {
+		t.Errorf("other client Take = %v, %d; want true, 2", allowed, remaining)
+	}
+}
+
+// blockingRepo задерживает сохранение, пока тест его не отпустит
+type blockingRepo struct {
+	repository.Repository
+	started, release chan struct{}
+}
+
+func (r *blockingRepo) Save(ctx context.Context, collection string, v any) error {
+	r.started <- struct{}{}
+	<-r.release
+	return r.Repository.Save(ctx, collection, v)
+}
+
+// Flush не держит блокировку во время записи: Take проходит, пока хранилище
+// пишет, а сделанное за это время изменение сохранится следующим Flush
+func TestQuotaFlushDoesNotBlockTake(t *testing.T) {
+	memory := repository.NewMemory()
+	repo := &blockingRepo{Repository: memory, started: make(chan struct{}), release: make(chan struct{})}
+	q := newQuota(t, 10, repo)
+	q.Take("a")
+
+	flushed := make(chan error)
+	go func() { flushed <- q.Flush() }()
+	<-repo.started
+
+	took := make(chan struct{})
+	go func() {
+		q.Take("a")
+		close(took)
+	}()
+	select {
+	case <-took:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Take waited for Flush to save")
+	}
+	close(repo.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+
+	var saved Quota
+	if err := memory.Load(context.Background(), repository.Quotas, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Counts["a"] != 1 {
+		t.Errorf("first flush saved %d requests, want the copy with 1", saved.Counts["a"])
+	}
+
+	// Второй запрос пришел во время записи, поэтому счетчики все еще изменены
+	go func() { <-repo.started }()
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Load(context.Background(), repository.Quotas, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Counts["a"] != 2 {
+		t.Errorf("second flush saved %d requests, want 2", saved.Counts["a"])
+	}
+}
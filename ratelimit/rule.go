@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidRule возвращается при неверной записи ограничения
+var ErrInvalidRule = errors.New("invalid rate limit")
+
+// Rule ограничение: не больше Requests запросов за Per. Запросы можно
+// сделать и разом, после этого запас восполняется равномерно.
+type Rule struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Off проверяет, что ограничение отключено
+func (r Rule) Off() bool {
+	return r.Requests <= 0 || r.Per <= 0
+}
+
+// String возвращает ограничение в виде, который понимает ParseRule
+func (r Rule) String() string {
+	if r.Off() {
+		return "off"
+	}
+	for unit, d := range units {
+		if r.Per == d {
+			return fmt.Sprintf("%d/%s", r.Requests, unit)
+		}
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Per)
+}
+
+// Policy возвращает ограничение в виде элемента заголовка RateLimit-Policy
+func (r Rule) Policy() string {
+	return fmt.Sprintf("%d;w=%d", r.Requests, int(r.Per.Seconds()))
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseRule разбирает ограничение вида 60/m: число запросов и период
+// s, m, h, d или длительность Go, например 100/10s. Строки off и 0
+// отключают ограничение.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Rule{}, nil
+	}
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("%w %q: use <requests>/<period>, e.g. 60/m", ErrInvalidRule, s)
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests <= 0 {
+		return Rule{}, fmt.Errorf("%w %q: requests must be a positive integer", ErrInvalidRule, s)
+	}
+	d, ok := units[per]
+	if !ok {
+		if d, err = time.ParseDuration(per); err != nil || d <= 0 {
+			return Rule{}, fmt.Errorf("%w %q: unknown period %q", ErrInvalidRule, s, per)
+		}
+	}
+	return Rule{Requests: requests, Per: d}, nil
+}
+
+// ParseRoutes разбирает ограничения маршрутов, разделенные точкой с запятой:
+//
+//	POST /api/v2/auth/{action:login|refresh|revoke}=10/m; /api/v2/books/{action:search}=5/s
+//
+// Маршрут записывается шаблоном пути gorilla/mux, метод можно не указывать.
+func ParseRoutes(s string) (map[string]Rule, error) {
+	routes := make(map[string]Rule)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%w %q: use [METHOD ]<path template>=<rule>", ErrInvalidRule, item)
+		}
+		rule, err := ParseRule(item[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		route := strings.Join(strings.Fields(item[:i]), " ")
+		if method, path, ok := strings.Cut(route, " "); ok {
+			route = strings.ToUpper(method) + " " + path
+		}
+		routes[route] = rule
+	}
+	return routes, nil
+}
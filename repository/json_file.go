@@ -0,0 +1,393 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"restapi/tracing"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultJournalSize сколько последних изменений каждой коллекции хранит журнал
+const DefaultJournalSize = 32
+
+// DefaultJournalBytes наибольший размер журнала коллекции: записи журнала
+// содержат коллекцию целиком, и для больших коллекций число записей
+// ограничивается размером
+const DefaultJournalBytes = 8 << 20
+
+// JSONFile хранит каждую коллекцию в отдельном файле <dir>/<collection>.json
+//
+// Запись устойчива к падению процесса:
+//   - состояние сначала дописывается в журнал <collection>.journal и сбрасывается на диск;
+//   - затем пишется во временный файл, который после fsync атомарно
+//     переименовывается поверх снимка;
+//   - предыдущий снимок сохраняется как <collection>.json.bak.
+//
+// Если при чтении снимок отсутствует или поврежден, коллекция восстанавливается
+// из последней целой записи журнала, а затем из предыдущего поколения.
+type JSONFile struct {
+	dir          string
+	journalSize  int
+	journalBytes int64
+
+	mu       sync.Mutex
+	journals map[string]journalState
+}
+
+// journalState последний номер записи, число записей и размер журнала коллекции
+type journalState struct {
+	seq   uint64
+	count int
+	bytes int64
+}
+
+// journalEntry одна запись журнала: полное состояние коллекции после изменения
+type journalEntry struct {
+	Seq  uint64          `json:"seq"`
+	CRC  uint32          `json:"crc"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NewJSONFile создает файловое хранилище в каталоге dir
+func NewJSONFile(dir string) Repository {
+	return &JSONFile{
+		dir:          dir,
+		journalSize:  DefaultJournalSize,
+		journalBytes: DefaultJournalBytes,
+		journals:     map[string]journalState{},
+	}
+}
+
+func (f *JSONFile) path(collection string) string {
+	return filepath.Join(f.dir, collection+".json")
+}
+
+func (f *JSONFile) backupPath(collection string) string {
+	return f.path(collection) + ".bak"
+}
+
+func (f *JSONFile) journalPath(collection string) string {
+	return filepath.Join(f.dir, collection+".journal")
+}
+
+func (f *JSONFile) Load(_ context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Пустой снимок остается после падения между усечением и записью файла,
+	// поэтому он восстанавливается так же, как поврежденный
+	data, err := os.ReadFile(f.path(collection))
+	if err == nil && len(data) > 0 && decodeInto(data, v) == nil {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	missing := err != nil || len(data) == 0
+	if err == nil {
+		err = fmt.Errorf("%s: corrupted snapshot", f.path(collection))
+	}
+
+	// Снимок отсутствует или поврежден: пробуем журнал, затем предыдущее поколение
+	entries, jerr := f.readJournal(collection)
+	if jerr != nil && !os.IsNotExist(jerr) {
+		return errors.Join(err, jerr)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if decodeInto(entries[i].Data, v) == nil {
+			return nil
+		}
+	}
+	if backup, berr := os.ReadFile(f.backupPath(collection)); berr == nil && len(backup) > 0 {
+		if decodeInto(backup, v) == nil {
+			return nil
+		}
+	}
+	if missing {
+		// Коллекция еще ни разу не сохранялась, или пустой снимок
+		// восстановить не из чего
+		return nil
+	}
+	return err
+}
+
+// decodeInto заполняет v из data, только если data целиком разбирается в
+// значение того же типа: при ошибке v остается нетронутым
+func decodeInto(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return json.Unmarshal(data, v)
+	}
+	if err := json.Unmarshal(data, reflect.New(rv.Elem().Type()).Interface()); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (f *JSONFile) Save(ctx context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	_, span := tracing.Start(ctx, "json.marshal")
+	data, err := json.Marshal(v)
+	span.SetAttributes(attribute.Int("json.bytes", len(data)))
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	_, span = tracing.Start(ctx, "json.wait_lock")
+	f.mu.Lock()
+	span.End()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	_, span = tracing.Start(ctx, "json.journal")
+	err = f.appendJournal(collection, data)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	_, span = tracing.Start(ctx, "json.write_snapshot")
+	err = f.writeSnapshot(collection, data)
+	tracing.End(span, err)
+	return err
+}
+
+// Probe проверяет, что снимки коллекций открываются на чтение, а в каталог
+// можно записать файл: пробный файл создается, сбрасывается на диск и
+// удаляется. Коллекция, которая еще ни разу не сохранялась, не ошибка.
+func (f *JSONFile) Probe(_ context.Context, collections ...string) error {
+	for _, c := range collections {
+		file, err := os.Open(f.path(c))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(f.dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeSnapshot атомарно заменяет снимок коллекции, предыдущий снимок
+// становится резервной копией
+func (f *JSONFile) writeSnapshot(collection string, data []byte) error {
+	path := f.path(collection)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	// Текущий снимок становится предыдущим поколением. Пустой снимок,
+	// оставшийся после падения, не должен заменить целую резервную копию.
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		if err := os.Rename(path, f.backupPath(collection)); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(f.dir)
+}
+
+// readJournal возвращает целые записи журнала. Оборванная при падении
+// последняя строка и записи с неверной контрольной суммой пропускаются.
+func (f *JSONFile) readJournal(collection string) ([]journalEntry, error) {
+	data, err := os.ReadFile(f.journalPath(collection))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if crc32.ChecksumIEEE(e.Data) != e.CRC {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// appendJournal дописывает запись в журнал и сбрасывает его на диск.
+// Когда в журнале больше 2*journalSize записей или больше journalBytes байт,
+// он переписывается с последними записями: не больше journalSize штук и
+// половины journalBytes, но последняя запись остается всегда.
+func (f *JSONFile) appendJournal(collection string, data []byte) error {
+	st, ok := f.journals[collection]
+	if !ok {
+		entries, err := f.readJournal(collection)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(entries) > 0 {
+			st.seq = entries[len(entries)-1].Seq
+		}
+		st.count = len(entries)
+		if info, err := os.Stat(f.journalPath(collection)); err == nil {
+			st.bytes = info.Size()
+		}
+	}
+	st.seq++
+
+	line, err := journalLine(journalEntry{Seq: st.seq, CRC: crc32.ChecksumIEEE(data), Data: data})
+	if err != nil {
+		return err
+	}
+
+	if st.count+1 > 2*f.journalSize || st.bytes+int64(len(line)) > f.journalBytes {
+		lines, err := f.recentLines(collection, len(line))
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		if err := f.rewriteJournal(collection, lines); err != nil {
+			return err
+		}
+		st.count, st.bytes = len(lines), 0
+		for _, l := range lines {
+			st.bytes += int64(len(l))
+		}
+		f.journals[collection] = st
+		return nil
+	}
+
+	file, err := os.OpenFile(f.journalPath(collection), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	st.count++
+	st.bytes += int64(len(line))
+	f.journals[collection] = st
+	return nil
+}
+
+// recentLines возвращает строки последних записей журнала, которые вместе
+// с новой записью размером reserve укладываются в пределы после переписывания
+func (f *JSONFile) recentLines(collection string, reserve int) ([][]byte, error) {
+	entries, err := f.readJournal(collection)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var lines [][]byte
+	size := int64(reserve)
+	for i := len(entries) - 1; i >= 0 && len(lines) < f.journalSize-1; i-- {
+		line, err := journalLine(entries[i])
+		if err != nil {
+			return nil, err
+		}
+		if size+int64(len(line)) > f.journalBytes/2 {
+			break
+		}
+		size += int64(len(line))
+		lines = append(lines, line)
+	}
+	slices.Reverse(lines)
+	return lines, nil
+}
+
+// journalLine сериализует запись журнала в строку с переводом строки
+func journalLine(e journalEntry) ([]byte, error) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// rewriteJournal атомарно заменяет журнал строками lines
+func (f *JSONFile) rewriteJournal(collection string, lines [][]byte) error {
+	path := f.journalPath(collection)
+	if err := writeFileSync(path+".tmp", bytes.Join(lines, nil)); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(f.dir)
+}
+
+// writeFileSync записывает файл и дожидается его сброса на диск
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименования пережили падение
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// state сохраняемая в тестах коллекция
+type state struct {
+	Name  string `json:"name"`
+	Items []int  `json:"items"`
+}
+
+// newSaved создает хранилище в новом каталоге и сохраняет в коллекцию c
+// состояния 1, 2 и 3: снимок содержит 3, резервная копия 2, журнал все три
+func newSaved(t *testing.T) (*JSONFile, string) {
+	t.Helper()
+	f := NewJSONFile(t.TempDir()).(*JSONFile)
+	for i := 1; i <= 3; i++ {
+		if err := f.Save(context.Background(), "c", state{Name: fmt.Sprint(i), Items: []int{i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f, "c"
+}
+
+// journalRecord строка журнала с верной контрольной суммой
+func journalRecord(t *testing.T, seq uint64, data string) string {
+	t.Helper()
+	line, err := json.Marshal(journalEntry{Seq: seq, CRC: crc32.ChecksumIEEE([]byte(data)), Data: json.RawMessage(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONFileLoadRecovery(t *testing.T) {
+	untouched := state{Name: "before", Items: []int{42}}
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, f *JSONFile, c string)
+		want    state
+		wantErr bool
+	}{
+		{
+			name:   "целый снимок",
+			damage: func(*testing.T, *JSONFile, string) {},
+			want:   state{Name: "3", Items: []int{3}},
+		},
+		{
+			name: "пустой снимок восстанавливается из журнала",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "")
+			},
+			want: state{Name: "3", Items: []int{3}},
+		},
+		{
+			name: "оборванная строка журнала пропускается",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "{")
+				appendFile(t, f.journalPath(c), `{"seq":4,"crc":1,"da`)
+			},
+			want: state{Name: "3", Items: []int{3}},
+		},
+		{
+			name: "запись с неверной контрольной суммой пропускается",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "{")
+				appendFile(t, f.journalPath(c), `{"seq":4,"crc":1,"data":{"name":"4"}}`+"\n")
+			},
+			want: state{Name: "3", Items: []int{3}},
+		},
+		{
+			name: "запись другого типа не заполняет значение наполовину",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "{")
+				appendFile(t, f.journalPath(c), journalRecord(t, 4, `{"name":"partial","items":"x"}`))
+			},
+			want: state{Name: "3", Items: []int{3}},
+		},
+		{
+			name: "без журнала используется резервная копия",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "{")
+				os.Remove(f.journalPath(c))
+			},
+			want: state{Name: "2", Items: []int{2}},
+		},
+		{
+			name: "пустой снимок без журнала и копии дает пустую коллекцию",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "")
+				os.Remove(f.journalPath(c))
+				os.Remove(f.backupPath(c))
+			},
+			want: untouched,
+		},
+		{
+			name: "поврежденный снимок без журнала и копии",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), "{")
+				os.Remove(f.journalPath(c))
+				os.Remove(f.backupPath(c))
+			},
+			want:    untouched,
+			wantErr: true,
+		},
+		{
+			name: "только запись другого типа",
+			damage: func(t *testing.T, f *JSONFile, c string) {
+				writeFile(t, f.path(c), `{"name":"partial","items":"x"}`)
+				writeFile(t, f.journalPath(c), journalRecord(t, 1, `{"name":"partial","items":"x"}`))
+				os.Remove(f.backupPath(c))
+			},
+			want:    untouched,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := newSaved(t)
+			tt.damage(t, f, c)
+
+			got := state{Name: untouched.Name, Items: slices.Clone(untouched.Items)}
+			err := NewJSONFile(f.dir).Load(context.Background(), c, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %v", err, tt.wantErr)
+			}
+			if got.Name != tt.want.Name || !slices.Equal(got.Items, tt.want.Items) {
+				t.Errorf("Load = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFileLoadMissing(t *testing.T) {
+	got := state{Name: "before"}
+	if err := NewJSONFile(t.TempDir()).Load(context.Background(), "c", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "before" {
+		t.Errorf("Load changed value of a collection that was never saved: %+v", got)
+	}
+}
+
+// После падения с пустым снимком следующее сохранение не должно заменить
+// им целую резервную копию
+func TestJSONFileSaveKeepsBackupAfterEmptySnapshot(t *testing.T) {
+	f, c := newSaved(t)
+	writeFile(t, f.path(c), "")
+	if err := f.Save(context.Background(), c, state{Name: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(f.backupPath(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got state
+	if err := json.Unmarshal(backup, &got); err != nil || got.Name != "2" {
+		t.Errorf("backup = %s, want state 2", backup)
+	}
+}
+
+func TestJSONFileJournalLimits(t *testing.T) {
+	tests := []struct {
+		name             string
+		size             int
+		bytes            int64
+		saves            int
+		maxLines         int
+		maxJournalLength int64
+	}{
+		{name: "по числу записей", size: 4, bytes: DefaultJournalBytes, saves: 20, maxLines: 8},
+		{name: "по размеру", size: DefaultJournalSize, bytes: 1024, saves: 50, maxLines: 2 * DefaultJournalSize, maxJournalLength: 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewJSONFile(t.TempDir()).(*JSONFile)
+			f.journalSize, f.journalBytes = tt.size, tt.bytes
+			for i := range tt.saves {
+				if err := f.Save(context.Background(), "c", state{Name: fmt.Sprintf("state %03d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries, err := f.readJournal("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 || len(entries) > tt.maxLines {
+				t.Errorf("journal has %d entries, want 1..%d", len(entries), tt.maxLines)
+			}
+			var last state
+			if err := json.Unmarshal(entries[len(entries)-1].Data, &last); err != nil || last.Name != fmt.Sprintf("state %03d", tt.saves-1) {
+				t.Errorf("last journal entry = %s, want the last save", entries[len(entries)-1].Data)
+			}
+			if info, err := os.Stat(filepath.Join(f.dir, "c.journal")); err != nil {
+				t.Fatal(err)
+			} else if tt.maxJournalLength > 0 && info.Size() > tt.maxJournalLength {
+				t.Errorf("journal is %d bytes, want at most %d", info.Size(), tt.maxJournalLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Memory хранит коллекции в памяти процесса. Подходит для тестов и разработки:
+// данные пропадают при перезапуске.
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemory создает пустое хранилище в памяти
+func NewMemory() Repository {
+	return &Memory{data: map[string][]byte{}}
+}
+
+func (m *Memory) Load(_ context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	m.mu.RLock()
+	data, ok := m.data[collection]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *Memory) Save(_ context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	// Храним сериализованную копию, чтобы изменения v после Save
+	// не попадали в хранилище в обход него
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.data[collection] = data
+	m.mu.Unlock()
+	return nil
+}
+
+// Probe всегда успешен: память процесса доступна, пока он работает
+func (m *Memory) Probe(context.Context, ...string) error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+// Имена коллекций, которые хранят модели
+const (
+	Books     = "books"
+	Users     = "users"
+	Purchases = "purchases"
+	APIKeys   = "api_keys"
+	// Учетные данные пользователей и отозванные JWT
+	Credentials   = "credentials"
+	RevokedTokens = "revoked_tokens"
+	// Суточные счетчики запросов клиентов
+	Quotas = "quotas"
+)
+
+// ErrEmptyCollection возвращается, если имя коллекции не указано
+var ErrEmptyCollection = errors.New("collection name is empty")
+
+// Repository представляет хранилище коллекций моделей
+// @Description Абстракция над местом хранения данных (файлы, память, SQL)
+//
+// Load заполняет v сохраненным состоянием коллекции. Если коллекция еще ни разу
+// не сохранялась, Load возвращает nil и оставляет v без изменений.
+// Save сохраняет текущее состояние v целиком. Контекст ctx несет спан
+// запроса, от имени которого выполняется обращение.
+type Repository interface {
+	Load(ctx context.Context, collection string, v any) error
+	Save(ctx context.Context, collection string, v any) error
+}
+
+// Prober реализуют хранилища, которые умеют дешево проверить доступность
+// коллекций на чтение и запись, не загружая и не сохраняя их целиком
+type Prober interface {
+	Probe(ctx context.Context, collections ...string) error
+}
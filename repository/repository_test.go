@@ -0,0 +1,193 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"restapi/repository"
+	"restapi/repository/sqlite"
+	"sync"
+	"testing"
+)
+
+// backend хранилище под проверкой. open открывает его заново поверх тех же
+// данных, как после перезапуска сервера.
+type backend struct {
+	name string
+	new  func(t *testing.T) (repo repository.Repository, open func() repository.Repository)
+}
+
+var backends = []backend{
+	{name: "memory", new: func(t *testing.T) (repository.Repository, func() repository.Repository) {
+		repo := repository.NewMemory()
+		return repo, func() repository.Repository { return repo }
+	}},
+	{name: "json", new: func(t *testing.T) (repository.Repository, func() repository.Repository) {
+		dir := t.TempDir()
+		return repository.NewJSONFile(dir), func() repository.Repository { return repository.NewJSONFile(dir) }
+	}},
+	{name: "sql", new: func(t *testing.T) (repository.Repository, func() repository.Repository) {
+		db, err := sqlite.Open(filepath.Join(t.TempDir(), "library.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		open := func() repository.Repository {
+			repo, err := repository.NewSQL(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return repo
+		}
+		return open(), open
+	}},
+}
+
+type collection struct {
+	Name   string            `json:"name"`
+	Items  []int             `json:"items"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// TestRepositoryContract проверяет, что все хранилища ведут себя одинаково
+func TestRepositoryContract(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		run  func(t *testing.T, repo repository.Repository, reopen func() repository.Repository)
+	}{
+		{"несохраненная коллекция не меняет значение", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			got := collection{Name: "before", Items: []int{1}}
+			if err := repo.Load(ctx, "missing", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, collection{Name: "before", Items: []int{1}}) {
+				t.Errorf("Load changed value: %+v", got)
+			}
+		}},
+		{"сохраненное читается после перезапуска", func(t *testing.T, repo repository.Repository, reopen func() repository.Repository) {
+			want := collection{Name: "books", Items: []int{1, 2, 3}, Labels: map[string]string{"a": "б"}}
+			if err := repo.Save(ctx, "c", want); err != nil {
+				t.Fatal(err)
+			}
+			var got collection
+			if err := reopen().Load(ctx, "c", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load = %+v, want %+v", got, want)
+			}
+		}},
+		{"сохранение заменяет коллекцию целиком", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			if err := repo.Save(ctx, "c", collection{Name: "first", Items: []int{1, 2}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Save(ctx, "c", collection{Name: "second"}); err != nil {
+				t.Fatal(err)
+			}
+			var got collection
+			if err := repo.Load(ctx, "c", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, collection{Name: "second"}) {
+				t.Errorf("Load = %+v, want only the second save", got)
+			}
+		}},
+		{"изменение значения после сохранения не попадает в хранилище", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			v := collection{Name: "saved", Items: []int{1}}
+			if err := repo.Save(ctx, "c", &v); err != nil {
+				t.Fatal(err)
+			}
+			v.Name, v.Items[0] = "changed", 2
+			var got collection
+			if err := repo.Load(ctx, "c", &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != "saved" || got.Items[0] != 1 {
+				t.Errorf("Load = %+v, want the value at Save", got)
+			}
+		}},
+		{"коллекции независимы", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			for _, name := range []string{repository.Books, repository.Users} {
+				if err := repo.Save(ctx, name, collection{Name: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range []string{repository.Books, repository.Users} {
+				var got collection
+				if err := repo.Load(ctx, name, &got); err != nil || got.Name != name {
+					t.Errorf("Load(%s) = %+v, %v", name, got, err)
+				}
+			}
+		}},
+		{"пустое имя коллекции", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			var v collection
+			if err := repo.Load(ctx, "", &v); !errors.Is(err, repository.ErrEmptyCollection) {
+				t.Errorf("Load error = %v, want ErrEmptyCollection", err)
+			}
+			if err := repo.Save(ctx, "", v); !errors.Is(err, repository.ErrEmptyCollection) {
+				t.Errorf("Save error = %v, want ErrEmptyCollection", err)
+			}
+		}},
+		{"значение, которое нельзя сохранить", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			if err := repo.Save(ctx, "c", collection{Name: "kept"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Save(ctx, "c", map[string]any{"f": func() {}}); err == nil {
+				t.Fatal("Save of a function succeeded")
+			}
+			var got collection
+			if err := repo.Load(ctx, "c", &got); err != nil || got.Name != "kept" {
+				t.Errorf("Load = %+v, %v; want the last good save", got, err)
+			}
+		}},
+		{"проба повторяется: пробная запись не остается", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			prober, ok := repo.(repository.Prober)
+			if !ok {
+				t.Fatal("repository does not implement Prober")
+			}
+			if err := prober.Probe(ctx, repository.Books, "missing"); err != nil {
+				t.Fatal(err)
+			}
+			if err := prober.Probe(ctx, repository.Books); err != nil {
+				t.Fatalf("second probe: %v", err)
+			}
+		}},
+		{"параллельные сохранения разных коллекций", func(t *testing.T, repo repository.Repository, _ func() repository.Repository) {
+			var wg sync.WaitGroup
+			for i := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					name := fmt.Sprintf("c%d", i)
+					for j := range 10 {
+						if err := repo.Save(ctx, name, collection{Name: name, Items: []int{j}}); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			for i := range 8 {
+				var got collection
+				name := fmt.Sprintf("c%d", i)
+				if err := repo.Load(ctx, name, &got); err != nil || got.Name != name || got.Items[0] != 9 {
+					t.Errorf("Load(%s) = %+v, %v; want the last save", name, got, err)
+				}
+			}
+		}},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					repo, reopen := b.new(t)
+					tc.run(t, repo, reopen)
+				})
+			}
+		})
+	}
+}
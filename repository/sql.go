@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// SQL хранит коллекции в таблице collections любой базы данных,
+// доступной через database/sql. Драйвер подключает вызывающий код.
+type SQL struct {
+	db *sql.DB
+}
+
+// NewSQL создает SQL хранилище и при необходимости создает таблицу collections
+func NewSQL(db *sql.DB) (Repository, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS collections (
+		name VARCHAR(64) PRIMARY KEY,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQL{db: db}, nil
+}
+
+func (s *SQL) Load(ctx context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM collections WHERE name = ?`, collection).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+func (s *SQL) Save(ctx context.Context, collection string, v any) error {
+	if collection == "" {
+		return ErrEmptyCollection
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// DELETE + INSERT в одной транзакции вместо UPSERT,
+	// синтаксис которого отличается между СУБД
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM collections WHERE name = ?`, collection); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO collections (name, data) VALUES (?, ?)`, collection, string(data)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Probe читает размер записей коллекций, не загружая данные, и проверяет
+// запись вставкой пробной строки в транзакции, которая откатывается
+func (s *SQL) Probe(ctx context.Context, collections ...string) error {
+	for _, c := range collections {
+		var size int
+		err := s.db.QueryRowContext(ctx, `SELECT length(data) FROM collections WHERE name = ?`, c).Scan(&size)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO collections (name, data) VALUES (?, ?)`, probeCollection, "")
+	return err
+}
+
+// probeCollection имя пробной записи Probe, в базе она не остается
+const probeCollection = ".probe"
@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"restapi/model"
+	"restapi/repository"
+	"restapi/utils"
+	"time"
+)
+
+// Books реализует model.Books поверх таблицы books
+type Books struct {
+	db tracedDB
+}
+
+// NewBooks создает хранилище книг в базе db
+func NewBooks(db *sql.DB) model.Books {
+	return &Books{db: tracedDB{db, repository.Books}}
+}
+
+// Get проверяет доступность базы: данные читаются по запросу
+func (b *Books) Get(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+const bookColumns = `id, name, author, price, version, updated_at`
+
+func scanBook(row interface{ Scan(...any) error }) (model.BookModel, error) {
+	var book model.BookModel
+	var updated sql.NullString
+	err := row.Scan(&book.Id, &book.Name, &book.Author, &book.Price, &book.Version, &updated)
+	book.UpdatedAt = parseTime(updated)
+	return book, err
+}
+
+// Save ничего не делает: каждое изменение сразу записывается в базу
+func (b *Books) Save(ctx context.Context) error {
+	return nil
+}
+
+func (b *Books) AddBook(ctx context.Context, book model.BookModel) error {
+	_, err := b.db.ExecContext(ctx, `INSERT INTO books (name, author, price, updated_at) VALUES (?, ?, ?, ?)`,
+		book.Name, book.Author, book.Price, formatTime(time.Now()))
+	return err
+}
+
+func (b *Books) RemoveBook(ctx context.Context, id, version int) error {
+	res, err := b.db.ExecContext(ctx, `DELETE FROM books WHERE id = ? AND `+versionMatch, id, version, version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, b.db, res, "books", id, model.ErrBookNotFound)
+}
+
+func (b *Books) UpdateBook(ctx context.Context, book model.BookModel) error {
+	res, err := b.db.ExecContext(ctx, `UPDATE books SET name = ?, author = ?, price = ?,
+		version = version + 1, updated_at = ? WHERE id = ? AND `+versionMatch,
+		book.Name, book.Author, book.Price, formatTime(time.Now()), book.Id, book.Version, book.Version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, b.db, res, "books", book.Id, model.ErrBookNotFound)
+}
+
+func (b *Books) GetBook(ctx context.Context, id int) []byte {
+	book, err := b.FindBook(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return utils.MarshalThis(ctx, book)
+}
+
+func (b *Books) FindBook(ctx context.Context, id int) (model.BookModel, error) {
+	book, err := scanBook(b.db.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.BookModel{}, model.ErrBookNotFound
+	}
+	return book, err
+}
+
+// WriteAllBooks отдает книги пачками по мере чтения из базы, ответ не копится
+// в памяти, а соединение не занято, пока пачка уходит клиенту
+func (b *Books) WriteAllBooks(ctx context.Context, w io.Writer) error {
+	s := utils.NewJSONStream(ctx, w)
+	model.BeginList(s, "books")
+	total := streamAll(ctx, b.db, s, "books", bookColumns, scanBook, func(v model.BookModel) int { return v.Id })
+	model.EndList(s, total)
+	return s.Close()
+}
+
+func (b *Books) GetCount(ctx context.Context) int {
+	var count int
+	b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM books`).Scan(&count)
+	return count
+}
+
+func (b *Books) ListBooks(ctx context.Context, q model.BookQuery) (model.BookList, error) {
+	if err := q.Normalize(model.BookSortFields); err != nil {
+		return model.BookList{}, err
+	}
+
+	var lq listQuery
+	if q.Author != "" {
+		lq.add("author = ? COLLATE NOCASE", q.Author)
+	}
+	if q.PriceMin != nil {
+		lq.add("price >= ?", *q.PriceMin)
+	}
+	if q.PriceMax != nil {
+		lq.add("price <= ?", *q.PriceMax)
+	}
+	total, err := lq.count(ctx, b.db, "books")
+	if err != nil {
+		return model.BookList{}, err
+	}
+
+	var after *cursorKey
+	if q.Cursor != "" {
+		var c model.BookModel
+		if err := model.DecodeCursor(q.Page, &c); err != nil {
+			return model.BookList{}, err
+		}
+		values := map[string]any{"id": c.Id, "name": c.Name, "author": c.Author, "price": c.Price}
+		after = &cursorKey{value: values[q.Sort], id: c.Id}
+	}
+
+	tail, args := lq.page(q.Page, q.Sort, after)
+	rows, err := b.db.QueryContext(ctx, `SELECT `+bookColumns+` FROM books`+tail, args...)
+	if err != nil {
+		return model.BookList{}, err
+	}
+	defer rows.Close()
+
+	res := model.BookList{Books: []model.BookModel{}, Total: total, Limit: q.Limit, Offset: q.Offset}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return model.BookList{}, err
+		}
+		res.Books = append(res.Books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return model.BookList{}, err
+	}
+	if len(res.Books) > q.Limit {
+		res.Books = res.Books[:q.Limit]
+		res.NextCursor = model.EncodeCursor(q.Page, res.Books[q.Limit-1])
+	}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"restapi/model"
+	"restapi/repository"
+)
+
+// ErrNotEmpty возвращается ImportJSON, если в базе уже есть данные
+var ErrNotEmpty = errors.New("sqlite database is not empty, import skipped")
+
+// ImportJSON однократно переносит книги, пользователей и историю покупок
+// из хранилища src (например, файлов books.json, users.json и purchases.json)
+// в базу db. Идентификаторы сохраняются. Импорт выполняется в одной транзакции
+// и только в пустую базу.
+func ImportJSON(ctx context.Context, db *sql.DB, src repository.Repository) error {
+	var (
+		library model.Library
+		users   model.Users
+		story   model.Story
+	)
+	if err := src.Load(ctx, repository.Books, &library); err != nil {
+		return err
+	}
+	if err := src.Load(ctx, repository.Users, &users); err != nil {
+		return err
+	}
+	if err := src.Load(ctx, repository.Purchases, &story); err != nil {
+		return err
+	}
+	// Повторяющиеся идентификаторы старых файлов нарушили бы PRIMARY KEY
+	library.RepairIds()
+	users.RepairIds()
+	story.RepairIds()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow(`SELECT (SELECT COUNT(*) FROM books) + (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM purchases)`).
+		Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrNotEmpty
+	}
+
+	for _, b := range library.Books {
+		_, err := tx.Exec(`INSERT INTO books (id, name, author, price, version, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			b.Id, b.Name, b.Author, b.Price, max(b.Version, 1), formatTime(b.UpdatedAt))
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range users.Users {
+		role, err := model.ParseRole(u.Role)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", u.Id, err)
+		}
+		_, err = tx.Exec(`INSERT INTO users (id, name, surname, role, version, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			u.Id, u.Name, u.Surname, role, max(u.Version, 1), formatTime(u.UpdatedAt))
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range story.Purchases {
+		_, err := tx.Exec(`INSERT INTO purchases (id, book_id, user_id, start_at, end_at, orphaned, version, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.Id, p.BookId, p.UserId, formatTime(p.TookAt), formatTime(p.EndAt), p.Orphaned,
+			max(p.Version, 1), formatTime(p.UpdatedAt))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Переносим последовательности, чтобы удаленные в файлах идентификаторы
+	// не были выданы повторно
+	sequences := map[string]int{
+		"books":     library.NextId,
+		"users":     users.NextId,
+		"purchases": story.NextId,
+	}
+	for table, next := range sequences {
+		if err := setSequence(tx, table, next); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// setSequence задает следующий идентификатор AUTOINCREMENT таблицы
+func setSequence(tx *sql.Tx, table string, next int) error {
+	if _, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, table); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, table, next-1)
+	return err
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"restapi/model"
+	"restapi/repository"
+	"testing"
+	"time"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestImportJSON(t *testing.T) {
+	ctx := context.Background()
+	took := time.Date(2025, 3, 1, 9, 30, 0, 0, time.FixedZone("MSK", 3*3600))
+	src := repository.NewMemory()
+	// Книга 2 повторяется, как в файлах старых версий; NextId отстает, а
+	// удаленная книга 5 не должна получить свой номер повторно
+	saves := map[string]any{
+		repository.Books: model.Library{NextId: 6, Books: []model.BookModel{
+			{Id: 1, Name: "One", Author: "A", Price: 10, Version: 3},
+			{Id: 2, Name: "Two", Author: "B", Price: 20},
+			{Id: 2, Name: "Copy", Author: "C", Price: 30},
+		}},
+		repository.Users: model.Users{NextId: 0, Users: []model.User{
+			{Id: 0, Name: "Zero", Surname: "Z"},
+			{Id: 1, Name: "One", Surname: "O", Role: model.RoleAdmin},
+		}},
+		repository.Purchases: model.Story{NextId: 1, Purchases: []model.Purchase{
+			{Id: 0, BookId: 1, UserId: 0, TookAt: took, Orphaned: true, Version: 2},
+		}},
+	}
+	for name, v := range saves {
+		if err := src.Save(ctx, name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := openDB(t)
+	if err := ImportJSON(ctx, db, src); err != nil {
+		t.Fatal(err)
+	}
+
+	books := NewBooks(db)
+	tests := []struct {
+		id      int
+		name    string
+		version int
+	}{
+		{id: 1, name: "One", version: 3},
+		{id: 2, name: "Two", version: 1},
+		// Дубликат получает следующий свободный номер
+		{id: 6, name: "Copy", version: 1},
+	}
+	for _, tt := range tests {
+		b, err := books.FindBook(ctx, tt.id)
+		if err != nil || b.Name != tt.name || b.Version != tt.version {
+			t.Errorf("book %d = %+v, %v; want %s of version %d", tt.id, b, err, tt.name, tt.version)
+		}
+	}
+	if err := books.AddBook(ctx, model.BookModel{Name: "New"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := books.FindBook(ctx, 7); err != nil {
+		t.Errorf("new book after import: %v, want id 7 after the imported sequence", err)
+	}
+
+	users := NewUsers(db)
+	if u, err := users.FindUser(ctx, 0); err != nil || u.Role != model.RoleMember {
+		t.Errorf("user 0 = %+v, %v; want member", u, err)
+	}
+	if u, err := users.FindUser(ctx, 1); err != nil || u.Role != model.RoleAdmin {
+		t.Errorf("user 1 = %+v, %v; want admin", u, err)
+	}
+
+	p, err := NewStory(db).FindPurchase(ctx, 0)
+	if err != nil || !p.TookAt.Equal(took) || !p.Orphaned || p.Version != 2 || !p.EndAt.IsZero() {
+		t.Errorf("purchase = %+v, %v", p, err)
+	}
+
+	if err := ImportJSON(ctx, db, src); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("second import: %v, want ErrNotEmpty", err)
+	}
+}
+
+func TestImportJSONEmpty(t *testing.T) {
+	db := openDB(t)
+	if err := ImportJSON(context.Background(), db, repository.NewMemory()); err != nil {
+		t.Fatal(err)
+	}
+	// Пустой импорт оставляет базу пустой, и следующий импорт разрешен
+	if err := ImportJSON(context.Background(), db, repository.NewMemory()); err != nil {
+		t.Errorf("second empty import: %v", err)
+	}
+}
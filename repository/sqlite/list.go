@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"context"
+	"math"
+	"restapi/model"
+	"restapi/utils"
+	"strings"
+)
+
+// listQuery собирает условия WHERE для выборок с фильтрами и курсором
+type listQuery struct {
+	where []string
+	args  []any
+}
+
+func (q *listQuery) add(cond string, args ...any) {
+	q.where = append(q.where, cond)
+	q.args = append(q.args, args...)
+}
+
+func (q *listQuery) clause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// count возвращает число записей таблицы, подходящих под фильтры
+func (q *listQuery) count(ctx context.Context, db tracedDB, table string) (int, error) {
+	var total int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+q.clause(), q.args...).Scan(&total)
+	return total, err
+}
+
+// page дополняет фильтры условием курсора и возвращает хвост запроса:
+// WHERE, ORDER BY и LIMIT/OFFSET. Запрашивается на одну запись больше,
+// чтобы понять, есть ли следующая страница.
+//
+// column выражение колонки сортировки, after значение этой колонки и id
+// записи из курсора, если он задан.
+func (q *listQuery) page(p model.Page, column string, after *cursorKey) (string, []any) {
+	where, args := q.where, q.args
+	if after != nil {
+		op := ">"
+		if p.Desc {
+			op = "<"
+		}
+		where = append(where, "("+column+" "+op+" ? OR ("+column+" = ? AND id "+op+" ?))")
+		args = append(args, after.value, after.value, after.id)
+	}
+
+	dir := " ASC"
+	if p.Desc {
+		dir = " DESC"
+	}
+	sql := ""
+	if len(where) > 0 {
+		sql = " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY " + column + dir + ", id" + dir + " LIMIT ?"
+	args = append(args, p.Limit+1)
+	if after == nil {
+		sql += " OFFSET ?"
+		args = append(args, p.Offset)
+	}
+	return sql, args
+}
+
+// cursorKey значение колонки сортировки и id записи, на которую указывает курсор
+type cursorKey struct {
+	value any
+	id    int
+}
+
+// streamBatch число записей, которое streamAll читает одним запросом
+const streamBatch = 500
+
+// streamAll пишет все записи таблицы в s по возрастанию id и возвращает их
+// число. Записи читаются пачками по streamBatch с продолжением от последнего
+// id, и строки закрываются до записи пачки клиенту: при единственном
+// соединении медленный клиент иначе держал бы базу до конца ответа.
+func streamAll[T any](ctx context.Context, db tracedDB, s *utils.JSONStream, table, columns string,
+	scan func(interface{ Scan(...any) error }) (T, error), id func(T) int) int {
+	total, after := 0, math.MinInt64
+	for s.Err() == nil {
+		batch, err := readBatch(ctx, db, table, columns, after, scan)
+		if err != nil {
+			s.Fail(err)
+			break
+		}
+		for _, item := range batch {
+			s.Elem(total, item)
+			total++
+		}
+		if len(batch) < streamBatch {
+			break
+		}
+		after = id(batch[len(batch)-1])
+	}
+	return total
+}
+
+// readBatch читает до streamBatch записей с id больше after
+func readBatch[T any](ctx context.Context, db tracedDB, table, columns string, after int,
+	scan func(interface{ Scan(...any) error }) (T, error)) ([]T, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+columns+` FROM `+table+` WHERE id > ? ORDER BY id LIMIT ?`, after, streamBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batch := make([]T, 0, streamBatch)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, item)
+	}
+	return batch, rows.Err()
+}
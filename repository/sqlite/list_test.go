@@ -0,0 +1,99 @@
+package sqlite
+
+import (
+	"context"
+	"reflect"
+	"restapi/model"
+	"restapi/repository"
+	"testing"
+)
+
+// walk обходит выборку по курсорам и возвращает id всех записей
+func walk(t *testing.T, books model.Books, q model.BookQuery) []int {
+	t.Helper()
+	var ids []int
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("%+v: cursor walk does not end", q.Page)
+		}
+		list, err := books.ListBooks(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range list.Books {
+			ids = append(ids, b.Id)
+		}
+		if list.NextCursor == "" {
+			return ids
+		}
+		q.Cursor = list.NextCursor
+	}
+}
+
+// Выборка из базы совпадает с выборкой из памяти, включая порядок равных
+// значений и курсоры
+func TestListBooksMatchesMemory(t *testing.T) {
+	ctx := context.Background()
+	memory, err := model.BooksInit(repository.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewBooks(openDB(t))
+	for _, b := range []model.BookModel{
+		{Name: "Dune", Author: "Herbert", Price: 300},
+		{Name: "Emma", Author: "Austen", Price: 100},
+		{Name: "Ulysses", Author: "Joyce", Price: 200},
+		{Name: "Persuasion", Author: "austen", Price: 100},
+		{Name: "Dune", Author: "Herbert", Price: 300},
+		{Name: "Beloved", Author: "Morrison", Price: 100},
+	} {
+		for _, books := range []model.Books{memory, db} {
+			if err := books.AddBook(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	price := 150.0
+	tests := []struct {
+		name string
+		q    model.BookQuery
+	}{
+		{name: "по id", q: model.BookQuery{Page: model.Page{Limit: 4}}},
+		{name: "по цене", q: model.BookQuery{Page: model.Page{Limit: 2, Sort: "price"}}},
+		{name: "по цене по убыванию", q: model.BookQuery{Page: model.Page{Limit: 2, Sort: "price", Desc: true}}},
+		{name: "по названию", q: model.BookQuery{Page: model.Page{Limit: 1, Sort: "name"}}},
+		{name: "автор без учета регистра", q: model.BookQuery{Page: model.Page{Limit: 1, Sort: "author"}, Author: "AUSTEN"}},
+		{name: "фильтр по цене", q: model.BookQuery{Page: model.Page{Limit: 1, Sort: "price", Desc: true}, PriceMin: &price}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := walk(t, memory, tt.q)
+			if got := walk(t, db, tt.q); !reflect.DeepEqual(got, want) {
+				t.Errorf("sqlite walked %v, memory walked %v", got, want)
+			}
+
+			offset := tt.q
+			offset.Offset = 1
+			m, err := memory.ListBooks(ctx, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := db.ListBooks(ctx, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bookIdsOf(d), bookIdsOf(m)) || d.Total != m.Total {
+				t.Errorf("offset page: sqlite %v of %d, memory %v of %d", bookIdsOf(d), d.Total, bookIdsOf(m), m.Total)
+			}
+		})
+	}
+}
+
+func bookIdsOf(list model.BookList) []int {
+	ids := []int{}
+	for _, b := range list.Books {
+		ids = append(ids, b.Id)
+	}
+	return ids
+}
@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"restapi/model"
+	"testing"
+)
+
+// openRaw открывает пустую базу без миграций
+func openRaw(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var res []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version <= migrations[i-1].version {
+			t.Fatalf("migrations %s and %s are out of order", migrations[i-1].name, migrations[i].name)
+		}
+	}
+
+	db := openRaw(t)
+	for range 2 {
+		// Повторный запуск ничего не применяет
+		if err := Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+		got := appliedVersions(t, db)
+		if len(got) != len(migrations) || got[len(got)-1] != migrations[len(migrations)-1].version {
+			t.Fatalf("applied versions %v, want all %d migrations once", got, len(migrations))
+		}
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	db := openRaw(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, '9999_future.sql', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err == nil {
+		t.Error("Migrate accepted a schema newer than the application")
+	}
+}
+
+// Данные, записанные первой версией схемы, после миграций читаются с
+// новыми колонками по умолчанию
+func TestMigrateUpgradesOldData(t *testing.T) {
+	db := openRaw(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(db, migrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO books (name, author, price) VALUES ('Old', 'Author', 10)`,
+		`INSERT INTO users (name, surname) VALUES ('Old', 'User')`,
+		`INSERT INTO purchases (book_id, user_id, start_at) VALUES (1, 1, '2024-05-01 10:20:30')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	book, err := NewBooks(db).FindBook(ctx, 1)
+	if err != nil || book.Version != 1 {
+		t.Errorf("book = %+v, %v; want version 1", book, err)
+	}
+	user, err := NewUsers(db).FindUser(ctx, 1)
+	if err != nil || user.Role != model.RoleMember || user.Version != 1 {
+		t.Errorf("user = %+v, %v; want member of version 1", user, err)
+	}
+	p, err := NewStory(db).FindPurchase(ctx, 1)
+	if err != nil || p.Orphaned || p.Version != 1 {
+		t.Errorf("purchase = %+v, %v", p, err)
+	}
+	if want := "2024-05-01T10:20:30Z"; p.TookAt.UTC().Format("2006-01-02T15:04:05Z") != want {
+		t.Errorf("start_at = %v, want %s", p.TookAt, want)
+	}
+}
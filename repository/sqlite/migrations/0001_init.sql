@@ -0,0 +1,23 @@
+CREATE TABLE books (
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    name   TEXT    NOT NULL DEFAULT '',
+    author TEXT    NOT NULL DEFAULT '',
+    price  REAL    NOT NULL DEFAULT 0
+);
+
+CREATE TABLE users (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    name    TEXT NOT NULL DEFAULT '',
+    surname TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE purchases (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id  INTEGER NOT NULL,
+    user_id  INTEGER NOT NULL,
+    start_at TEXT,
+    end_at   TEXT
+);
+
+CREATE INDEX purchases_book_id ON purchases (book_id);
+CREATE INDEX purchases_user_id ON purchases (user_id);
@@ -0,0 +1 @@
+ALTER TABLE purchases ADD COLUMN orphaned INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Время хранится в UTC с фиксированной точностью, чтобы сортировка
+-- TEXT колонок совпадала с хронологической
+UPDATE purchases SET start_at = strftime('%Y-%m-%dT%H:%M:%f000000Z', start_at) WHERE start_at IS NOT NULL;
+UPDATE purchases SET end_at = strftime('%Y-%m-%dT%H:%M:%f000000Z', end_at) WHERE end_at IS NOT NULL;
+CREATE INDEX purchases_start_at ON purchases (start_at);
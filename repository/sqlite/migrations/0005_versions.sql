@@ -0,0 +1,8 @@
+ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE books ADD COLUMN updated_at TEXT;
+
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN updated_at TEXT;
+
+ALTER TABLE purchases ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE purchases ADD COLUMN updated_at TEXT;
@@ -0,0 +1,264 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"restapi/model"
+	"restapi/repository"
+	"restapi/utils"
+	"time"
+)
+
+// Story реализует model.StoryHandler поверх таблицы purchases
+type Story struct {
+	db tracedDB
+}
+
+// NewStory создает хранилище истории покупок в базе db
+func NewStory(db *sql.DB) model.StoryHandler {
+	return &Story{db: tracedDB{db, repository.Purchases}}
+}
+
+const purchaseColumns = `id, book_id, user_id, start_at, end_at, orphaned, version, updated_at`
+
+func scanPurchase(row interface{ Scan(...any) error }) (model.Purchase, error) {
+	var p model.Purchase
+	var start, end, updated sql.NullString
+	if err := row.Scan(&p.Id, &p.BookId, &p.UserId, &start, &end, &p.Orphaned, &p.Version, &updated); err != nil {
+		return p, err
+	}
+	p.TookAt = parseTime(start)
+	p.EndAt = parseTime(end)
+	p.UpdatedAt = parseTime(updated)
+	return p, nil
+}
+
+func (s *Story) query(ctx context.Context, where string, args ...any) []model.Purchase {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+purchaseColumns+` FROM purchases `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var res []model.Purchase
+	for rows.Next() {
+		p, err := scanPurchase(rows)
+		if err != nil {
+			return nil
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// Get проверяет доступность базы: данные читаются по запросу
+func (s *Story) Get(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Save ничего не делает: каждое изменение сразу записывается в базу
+func (s *Story) Save(ctx context.Context) error {
+	return nil
+}
+
+// WriteAll отдает покупки пачками по мере чтения из базы
+func (s *Story) WriteAll(ctx context.Context, w io.Writer) error {
+	js := utils.NewJSONStream(ctx, w)
+	model.BeginList(js, "purchases")
+	total := streamAll(ctx, s.db, js, "purchases", purchaseColumns, scanPurchase, func(v model.Purchase) int { return v.Id })
+	model.EndList(js, total)
+	return js.Close()
+}
+
+func (s *Story) GetByUser(ctx context.Context, id int) []byte {
+	return utils.MarshalThis(ctx, s.query(ctx, "WHERE user_id = ?", id))
+}
+
+func (s *Story) GetByBook(ctx context.Context, id int) []byte {
+	return utils.MarshalThis(ctx, s.query(ctx, "WHERE book_id = ?", id))
+}
+
+func (s *Story) GetById(ctx context.Context, id int) []byte {
+	p, err := s.FindPurchase(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return utils.MarshalThis(ctx, p)
+}
+
+func (s *Story) FindPurchase(ctx context.Context, id int) (model.Purchase, error) {
+	p, err := scanPurchase(s.db.QueryRowContext(ctx, `SELECT `+purchaseColumns+` FROM purchases WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Purchase{}, model.ErrPurchaseNotFound
+	}
+	return p, err
+}
+
+func (s *Story) AddPurchase(ctx context.Context, p model.Purchase) error {
+	now := formatTime(time.Now())
+	_, err := s.db.ExecContext(ctx, `INSERT INTO purchases (book_id, user_id, start_at, updated_at) VALUES (?, ?, ?, ?)`,
+		p.BookId, p.UserId, now, now)
+	return err
+}
+
+func (s *Story) EndPurchase(ctx context.Context, id, version int) error {
+	now := formatTime(time.Now())
+	res, err := s.db.ExecContext(ctx, `UPDATE purchases SET end_at = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND `+versionMatch, now, now, id, version, version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, s.db, res, "purchases", id, model.ErrPurchaseNotFound)
+}
+
+func (s *Story) DelPurchase(ctx context.Context, id, version int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM purchases WHERE id = ? AND `+versionMatch, id, version, version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, s.db, res, "purchases", id, model.ErrPurchaseNotFound)
+}
+
+func (s *Story) DelPurchaseByBook(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM purchases WHERE book_id = ?`, id)
+	return err
+}
+
+func (s *Story) DelPurchaseByUser(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM purchases WHERE user_id = ?`, id)
+	return err
+}
+
+func (s *Story) UpdatePurchase(ctx context.Context, p model.Purchase) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE purchases SET book_id = ?, user_id = ?,
+		version = version + 1, updated_at = ? WHERE id = ? AND `+versionMatch,
+		p.BookId, p.UserId, formatTime(time.Now()), p.Id, p.Version, p.Version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, s.db, res, "purchases", p.Id, model.ErrPurchaseNotFound)
+}
+
+func (s *Story) CountByBook(ctx context.Context, id int) int {
+	var count int
+	s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM purchases WHERE book_id = ?`, id).Scan(&count)
+	return count
+}
+
+func (s *Story) CountByUser(ctx context.Context, id int) int {
+	var count int
+	s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM purchases WHERE user_id = ?`, id).Scan(&count)
+	return count
+}
+
+func (s *Story) OrphanByBook(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE purchases SET orphaned = 1, version = version + 1, updated_at = ?
+		WHERE book_id = ? AND orphaned = 0`, formatTime(time.Now()), id)
+	return err
+}
+
+func (s *Story) OrphanByUser(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE purchases SET orphaned = 1, version = version + 1, updated_at = ?
+		WHERE user_id = ? AND orphaned = 0`, formatTime(time.Now()), id)
+	return err
+}
+
+func (s *Story) RestorePurchases(ctx context.Context, purchases []model.Purchase) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range purchases {
+		_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO purchases (`+purchaseColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.Id, p.BookId, p.UserId, formatTime(p.TookAt), formatTime(p.EndAt), p.Orphaned,
+			p.Version, formatTime(p.UpdatedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// purchaseSortColumns выражения колонок сортировки. NULL в датах заменяется
+// пустой строкой, чтобы открытые аренды участвовали в сравнении курсора.
+var purchaseSortColumns = map[string]string{
+	"id":       "id",
+	"book_id":  "book_id",
+	"user_id":  "user_id",
+	"start_at": "COALESCE(start_at, '')",
+	"end_at":   "COALESCE(end_at, '')",
+}
+
+// sortTime значение даты для сравнения с purchaseSortColumns
+func sortTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+func (s *Story) List(ctx context.Context, q model.PurchaseQuery) (model.PurchaseList, error) {
+	if err := q.Normalize(model.PurchaseSortFields); err != nil {
+		return model.PurchaseList{}, err
+	}
+
+	var lq listQuery
+	if q.BookId != nil {
+		lq.add("book_id = ?", *q.BookId)
+	}
+	if q.UserId != nil {
+		lq.add("user_id = ?", *q.UserId)
+	}
+	if q.Active != nil {
+		if *q.Active {
+			lq.add("end_at IS NULL")
+		} else {
+			lq.add("end_at IS NOT NULL")
+		}
+	}
+	total, err := lq.count(ctx, s.db, "purchases")
+	if err != nil {
+		return model.PurchaseList{}, err
+	}
+
+	var after *cursorKey
+	if q.Cursor != "" {
+		var c model.Purchase
+		if err := model.DecodeCursor(q.Page, &c); err != nil {
+			return model.PurchaseList{}, err
+		}
+		values := map[string]any{
+			"id": c.Id, "book_id": c.BookId, "user_id": c.UserId,
+			"start_at": sortTime(c.TookAt), "end_at": sortTime(c.EndAt),
+		}
+		after = &cursorKey{value: values[q.Sort], id: c.Id}
+	}
+
+	tail, args := lq.page(q.Page, purchaseSortColumns[q.Sort], after)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+purchaseColumns+` FROM purchases`+tail, args...)
+	if err != nil {
+		return model.PurchaseList{}, err
+	}
+	defer rows.Close()
+
+	res := model.PurchaseList{Purchases: []model.Purchase{}, Total: total, Limit: q.Limit, Offset: q.Offset}
+	for rows.Next() {
+		p, err := scanPurchase(rows)
+		if err != nil {
+			return model.PurchaseList{}, err
+		}
+		res.Purchases = append(res.Purchases, p)
+	}
+	if err := rows.Err(); err != nil {
+		return model.PurchaseList{}, err
+	}
+	if len(res.Purchases) > q.Limit {
+		res.Purchases = res.Purchases[:q.Limit]
+		res.NextCursor = model.EncodeCursor(q.Page, res.Purchases[q.Limit-1])
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite" // Драйвер SQLite на чистом Go
+)
+
+// Open открывает базу SQLite по пути path и применяет миграции
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает только одного писателя, поэтому одно соединение
+	// избавляет от ошибок SQLITE_BUSY при параллельных запросах
+	db.SetMaxOpenConns(1)
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// timeLayout RFC 3339 в UTC с фиксированным числом знаков, чтобы
+// строки в TEXT колонках сортировались в хронологическом порядке
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Время хранится в TEXT колонках, нулевое время хранится как NULL
+func formatTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+func parseTime(s sql.NullString) time.Time {
+	if !s.Valid {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
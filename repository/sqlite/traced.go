@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"restapi/metrics"
+	"restapi/tracing"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDB открывает спан на каждый SQL запрос: видно, сколько времени
+// запрос провел в базе, включая ожидание единственного соединения. Время
+// и ошибки запросов попадают в метрики хранилища с меткой collection:
+// SELECT как load, остальные запросы как save.
+type tracedDB struct {
+	*sql.DB
+	collection string
+}
+
+// queryStart начатый запрос: спан и время начала для метрик
+type queryStart struct {
+	span  trace.Span
+	op    string
+	start time.Time
+}
+
+func (db tracedDB) start(ctx context.Context, query string) (context.Context, queryStart) {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	ctx, span := tracing.Start(ctx, "sqlite "+op,
+		attribute.String("db.system.name", "sqlite"),
+		attribute.String("db.operation.name", op),
+		attribute.String("db.query.text", query))
+	return ctx, queryStart{span: span, op: op, start: time.Now()}
+}
+
+func (db tracedDB) end(q queryStart, err error) {
+	op := "save"
+	if strings.EqualFold(q.op, "SELECT") {
+		op = "load"
+	}
+	metrics.ObserveStorage("sqlite", op, db.collection, time.Since(q.start), err)
+	tracing.End(q.span, err)
+}
+
+func (db tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, q := db.start(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	db.end(q, err)
+	return res, err
+}
+
+// QueryContext закрывает спан после выполнения запроса, чтение строк
+// остается в спане вызывающего
+func (db tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, q := db.start(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.end(q, err)
+	return rows, err
+}
+
+func (db tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, q := db.start(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.end(q, row.Err())
+	return row
+}
@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"restapi/model"
+	"restapi/repository"
+	"restapi/utils"
+	"time"
+)
+
+// Users реализует model.UserHandler поверх таблицы users
+type Users struct {
+	db tracedDB
+}
+
+// NewUsers создает хранилище пользователей в базе db
+func NewUsers(db *sql.DB) model.UserHandler {
+	return &Users{db: tracedDB{db, repository.Users}}
+}
+
+// Get проверяет доступность базы: данные читаются по запросу
+func (u *Users) Get(ctx context.Context) error {
+	return u.db.PingContext(ctx)
+}
+
+// Save ничего не делает: каждое изменение сразу записывается в базу
+func (u *Users) Save(ctx context.Context) error {
+	return nil
+}
+
+const userColumns = `id, name, surname, role, version, updated_at`
+
+func scanUser(row interface{ Scan(...any) error }) (model.User, error) {
+	var user model.User
+	var updated sql.NullString
+	err := row.Scan(&user.Id, &user.Name, &user.Surname, &user.Role, &user.Version, &updated)
+	user.UpdatedAt = parseTime(updated)
+	return user, err
+}
+
+func (u *Users) AddUser(ctx context.Context, user model.User) error {
+	if user.Role == "" {
+		user.Role = model.RoleMember
+	}
+	_, err := u.db.ExecContext(ctx, `INSERT INTO users (name, surname, role, updated_at) VALUES (?, ?, ?, ?)`,
+		user.Name, user.Surname, user.Role, formatTime(time.Now()))
+	return err
+}
+
+func (u *Users) UpdateUser(ctx context.Context, user model.User) error {
+	// Пустая роль оставляет прежнюю
+	res, err := u.db.ExecContext(ctx, `UPDATE users SET name = ?, surname = ?, role = COALESCE(NULLIF(?, ''), role),
+		version = version + 1, updated_at = ? WHERE id = ? AND `+versionMatch,
+		user.Name, user.Surname, user.Role, formatTime(time.Now()), user.Id, user.Version, user.Version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, u.db, res, "users", user.Id, model.ErrUserNotFound)
+}
+
+func (u *Users) RemoveUser(ctx context.Context, id, version int) error {
+	res, err := u.db.ExecContext(ctx, `DELETE FROM users WHERE id = ? AND `+versionMatch, id, version, version)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, u.db, res, "users", id, model.ErrUserNotFound)
+}
+
+func (u *Users) GetUser(ctx context.Context, id int) []byte {
+	user, err := u.FindUser(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return utils.MarshalThis(ctx, user)
+}
+
+func (u *Users) FindUser(ctx context.Context, id int) (model.User, error) {
+	user, err := scanUser(u.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, model.ErrUserNotFound
+	}
+	return user, err
+}
+
+// WriteAllUsers отдает пользователей пачками по мере чтения из базы
+func (u *Users) WriteAllUsers(ctx context.Context, w io.Writer) error {
+	s := utils.NewJSONStream(ctx, w)
+	model.BeginList(s, "users")
+	total := streamAll(ctx, u.db, s, "users", userColumns, scanUser, func(v model.User) int { return v.Id })
+	model.EndList(s, total)
+	return s.Close()
+}
+
+func (u *Users) GetCount(ctx context.Context) []byte {
+	var count int
+	u.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return utils.MarshalThis(ctx, count)
+}
+
+// checkAffected возвращает notFound, если запрос не затронул ни одной строки
+func checkAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func (u *Users) ListUsers(ctx context.Context, q model.UserQuery) (model.UserList, error) {
+	if err := q.Normalize(model.UserSortFields); err != nil {
+		return model.UserList{}, err
+	}
+
+	var lq listQuery
+	if q.Name != "" {
+		lq.add("name = ? COLLATE NOCASE", q.Name)
+	}
+	if q.Surname != "" {
+		lq.add("surname = ? COLLATE NOCASE", q.Surname)
+	}
+	total, err := lq.count(ctx, u.db, "users")
+	if err != nil {
+		return model.UserList{}, err
+	}
+
+	var after *cursorKey
+	if q.Cursor != "" {
+		var c model.User
+		if err := model.DecodeCursor(q.Page, &c); err != nil {
+			return model.UserList{}, err
+		}
+		values := map[string]any{"id": c.Id, "name": c.Name, "surname": c.Surname}
+		after = &cursorKey{value: values[q.Sort], id: c.Id}
+	}
+
+	tail, args := lq.page(q.Page, q.Sort, after)
+	rows, err := u.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users`+tail, args...)
+	if err != nil {
+		return model.UserList{}, err
+	}
+	defer rows.Close()
+
+	res := model.UserList{Users: []model.User{}, Total: total, Limit: q.Limit, Offset: q.Offset}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return model.UserList{}, err
+		}
+		res.Users = append(res.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return model.UserList{}, err
+	}
+	if len(res.Users) > q.Limit {
+		res.Users = res.Users[:q.Limit]
+		res.NextCursor = model.EncodeCursor(q.Page, res.Users[q.Limit-1])
+	}
+	return res, nil
+}
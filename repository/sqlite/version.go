@@ -0,0 +1,29 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"restapi/model"
+)
+
+// versionMatch условие изменения строки с версией, которую ожидает клиент.
+// Принимает версию дважды, model.AnyVersion (0) отключает проверку.
+const versionMatch = `(? = 0 OR version = ?)`
+
+// checkVersion разбирает результат изменения с условием versionMatch:
+// если строка не затронута, но существует, ее версия не совпала
+func checkVersion(ctx context.Context, db tracedDB, res sql.Result, table string, id int, notFound error) error {
+	if err := checkAffected(res, notFound); !errors.Is(err, notFound) {
+		return err
+	}
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = ?)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return model.ErrVersionConflict
+	}
+	return notFound
+}
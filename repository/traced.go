@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"restapi/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedRepository открывает спан на каждое обращение к хранилищу
+type tracedRepository struct {
+	repo    Repository
+	backend string
+}
+
+// WithTracing оборачивает repo, чтобы каждое чтение и сохранение коллекции
+// было отдельным спаном внутри спана запроса
+func WithTracing(repo Repository, backend string) Repository {
+	return &tracedRepository{repo: repo, backend: backend}
+}
+
+func (r *tracedRepository) Load(ctx context.Context, collection string, v any) error {
+	ctx, span := r.start(ctx, "load", collection)
+	err := r.repo.Load(ctx, collection, v)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) Save(ctx context.Context, collection string, v any) error {
+	ctx, span := r.start(ctx, "save", collection)
+	err := r.repo.Save(ctx, collection, v)
+	tracing.End(span, err)
+	return err
+}
+
+func (r *tracedRepository) start(ctx context.Context, op, collection string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "storage."+op+" "+collection,
+		attribute.String("storage.backend", r.backend),
+		attribute.String("storage.operation", op),
+		attribute.String("storage.collection", collection))
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	repo := WithTracing(NewMemory(), "memory")
+	ctx := context.Background()
+	var v []int
+	tests := []struct {
+		name      string
+		op        func() error
+		wantSpan  string
+		wantError bool
+	}{
+		{name: "сохранение", op: func() error { return repo.Save(ctx, Books, []int{1}) }, wantSpan: "storage.save books"},
+		{name: "чтение", op: func() error { return repo.Load(ctx, Books, &v) }, wantSpan: "storage.load books"},
+		{name: "ошибка сохранения", op: func() error { return repo.Save(ctx, Users, func() {}) }, wantSpan: "storage.save users", wantError: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); (err != nil) != tt.wantError {
+				t.Fatalf("error = %v, want error %v", err, tt.wantError)
+			}
+			spans := rec.Ended()
+			if len(spans) != i+1 {
+				t.Fatalf("got %d spans, want %d", len(spans), i+1)
+			}
+			span := spans[i]
+			if span.Name() != tt.wantSpan || (span.Status().Code == codes.Error) != tt.wantError {
+				t.Errorf("span %q with status %v, want %q with error %v", span.Name(), span.Status(), tt.wantSpan, tt.wantError)
+			}
+			attrs := attribute.NewSet(span.Attributes()...)
+			if backend, _ := attrs.Value("storage.backend"); backend.AsString() != "memory" {
+				t.Errorf("storage.backend = %q, want memory", backend.AsString())
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package search
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// PrefixWeight доля веса совпадения по префиксу относительно совпадения основы
+	PrefixWeight = 0.5
+	// MinPrefix минимальная длина слова запроса, с которой ищется совпадение по префиксу
+	MinPrefix = 2
+)
+
+// Field индексируемое поле документа. Совпадения в полях с большим
+// весом поднимают документ выше в выдаче.
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+// Hit найденный документ и его релевантность
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// Index инвертированный индекс: основа слова -> документы, в которых она встречается.
+// Для поиска по префиксу хранится отсортированный словарь исходных слов.
+type Index struct {
+	mu sync.RWMutex
+
+	docs     map[int]document
+	postings map[string]map[int]float64
+
+	// words отсортированные слова всех документов, stems их основы,
+	// refs число документов с каждым словом
+	words []string
+	stems map[string]string
+	refs  map[string]int
+}
+
+type document struct {
+	terms  map[string]float64
+	words  []string
+	length float64
+}
+
+// NewIndex создает пустой индекс
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[int]document{},
+		postings: map[string]map[int]float64{},
+		stems:    map[string]string{},
+		refs:     map[string]int{},
+	}
+}
+
+// Len возвращает число проиндексированных документов
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Add индексирует документ id, заменяя его прежнюю версию
+func (ix *Index) Add(id int, fields ...Field) {
+	doc := document{terms: map[string]float64{}}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		for _, word := range Tokenize(f.Text) {
+			doc.terms[Stem(word)] += f.Weight
+			doc.length++
+			if !seen[word] {
+				seen[word] = true
+				doc.words = append(doc.words, word)
+			}
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+	ix.docs[id] = doc
+	for term, tf := range doc.terms {
+		if ix.postings[term] == nil {
+			ix.postings[term] = map[int]float64{}
+		}
+		ix.postings[term][id] = tf
+	}
+	for _, word := range doc.words {
+		if ix.refs[word] == 0 {
+			i, _ := slices.BinarySearch(ix.words, word)
+			ix.words = slices.Insert(ix.words, i, word)
+			ix.stems[word] = Stem(word)
+		}
+		ix.refs[word]++
+	}
+}
+
+// Remove удаляет документ id из индекса
+func (ix *Index) Remove(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// remove удаляет документ, вызывающий должен держать ix.mu
+func (ix *Index) remove(id int) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	delete(ix.docs, id)
+	for term := range doc.terms {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	for _, word := range doc.words {
+		ix.refs[word]--
+		if ix.refs[word] > 0 {
+			continue
+		}
+		delete(ix.refs, word)
+		delete(ix.stems, word)
+		if i, found := slices.BinarySearch(ix.words, word); found {
+			ix.words = slices.Delete(ix.words, i, i+1)
+		}
+	}
+}
+
+// Search находит документы, содержащие все слова запроса. Слово совпадает
+// с документом по основе или, с меньшим весом, как префикс слова документа.
+// Результаты упорядочены по убыванию релевантности (TF-IDF), затем по id.
+func (ix *Index) Search(query string) []Hit {
+	words := Tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var scores map[int]float64
+	for _, word := range words {
+		matched := ix.match(word)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for id := range scores {
+			if s, ok := matched[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return hits
+}
+
+// match оценивает документы, совпавшие со словом запроса.
+// Вызывающий должен держать ix.mu на чтение.
+func (ix *Index) match(word string) map[int]float64 {
+	terms := map[string]float64{Stem(word): 1}
+	if utf8.RuneCountInString(word) >= MinPrefix {
+		i, _ := slices.BinarySearch(ix.words, word)
+		for ; i < len(ix.words) && strings.HasPrefix(ix.words[i], word); i++ {
+			if stem := ix.stems[ix.words[i]]; terms[stem] == 0 {
+				terms[stem] = PrefixWeight
+			}
+		}
+	}
+
+	res := map[int]float64{}
+	for term, weight := range terms {
+		docs := ix.postings[term]
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(ix.docs))/float64(len(docs)))
+		for id, tf := range docs {
+			score := weight * tf * idf / math.Sqrt(ix.docs[id].length)
+			res[id] = max(res[id], score)
+		}
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func ids(hits []Hit) []int {
+	res := []int{}
+	for _, h := range hits {
+		res = append(res, h.ID)
+	}
+	return res
+}
+
+// newLibrary индексирует книги так же, как model.SearchableBooks:
+// название весит вдвое больше автора
+func newLibrary() *Index {
+	ix := NewIndex()
+	books := []struct {
+		id           int
+		name, author string
+	}{
+		{1, "Война и мир", "Лев Толстой"},
+		{2, "Анна Каренина", "Лев Толстой"},
+		{3, "Мир без войны", "Иван Миров"},
+		{4, "Толстой: биография", "Павел Басинский"},
+		{5, "Книга о войне", "Мирон Книжников"},
+		{6, "Go Programming", "Alan Donovan"},
+		{7, "Programming Pearls", "Jon Bentley"},
+	}
+	for _, b := range books {
+		ix.Add(b.id, Field{Text: b.name, Weight: 2}, Field{Text: b.author, Weight: 1})
+	}
+	return ix
+}
+
+func TestSearch(t *testing.T) {
+	ix := newLibrary()
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{name: "пустой запрос", query: " ,. ", want: []int{}},
+		{name: "нет совпадений", query: "сапиенс", want: []int{}},
+		{name: "формы слова", query: "войны", want: []int{1, 3, 5}},
+		// Мирон совпадает с мир по префиксу, Миров по основе
+		{name: "все слова запроса", query: "мир война", want: []int{3, 1, 5}},
+		{name: "название выше автора", query: "толстой", want: []int{4, 2, 1}},
+		{name: "точное слово выше префикса", query: "мир", want: []int{3, 1, 5}},
+		{name: "префикс", query: "prog", want: []int{6, 7}},
+		{name: "регистр и ё", query: "ВОЙНЁ", want: []int{1, 3, 5}},
+		{name: "английские окончания", query: "programmed", want: []int{6, 7}},
+		{name: "короткий запрос без префикса", query: "к", want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := ix.Search(tt.query)
+			if got := ids(hits); !slices.Equal(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			for i := 1; i < len(hits); i++ {
+				if hits[i].Score > hits[i-1].Score {
+					t.Errorf("hits are not ordered by score: %+v", hits)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	tests := []struct {
+		name string
+		docs [][]Field
+		q    string
+		want []int
+	}{
+		{
+			name: "совпадение в весомом поле выше",
+			docs: [][]Field{
+				{{Text: "alpha", Weight: 1}, {Text: "beta", Weight: 2}},
+				{{Text: "beta", Weight: 1}, {Text: "alpha", Weight: 2}},
+			},
+			q:    "alpha",
+			want: []int{1, 0},
+		},
+		{
+			name: "короткий документ выше длинного",
+			docs: [][]Field{
+				{{Text: "alpha beta gamma delta", Weight: 1}},
+				{{Text: "alpha beta", Weight: 1}},
+			},
+			q:    "alpha",
+			want: []int{1, 0},
+		},
+		{
+			name: "редкое слово весит больше частого",
+			docs: [][]Field{
+				{{Text: "common rare", Weight: 1}},
+				{{Text: "common common", Weight: 1}},
+				{{Text: "common other", Weight: 1}},
+			},
+			q:    "common",
+			want: []int{1, 0, 2},
+		},
+		{
+			name: "основа выше префикса",
+			docs: [][]Field{
+				{{Text: "planetarium", Weight: 1}},
+				{{Text: "plan", Weight: 1}},
+			},
+			q:    "plan",
+			want: []int{1, 0},
+		},
+		{
+			name: "равные оценки по id",
+			docs: [][]Field{
+				{{Text: "same", Weight: 1}},
+				{{Text: "same", Weight: 1}},
+				{{Text: "same", Weight: 1}},
+			},
+			q:    "same",
+			want: []int{0, 1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ix := NewIndex()
+			for id, fields := range tt.docs {
+				ix.Add(id, fields...)
+			}
+			if got := ids(ix.Search(tt.q)); !slices.Equal(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexUpdate(t *testing.T) {
+	ix := newLibrary()
+
+	// Новая версия документа заменяет прежнюю вместе со словарем префиксов
+	ix.Add(6, Field{Text: "Concurrency in Go", Weight: 2})
+	if got := ids(ix.Search("prog")); !slices.Equal(got, []int{7}) {
+		t.Errorf("after update prog = %v, want [7]", got)
+	}
+	if got := ids(ix.Search("concur")); !slices.Equal(got, []int{6}) {
+		t.Errorf("after update concur = %v, want [6]", got)
+	}
+
+	ix.Remove(7)
+	ix.Remove(7)
+	if got := ids(ix.Search("prog")); len(got) != 0 {
+		t.Errorf("after remove prog = %v, want none", got)
+	}
+	if ix.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", ix.Len())
+	}
+	if len(ix.words) != len(ix.refs) || len(ix.words) != len(ix.stems) {
+		t.Errorf("dictionary out of sync: %d words, %d refs, %d stems", len(ix.words), len(ix.refs), len(ix.stems))
+	}
+}
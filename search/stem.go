@@ -0,0 +1,171 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Tokenize разбивает текст на слова: последовательности букв и цифр.
+// Слова приводятся к нижнему регистру, ё заменяется на е.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = strings.ReplaceAll(strings.ToLower(w), "ё", "е")
+	}
+	return words
+}
+
+// Stem приводит слово к основе. Русские слова обрабатываются стеммером
+// Snowball, латиница отрезанием основных английских окончаний,
+// остальные слова возвращаются без изменений.
+func Stem(word string) string {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return stemRussian(word)
+		}
+	}
+	return stemEnglish(word)
+}
+
+// stemEnglish облегченное отрезание окончаний множественного числа и глагольных форм
+func stemEnglish(word string) string {
+	switch n := len(word); {
+	case n > 4 && strings.HasSuffix(word, "ies"):
+		return word[:n-3] + "y"
+	case n > 4 && strings.HasSuffix(word, "sses"):
+		return word[:n-2]
+	case n > 5 && strings.HasSuffix(word, "ing"):
+		return word[:n-3]
+	case n > 4 && strings.HasSuffix(word, "ed"):
+		return word[:n-2]
+	case n > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:n-1]
+	}
+	return word
+}
+
+// ending окончание из классов стеммера Snowball. afterA означает,
+// что окончание отрезается только после а или я, которые остаются в основе.
+type ending struct {
+	suffix []rune
+	afterA bool
+}
+
+// endings собирает класс окончаний, самые длинные проверяются первыми
+func endings(afterA, other string) []ending {
+	var res []ending
+	for _, s := range strings.Fields(afterA) {
+		res = append(res, ending{[]rune(s), true})
+	}
+	for _, s := range strings.Fields(other) {
+		res = append(res, ending{[]rune(s), false})
+	}
+	slices.SortStableFunc(res, func(a, b ending) int { return len(b.suffix) - len(a.suffix) })
+	return res
+}
+
+var (
+	perfectiveGerund = endings("в вши вшись", "ив ивши ившись ыв ывши ывшись")
+	adjective        = endings("", "ее ие ые ое ими ыми ей ий ый ой ем им ым ом его ого ему ому их ых ую юю ая яя ою ею")
+	participle       = endings("ем нн вш ющ щ", "ивш ывш ующ")
+	reflexive        = endings("", "ся сь")
+	verb             = endings("ла на ете йте ли й л ем н ло но ет ют ны ть ешь нно",
+		"ила ыла ена ейте уйте ите или ыли ей уй ил ыл им ым ен ило ыло ено ят ует уют ит ыт ены ить ыть ишь ую ю")
+	noun         = endings("", "а ев ов ие ье е иями ями ами еи ии и ией ей ой ий й иям ям ием ем ам ом о у ах иях ях ы ь ию ью ю ия ья я")
+	superlative  = endings("", "ейш ейше")
+	derivational = endings("", "ост ость")
+)
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("аеиоуыэюя", r)
+}
+
+// cut отрезает от w самое длинное окончание из класса, целиком лежащее
+// в области начиная с позиции from. Возвращает новую длину слова.
+func cut(w []rune, from int, class []ending) (int, bool) {
+	for _, e := range class {
+		n := len(w) - len(e.suffix)
+		if n < from || !slices.Equal(w[n:], e.suffix) {
+			continue
+		}
+		if e.afterA && (n-1 < from || (w[n-1] != 'а' && w[n-1] != 'я')) {
+			continue
+		}
+		return n, true
+	}
+	return len(w), false
+}
+
+// stemRussian стеммер Snowball для русского языка
+// (https://snowballstem.org/algorithms/russian/stemmer.html)
+func stemRussian(word string) string {
+	w := []rune(word)
+
+	// RV начинается после первой гласной, R1 после первой согласной,
+	// следующей за гласной, R2 так же внутри R1
+	rv := len(w)
+	for i, r := range w {
+		if isVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+	region := func(from int) int {
+		for i := from; i+1 < len(w); i++ {
+			if isVowel(w[i]) && !isVowel(w[i+1]) {
+				return i + 2
+			}
+		}
+		return len(w)
+	}
+	r2 := region(region(0))
+
+	// Шаг 1: деепричастие, иначе возвратная частица и затем
+	// прилагательное (с причастием), глагол или существительное
+	if n, ok := cut(w, rv, perfectiveGerund); ok {
+		w = w[:n]
+	} else {
+		n, _ := cut(w, rv, reflexive)
+		w = w[:n]
+		if n, ok := cut(w, rv, adjective); ok {
+			w = w[:n]
+			n, _ = cut(w, rv, participle)
+			w = w[:n]
+		} else if n, ok := cut(w, rv, verb); ok {
+			w = w[:n]
+		} else {
+			n, _ = cut(w, rv, noun)
+			w = w[:n]
+		}
+	}
+
+	// Шаг 2
+	if len(w) > rv && w[len(w)-1] == 'и' {
+		w = w[:len(w)-1]
+	}
+
+	// Шаг 3
+	if n, ok := cut(w, r2, derivational); ok {
+		w = w[:n]
+	}
+
+	// Шаг 4
+	undouble := func() {
+		if n := len(w); n-2 >= rv && w[n-1] == 'н' && w[n-2] == 'н' {
+			w = w[:n-1]
+		}
+	}
+	if n, ok := cut(w, rv, superlative); ok {
+		w = w[:n]
+		undouble()
+	} else if n := len(w); n > rv && w[n-1] == 'ь' {
+		w = w[:n-1]
+	} else {
+		undouble()
+	}
+	return string(w)
+}
@@ -0,0 +1,62 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: []string{}},
+		{text: "Война и мир", want: []string{"война", "и", "мир"}},
+		{text: "Ёжик в тумане, 2-е изд.!", want: []string{"ежик", "в", "тумане", "2", "е", "изд"}},
+		{text: "  The Go  Programming_Language ", want: []string{"the", "go", "programming", "language"}},
+	}
+	for _, tt := range tests {
+		got := Tokenize(tt.text)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		// Формы одного слова сводятся к одной основе
+		{"книга", "книг"},
+		{"книги", "книг"},
+		{"книгой", "книг"},
+		{"война", "войн"},
+		{"войны", "войн"},
+		{"мир", "мир"},
+		{"мира", "мир"},
+		{"красивейший", "красив"},
+		{"бегущий", "бегущ"},
+		{"читаешь", "чита"},
+		{"стихотворения", "стихотворен"},
+		{"ponies", "pony"},
+		{"classes", "class"},
+		{"reading", "read"},
+		{"jumped", "jump"},
+		{"books", "book"},
+		// Короткие слова и исключения не обрезаются
+		{"bus", "bus"},
+		{"analysis", "analysis"},
+		{"stress", "stress"},
+		{"go", "go"},
+		{"1984", "1984"},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.want {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"restapi/auth"
+	"restapi/config"
+	"restapi/handler"
+	"restapi/health"
+	"restapi/model"
+	"restapi/repository"
+	"testing"
+)
+
+// newTestServer собирает сервер с хранилищами в памяти и возвращает его
+// обработчик со всеми middleware
+func newTestServer(t *testing.T, cfg *config.Config) http.Handler {
+	t.Helper()
+	repo := repository.NewMemory()
+	sessions, err := auth.NewSessions(auth.SessionConfig{KeyFile: filepath.Join(t.TempDir(), "jwt.key")}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyStore(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers, err := handler.NewHandlerManager(repo, model.DeleteRestrict, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers["health"] = handler.NewHealthHandler(health.New(), "test")
+
+	s := NewServer(cfg, handlers, keys, sessions, nil)
+	s.Init()
+	return s.handler()
+}
+
+// Браузер отправляет предварительный запрос без ключа API, поэтому он
+// получает ответ раньше проверки ключа и маршрутизации. Отказ в доступе
+// несет заголовки CORS, чтобы страница могла прочитать ошибку.
+func TestCORSPreflightBeforeAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.Swagger.Enabled = false
+	cfg.CORS.Origins = "https://app.example.com"
+	h := newTestServer(t, cfg)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		origin     string
+		reqMethod  string
+		wantStatus int
+		wantOrigin string
+		wantAllow  bool
+	}{
+		{name: "предварительный запрос к API", method: http.MethodOptions, target: "/api/v1/books/add",
+			origin: "https://app.example.com", reqMethod: "POST",
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantAllow: true},
+		{name: "предварительный запрос к неизвестному пути", method: http.MethodOptions, target: "/api/v1/nothing",
+			origin: "https://app.example.com", reqMethod: "GET",
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantAllow: true},
+		{name: "предварительный запрос чужого источника", method: http.MethodOptions, target: "/api/v1/books",
+			origin: "https://evil.example.net", reqMethod: "GET",
+			wantStatus: http.StatusNoContent},
+		{name: "запрос без ключа", method: http.MethodGet, target: "/api/v1/books",
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusUnauthorized, wantOrigin: "https://app.example.com"},
+		{name: "OPTIONS без CORS проходит в маршрутизатор", method: http.MethodOptions, target: "/api/v1/books",
+			wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+				r.Header.Set("Access-Control-Request-Headers", "x-api-key")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers") != ""; got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Headers = %q, want present %v", w.Header().Get("Access-Control-Allow-Headers"), tt.wantAllow)
+			}
+			if w.Header().Get("X-Request-ID") == "" {
+				t.Error("response has no X-Request-ID")
+			}
+		})
+	}
+}
@@ -1,11 +1,22 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"restapi/auth"
+	"restapi/config"
 	"restapi/handler"
+	"restapi/logging"
+	"restapi/metrics"
 	"restapi/middleware"
+	"restapi/ratelimit"
 	"restapi/utils"
+	"strconv"
+	"time"
 
 	_ "restapi/docs" // Импорт сгенерированной документации
 
@@ -13,60 +24,72 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-const (
-	apikey = "12345"
-)
+// healthRoute маршрут проверок для оркестратора
+const healthRoute = "/{action:healthz|readyz|version}"
 
 // Server структура HTTP сервера
 // @Description Основной сервер приложения с маршрутизацией и middleware
 type Server struct {
-	port     string
+	cfg      *config.Config
 	router   *mux.Router
 	handlers handler.HandlerManager
+	keys     *auth.KeyStore
+	sessions *auth.Sessions
+	limiter  *ratelimit.Limiter
+	// readOnly причина работы только на чтение, nil в обычном режиме
+	readOnly error
 }
 
 // NewServer создает новый экземпляр сервера
 // @Summary Создать новый сервер
-// @Description Инициализирует новый HTTP сервер с указанным портом
-// @Param port query string false "Порт для запуска сервера" default(8080)
+// @Description Инициализирует новый HTTP сервер с указанным портом. Запросы к API
+// @Description проверяются по ключам из keys или по JWT, выданным sessions.
+// @Description Частоту запросов ограничивает limiter, nil отключает ограничение.
+// @Description Адрес, таймауты, TLS и Swagger задаются в cfg.
 // @Return *Server новый экземпляр сервера
-func NewServer(port string) *Server {
-	if port == "" {
-		port = ":8080"
-	}
-	if port[0] != ':' {
-		port = ":" + port
-	}
+func NewServer(cfg *config.Config, handlers handler.HandlerManager, keys *auth.KeyStore, sessions *auth.Sessions, limiter *ratelimit.Limiter) *Server {
 	return &Server{
-		port:     port,
+		cfg:      cfg,
 		router:   mux.NewRouter(),
-		handlers: handler.NewHandlerManager(),
+		handlers: handlers,
+		keys:     keys,
+		sessions: sessions,
+		limiter:  limiter,
 	}
 }
 
+// SetReadOnly запрещает изменение книг, пользователей и покупок: данные
+// загрузились с ошибкой reason. Вызывается до Init.
+func (s *Server) SetReadOnly(reason error) {
+	s.readOnly = reason
+}
+
 // Init инициализирует маршруты и middleware сервера
 // @Summary Инициализировать сервер
 // @Description Настраивает все маршруты API, middleware и Swagger документацию
 func (s Server) Init() {
 	s.router.NotFoundHandler = utils.ErrNotFoundApi
+	s.router.MethodNotAllowedHandler = utils.ErrMethodNotAllowed
 
-	// Swagger UI документация
-	s.router.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
-		httpSwagger.URL("/swagger/doc.json"),
-		httpSwagger.DocExpansion("none"),
-		httpSwagger.DomID("#swagger-ui"),
-		httpSwagger.UIConfig(map[string]string{
-			"displayRequestDuration": "true",
-			"filter":                 "true",
-		}),
-		httpSwagger.PersistAuthorization(true),
-	))
-
-	// Serve swagger.json напрямую
-	s.router.HandleFunc("/swagger/doc.json", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		http.ServeFile(w, r, "./docs/swagger.json")
-	})
+	if s.cfg.Swagger.Enabled {
+		// Спецификация отдается из файла, поэтому ее маршрут идет раньше Swagger UI
+		s.router.HandleFunc("/swagger/doc.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, s.cfg.Swagger.Spec)
+		})
+
+		// Swagger UI документация
+		s.router.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
+			httpSwagger.URL("/swagger/doc.json"),
+			httpSwagger.DocExpansion("none"),
+			httpSwagger.DomID("#swagger-ui"),
+			httpSwagger.UIConfig(map[string]string{
+				"displayRequestDuration": "true",
+				"filter":                 "true",
+			}),
+			httpSwagger.PersistAuthorization(true),
+		))
+	}
 
 	// Стартовая страница
 	s.router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -143,8 +166,8 @@ func (s Server) Init() {
 				<div class="card">
 					<h2>🚀 Quick Start</h2>
 					<p>Это REST API для управления библиотекой книг, пользователями и историей покупок.</p>
-					<p><strong>Base URL:</strong> <code>http://localhost` + s.port + `/api</code></p>
-					<p><strong>API Key:</strong> <span class="api-key">12345</span> (используйте в заголовке X-API-Key)</p>
+					<p><strong>Base URL:</strong> <code>` + s.baseURL() + `/api</code></p>
+					<p><strong>API Key:</strong> ключ выдает администратор (используйте в заголовке X-API-Key)</p>
 				</div>
 				
 				<div class="card">
@@ -159,9 +182,37 @@ func (s Server) Init() {
 					<h2>🔐 Authentication</h2>
 					<p>Все API endpoints требуют API ключ в заголовке:</p>
 					<div class="endpoint">
-						<strong>Header:</strong> X-API-Key: 12345
+						<strong>Header:</strong> X-API-Key: &lt;ваш ключ&gt;
 					</div>
-					<p>Исключение: Swagger UI и главная страница не требуют аутентификации.</p>
+					<p>Каждый ключ имеет набор прав вида <code>действие:ресурс</code>
+					(<code>read:books</code>, <code>write:users</code>, <code>delete:story</code>...).
+					Без нужного права запрос получает 403.</p>
+					<p>Ключи выпускаются и отзываются через <code>/api/v2/keys</code> ключом с правом <code>admin:keys</code>.
+					Первый ключ администратора создается при первом запуске.</p>
+					<p>Пользователи с заданным паролем могут вместо ключа войти через
+					<code>POST /api/v2/auth/login</code> и передавать токен в заголовке
+					<code>Authorization: Bearer &lt;access_token&gt;</code>.
+					Токены обновляются через <code>/api/v2/auth/refresh</code> и отзываются через <code>/api/v2/auth/revoke</code>.</p>
+					<p>Права пользователя определяются его ролью: <code>admin</code> может все,
+					<code>librarian</code> ведет каталог, пользователей и выдачу, <code>member</code> видит каталог,
+					свою карточку <code>/users/{id}</code> и свою историю <code>/story/user/{id}</code>.
+					Роль назначает администратор полем <code>role</code> в <code>/users/add</code> и <code>/users/update</code>.
+					Отказ возвращает 403 с кодом <code>insufficient_role</code> или <code>not_owner</code>.</p>
+					<p>Частота запросов каждого ключа, пользователя или IP ограничена. Текущий запас сообщают
+					заголовки <code>RateLimit-Limit</code>, <code>RateLimit-Remaining</code> и <code>RateLimit-Reset</code>,
+					при превышении ответ 429 с кодом <code>rate_limited</code> или <code>quota_exceeded</code> и заголовком <code>Retry-After</code>.
+					Запросы с неверным ключом или токеном расходуют запас IP адреса.</p>
+					<p>Если сервер настроен на проверку клиентских сертификатов (mTLS), сертификат,
+					выданный доверенным УЦ, заменяет ключ API.</p>
+					<p>Браузерные приложения с других источников могут обращаться к API, если их
+					источник разрешен настройкой <code>cors.origins</code>.</p>
+					<p>Книги, пользователи и покупки имеют версию. <code>GET /books/{id}</code>, <code>/users/{id}</code>
+					и <code>/story/id/{id}</code> возвращают ее в заголовках <code>ETag</code> и <code>Last-Modified</code>,
+					с <code>If-None-Match</code> неизменившаяся запись дает 304. Изменение и удаление записи требуют
+					заголовка <code>If-Match</code> с ее ETag: без него ответ 428, если запись успела измениться, 412
+					с кодом <code>version_mismatch</code>.</p>
+					<p>Исключение: Swagger UI, главная страница и проверки <code>/healthz</code>, <code>/readyz</code>,
+					<code>/version</code>, а также метрики Prometheus <code>/metrics</code> не требуют аутентификации.</p>
 				</div>
 				
 				<div class="card">
@@ -181,6 +232,9 @@ func (s Server) Init() {
 					<div class="endpoint">
 						<span class="method get">GET</span> <strong>/books</strong> - получить все книги
 					</div>
+					<div class="endpoint">
+						<span class="method get">GET</span> <strong>/books/search?q=...</strong> - полнотекстовый поиск по названию и автору
+					</div>
 					<div class="endpoint">
 						<span class="method get">GET</span> <strong>/books/{id}</strong> - получить книгу по ID
 					</div>
@@ -213,15 +267,21 @@ func (s Server) Init() {
 					<div class="endpoint">
 						<span class="method get">GET</span> <span class="method put">PUT</span> <span class="method delete">DELETE</span> <strong>/story/{action}/{id}</strong> - полный CRUD для покупок
 					</div>
+					<div class="endpoint">
+						<span class="method get">GET</span> <span class="method post">POST</span> <strong>/keys</strong> - список и выпуск ключей API (admin:keys)
+					</div>
+					<div class="endpoint">
+						<span class="method get">GET</span> <span class="method delete">DELETE</span> <strong>/keys/{id}</strong> - просмотр и отзыв ключа API (admin:keys)
+					</div>
 				</div>
 				
 				<div class="card">
 					<h2>📞 Примеры запросов</h2>
 					<div class="endpoint">
-						<strong>curl -X GET "http://localhost` + s.port + `/api/v1/books" -H "X-API-Key: 12345"</strong>
+						<strong>curl -X GET "` + s.baseURL() + `/api/v1/books" -H "X-API-Key: $API_KEY"</strong>
 					</div>
 					<div class="endpoint">
-						<strong>curl -X POST "http://localhost` + s.port + `/api/v1/users/add" -d "name=John&surname=Doe" -H "X-API-Key: 12345"</strong>
+						<strong>curl -X POST "` + s.baseURL() + `/api/v1/users/add" -d "name=John&surname=Doe" -H "X-API-Key: $API_KEY"</strong>
 					</div>
 				</div>
 				
@@ -234,15 +294,54 @@ func (s Server) Init() {
 		`))
 	})
 
+	// Проверки для оркестратора и метрики доступны без ключа
+	if h, ok := s.handlers["health"]; ok {
+		s.router.Handle(healthRoute, h).Methods("GET", "HEAD")
+	}
+	if s.cfg.Metrics.Enabled {
+		s.router.Handle(s.cfg.Metrics.Path, metrics.Handler()).Methods("GET")
+	}
+
+	// Вход по логину и паролю не требует ключа, поэтому маршрут
+	// регистрируется до защищенного подмаршрутизатора /api
+	// и ограничивается по IP
+	authHandler := middleware.TraceHandler("auth", s.handlers["auth"])
+	s.router.Handle("/api/v2/auth/{action:login|refresh|revoke}", s.limited(authHandler)).Methods("POST")
+
 	var api = s.router.PathPrefix("/api").Subrouter()
 	api.NotFoundHandler = utils.ErrNotFoundApi
+	api.MethodNotAllowedHandler = utils.ErrMethodNotAllowed
 
-	// Middleware для проверки API ключа
-	api.Use(middleware.APIKeyMiddleware(apikey))
+	// Частота запросов ограничивается по IP еще до проверки учетных данных,
+	// чтобы запросы с неверным ключом тоже учитывались
+	if s.limiter != nil {
+		api.Use(middleware.Traced("rate_limit_ip", middleware.PreAuthRateLimit(s.limiter)))
+	}
+
+	// Middleware для проверки Bearer токена, клиентского сертификата, затем API ключа
+	api.Use(middleware.Traced("bearer", middleware.BearerMiddleware(s.sessions)))
+	if s.cfg.TLS.ClientCA != "" {
+		// Права уже проверены при загрузке настроек
+		scopes, _ := auth.ParseScopes(s.cfg.TLS.ClientScopes)
+		api.Use(middleware.Traced("client_cert", middleware.ClientCertMiddleware(scopes)))
+	}
+	api.Use(middleware.Traced("api_key", middleware.APIKeyMiddleware(s.keys)))
+	api.Use(middleware.LogPrincipal)
+	if s.limiter != nil {
+		api.Use(middleware.Traced("rate_limit", middleware.RateLimit(s.limiter)))
+	}
+
+	users := middleware.TraceHandler("users", s.handlers["users"])
+	books := middleware.TraceHandler("books", s.handlers["books"])
+	story := middleware.TraceHandler("story", s.handlers["story"])
+	if s.readOnly != nil {
+		users, books, story = middleware.ReadOnly(users), middleware.ReadOnly(books), middleware.ReadOnly(story)
+	}
 
 	// API Version 1
 	var v1 = api.PathPrefix("/v1").Subrouter()
 	v1.NotFoundHandler = utils.ErrNotFoundApi
+	v1.MethodNotAllowedHandler = utils.ErrMethodNotAllowed
 	v1.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"version": "1.0", "status": "active", "message": "API v1 is running"}`))
@@ -250,60 +349,243 @@ func (s Server) Init() {
 
 	{
 		// Users endpoints v1
-		v1.Handle("/users/{id}", s.handlers["users"]).Methods("GET")
-		v1.Handle("/users/{action}", s.handlers["users"]).Methods("POST")
+		v1.Handle("/users/{id}", owned(auth.ReadUsers, users)).Methods("GET")
+		v1.Handle("/users/{action}", scoped(auth.WriteUsers, users)).Methods("POST")
 
 		// Books endpoints v1
-		v1.Handle("/books/{id}", s.handlers["books"]).Methods("GET")
-		v1.Handle("/books/{action}", s.handlers["books"]).Methods("POST")
+		v1.Handle("/books/{action:search}", scoped(auth.ReadBooks, books)).Methods("GET")
+		v1.Handle("/books/{id}", scoped(auth.ReadBooks, books)).Methods("GET")
+		v1.Handle("/books/{action}", scoped(auth.WriteBooks, books)).Methods("POST")
 
 		// Story endpoints v1
-		v1.Handle("/story/{action}/{id}", s.handlers["story"]).Methods("GET", "PUT")
+		v1.Handle("/story/{action:user}/{id}", owned(auth.ReadStory, story)).Methods("GET")
+		v1.Handle("/story/{action}/{id}", scoped(auth.ReadStory, story)).Methods("GET")
+		v1.Handle("/story/{action}/{id}", scoped(auth.WriteStory, story)).Methods("PUT")
 
 		// Collection endpoints v1
-		v1.Handle("/story", s.handlers["story"]).Methods("GET", "POST")
-		v1.Handle("/users", s.handlers["users"]).Methods("GET")
-		v1.Handle("/books", s.handlers["books"]).Methods("GET")
+		v1.Handle("/story", scoped(auth.ReadStory, story)).Methods("GET")
+		v1.Handle("/story", scoped(auth.WriteStory, story)).Methods("POST")
+		v1.Handle("/users", scoped(auth.ReadUsers, users)).Methods("GET")
+		v1.Handle("/books", scoped(auth.ReadBooks, books)).Methods("GET")
 	}
 
 	// API Version 2
 	var v2 = api.PathPrefix("/v2").Subrouter()
 	v2.NotFoundHandler = utils.ErrNotFoundApi
+	v2.MethodNotAllowedHandler = utils.ErrMethodNotAllowed
 	v2.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"version": "2.0", "status": "active", "message": "API v2 is running", "features": ["delete_operations"]}`))
 	})
 	{
 		// Users endpoints v2
-		v2.Handle("/users/{id}", s.handlers["users"]).Methods("GET", "DELETE")
-		v2.Handle("/users/{action}", s.handlers["users"]).Methods("POST")
+		v2.Handle("/users/{id}", owned(auth.ReadUsers, users)).Methods("GET")
+		v2.Handle("/users/{id}", scoped(auth.DeleteUsers, users)).Methods("DELETE")
+		v2.Handle("/users/{action}", scoped(auth.WriteUsers, users)).Methods("POST")
+		v2.Handle("/users/{id}/{action:credentials}", scoped(auth.AdminUsers, users)).Methods("POST", "DELETE")
 
 		// Books endpoints v2
-		v2.Handle("/books/{id}", s.handlers["books"]).Methods("GET", "DELETE")
-		v2.Handle("/books/{action}", s.handlers["books"]).Methods("POST")
+		v2.Handle("/books/{action:search}", scoped(auth.ReadBooks, books)).Methods("GET")
+		v2.Handle("/books/{id}", scoped(auth.ReadBooks, books)).Methods("GET")
+		v2.Handle("/books/{id}", scoped(auth.DeleteBooks, books)).Methods("DELETE")
+		v2.Handle("/books/{action}", scoped(auth.WriteBooks, books)).Methods("POST")
 
 		// Story endpoints v2
-		v2.Handle("/story/{action}/{id}", s.handlers["story"]).Methods("GET", "PUT", "DELETE")
+		v2.Handle("/story/{action:user}/{id}", owned(auth.ReadStory, story)).Methods("GET")
+		v2.Handle("/story/{action}/{id}", scoped(auth.ReadStory, story)).Methods("GET")
+		v2.Handle("/story/{action}/{id}", scoped(auth.WriteStory, story)).Methods("PUT")
+		v2.Handle("/story/{action}/{id}", scoped(auth.DeleteStory, story)).Methods("DELETE")
 
 		// Collection endpoints v2
-		v2.Handle("/story", s.handlers["story"]).Methods("GET", "POST")
-		v2.Handle("/users", s.handlers["users"]).Methods("GET")
-		v2.Handle("/books", s.handlers["books"]).Methods("GET")
+		v2.Handle("/story", scoped(auth.ReadStory, story)).Methods("GET")
+		v2.Handle("/story", scoped(auth.WriteStory, story)).Methods("POST")
+		v2.Handle("/users", scoped(auth.ReadUsers, users)).Methods("GET")
+		v2.Handle("/books", scoped(auth.ReadBooks, books)).Methods("GET")
+
+		// Текущий ключ или пользователь
+		v2.Handle("/auth/{action:me}", authHandler).Methods("GET")
+
+		// API keys v2
+		keys := middleware.TraceHandler("keys", handler.NewKeyHandler(s.keys))
+		v2.Handle("/keys", scoped(auth.AdminKeys, keys)).Methods("GET", "POST")
+		v2.Handle("/keys/{id}", scoped(auth.AdminKeys, keys)).Methods("GET", "DELETE")
+	}
+
+	s.checkRateLimits()
+}
+
+// limited ограничивает частоту запросов к h, если задан limiter
+func (s Server) limited(h http.Handler) http.Handler {
+	if s.limiter == nil {
+		return h
+	}
+	return middleware.RateLimit(s.limiter)(h)
+}
+
+// checkRateLimits предупреждает об ограничениях для маршрутов, которых нет в роутере
+func (s Server) checkRateLimits() {
+	if s.limiter == nil {
+		return
 	}
+	known := make(map[string]bool)
+	s.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		known[tpl] = true
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			known[m+" "+tpl] = true
+		}
+		return nil
+	})
+	for _, route := range s.limiter.Routes() {
+		if !known[route] {
+			slog.Warn("⚠️ Rate limit for unknown route is ignored", "route", route)
+		}
+	}
+}
+
+// scoped пропускает к обработчику h только запросы с ключом, имеющим право scope
+func scoped(scope string, h http.Handler) http.Handler {
+	return middleware.Traced("scope", middleware.RequireScope(scope))(h)
 }
 
-// StartServer запускает HTTP сервер
+// owned как scoped, но пропускает и пользователя с правом scope:own
+// к записям, номер владельца которых указан в пути как {id}
+func owned(scope string, h http.Handler) http.Handler {
+	return middleware.Traced("scope", middleware.RequireOwnScope(scope, "id"))(h)
+}
+
+// StartServer запускает HTTP сервер и работает до отмены ctx. После отмены
+// сервер перестает принимать соединения и ждет завершения начатых запросов
+// не дольше server.shutdown_timeout.
 // @Summary Запустить сервер
 // @Description Запускает HTTP сервер на указанном порту
-func (s *Server) StartServer() {
-	log.Printf("🚀 Server starting on http://localhost%s", s.port)
-	log.Printf("📖 Swagger UI: http://localhost%s/swagger/", s.port)
-	log.Printf("🔐 API Key required: %s", apikey)
-	log.Printf("🌐 API v1: http://localhost%s/api/v1", s.port)
-	log.Printf("🌐 API v2: http://localhost%s/api/v2", s.port)
-
-	err := http.ListenAndServe(s.port, s.router)
-	if err != nil {
-		log.Fatalf("❌ Server error: %s", err)
+func (s *Server) StartServer(ctx context.Context) error {
+	base := s.baseURL()
+	slog.Info("🚀 Server starting", "url", base)
+	if s.cfg.Swagger.Enabled {
+		slog.Info("📖 Swagger UI", "url", base+"/swagger/")
+	}
+	slog.Info("🔐 API key required in X-API-Key header")
+	slog.Info("🌐 API v1", "url", base+"/api/v1")
+	slog.Info("🌐 API v2", "url", base+"/api/v2")
+
+	srv := &http.Server{
+		Addr:              s.cfg.Server.Addr(),
+		Handler:           s.handler(),
+		ErrorLog:          logging.ErrorLog(),
+		ReadTimeout:       s.cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      s.cfg.Server.WriteTimeout,
+		IdleTimeout:       s.cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    s.cfg.Server.MaxHeaderBytes,
+		Protocols:         new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	if s.cfg.TLS.Enabled() {
+		srv.Protocols.SetHTTP2(s.cfg.Server.HTTP2)
+	} else {
+		srv.Protocols.SetUnencryptedHTTP2(s.cfg.Server.H2C)
+	}
+	servers := []*http.Server{srv}
+
+	if s.cfg.TLS.Enabled() {
+		if s.cfg.TLS.Dev {
+			if err := ensureDevCert(s.cfg.TLS.Cert, s.cfg.TLS.Key, devCertHosts(s.cfg.Server.Bind)); err != nil {
+				return err
+			}
+		}
+		tc, err := tlsConfig(s.cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		srv.TLSConfig = tc
+		if s.cfg.TLS.ClientCA != "" {
+			slog.Info("🪪 Client certificates accepted instead of API keys", "client_auth", s.cfg.TLS.ClientAuth)
+		}
+
+		if port := s.cfg.TLS.RedirectPort; port != 0 {
+			servers = append(servers, &http.Server{
+				Addr:              net.JoinHostPort(s.cfg.Server.Bind, strconv.Itoa(port)),
+				Handler:           redirectHandler(s.cfg.Server.Port),
+				ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout,
+				IdleTimeout:       s.cfg.Server.IdleTimeout,
+				MaxHeaderBytes:    s.cfg.Server.MaxHeaderBytes,
+				ErrorLog:          logging.ErrorLog(),
+			})
+			slog.Info("↪️ HTTP redirects to HTTPS", "port", port)
+		}
+	}
+
+	errc := make(chan error, len(servers))
+	for _, hs := range servers {
+		go func() {
+			if hs.TLSConfig != nil {
+				errc <- hs.ListenAndServeTLS(s.cfg.TLS.Cert, s.cfg.TLS.Key)
+			} else {
+				errc <- hs.ListenAndServe()
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+		// Один из серверов не запустился, остальные тоже останавливаются
+	case <-ctx.Done():
+		slog.Info("🛑 Shutting down, waiting for active requests", "timeout", s.cfg.Server.ShutdownTimeout.String())
+		if d, ok := s.handlers["health"].(interface{ SetDraining() }); ok {
+			d.SetDraining()
+		}
+		if delay := s.cfg.Server.DrainDelay; delay > 0 {
+			slog.Info("⏳ Not ready, still accepting requests", "drain_delay", delay.String())
+			time.Sleep(delay)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	for _, hs := range servers {
+		if serr := hs.Shutdown(shutdownCtx); serr != nil {
+			// Не дождались: оставшиеся соединения закрываются принудительно
+			hs.Close()
+			err = errors.Join(err, fmt.Errorf("shutdown: %w", serr))
+		}
+	}
+	return err
+}
+
+// handler оборачивает маршрутизатор в идентификатор запроса, перехват
+// паник, трассировку, журнал доступа, сжатие, CORS и метрики. Предварительные
+// запросы CORS получают ответ до маршрутизации и проверки ключа API. Журнал
+// доступа видит размер ответа после сжатия, метрики до него. Recover стоит
+// снаружи всех middleware, кроме RequestID: паника в любом из них дает ответ
+// 500, а в журнале и ответе есть идентификатор запроса.
+func (s *Server) handler() http.Handler {
+	h := metrics.Instrument(s.router)
+	if s.cfg.CORS.Enabled() {
+		h = middleware.CORS(s.cfg.CORS.Options())(h)
+	}
+	if s.cfg.Compression.Enabled {
+		h = middleware.Compress(s.cfg.Compression.Options())(h)
+	}
+	h = middleware.AccessLog(s.router, healthRoute, s.cfg.Metrics.Path)(h)
+	h = middleware.Tracing(s.router)(h)
+	h = middleware.Recover(h)
+	return middleware.RequestID(h)
+}
+
+// baseURL адрес сервера для ссылок на стартовой странице и в журнале
+func (s *Server) baseURL() string {
+	scheme, host := "http", s.cfg.Server.Bind
+	if s.cfg.TLS.Enabled() {
+		scheme = "https"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
 	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(s.cfg.Server.Port))
 }
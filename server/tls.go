@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"restapi/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// devCertTTL срок действия самоподписанного сертификата для разработки
+const devCertTTL = 365 * 24 * time.Hour
+
+// tlsConfig настраивает TLS сервера: минимальная версия 1.2 и, если задан
+// tls.client_ca, проверка клиентских сертификатов
+func tlsConfig(cfg config.TLS) (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA == "" {
+		return tc, nil
+	}
+	data, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", cfg.ClientCA)
+	}
+	tc.ClientCAs = pool
+	tc.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.ClientAuth == config.ClientAuthRequire {
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// ensureDevCert создает самоподписанный сертификат для hosts, если файлов
+// certFile и keyFile еще нет или сертификат истек
+func ensureDevCert(certFile, keyFile string, hosts []string) error {
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if time.Now().Before(pair.Leaf.NotAfter) {
+			return nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("dev certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Library REST API dev"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCertTTL),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else if h != "" {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(der)
+	slog.Warn("🔏 Generated self-signed dev certificate, do not use it in production",
+		"cert", certFile, "sha256", hex.EncodeToString(sum[:]))
+	return nil
+}
+
+// devCertHosts имена, на которые выписывается сертификат для разработки
+func devCertHosts(bind string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if bind != "" && bind != "0.0.0.0" && bind != "::" {
+		hosts = append(hosts, bind)
+	}
+	return hosts
+}
+
+// redirectHandler перенаправляет запросы HTTP на тот же путь по HTTPS
+func redirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// 308 сохраняет метод и тело запроса, в отличие от 301
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
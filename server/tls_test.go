@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"restapi/config"
+	"slices"
+	"testing"
+	"time"
+)
+
+func init() {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+}
+
+// devCert создает сертификат для разработки во временном каталоге
+func devCert(t *testing.T, hosts ...string) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "dev-cert.pem"), filepath.Join(dir, "dev-key.pem")
+	if err := ensureDevCert(certFile, keyFile, hosts); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func loadLeaf(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair.Leaf
+}
+
+func TestEnsureDevCert(t *testing.T) {
+	certFile, keyFile := devCert(t, devCertHosts("library.local")...)
+	leaf := loadLeaf(t, certFile, keyFile)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "library.local"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool}); err != nil {
+			t.Errorf("certificate is not valid for %s: %v", host, err)
+		}
+	}
+	if err := leaf.VerifyHostname("example.com"); err == nil {
+		t.Error("certificate is valid for a host it was not issued for")
+	}
+	if ttl := time.Until(leaf.NotAfter); ttl < devCertTTL-time.Hour || ttl > devCertTTL {
+		t.Errorf("certificate expires in %v, want about %v", ttl, devCertTTL)
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode %o, want 600", perm)
+	}
+}
+
+// Действующий сертификат не перевыпускается при перезапуске,
+// иначе браузеры и клиенты с закрепленным сертификатом перестанут доверять серверу
+func TestEnsureDevCertKeepsValidCert(t *testing.T) {
+	certFile, keyFile := devCert(t, "localhost")
+	before, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDevCert(certFile, keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("valid certificate was regenerated")
+	}
+}
+
+func TestEnsureDevCertRenewsExpired(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	if err := ensureDevCert(certFile, keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if leaf := loadLeaf(t, certFile, keyFile); !time.Now().Before(leaf.NotAfter) {
+		t.Errorf("expired certificate was kept, NotAfter %v", leaf.NotAfter)
+	}
+}
+
+// Испорченные файлы не перезаписываются молча: это может быть
+// настоящий сертификат, подложенный по ошибке
+func TestEnsureDevCertRejectsBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, []byte("not a certificate"), 0o644)
+	os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err := ensureDevCert(certFile, keyFile, []string{"localhost"}); err == nil {
+		t.Error("broken certificate files were accepted")
+	}
+	if data, _ := os.ReadFile(certFile); string(data) != "not a certificate" {
+		t.Error("broken certificate file was overwritten")
+	}
+}
+
+func TestDevCertHosts(t *testing.T) {
+	defaults := []string{"localhost", "127.0.0.1", "::1"}
+	tests := []struct {
+		bind string
+		want []string
+	}{
+		{bind: "", want: defaults},
+		{bind: "0.0.0.0", want: defaults},
+		{bind: "::", want: defaults},
+		{bind: "192.168.1.10", want: append(slices.Clone(defaults), "192.168.1.10")},
+		{bind: "library.local", want: append(slices.Clone(defaults), "library.local")},
+	}
+	for _, tt := range tests {
+		if got := devCertHosts(tt.bind); !slices.Equal(got, tt.want) {
+			t.Errorf("devCertHosts(%q) = %v, want %v", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   int
+		host   string
+		target string
+		want   string
+	}{
+		{name: "стандартный порт", port: 443, host: "example.com", target: "/api/v1/books?limit=2", want: "https://example.com/api/v1/books?limit=2"},
+		{name: "порт заменяется", port: 8443, host: "example.com:8080", target: "/", want: "https://example.com:8443/"},
+		{name: "ipv6", port: 443, host: "[::1]:8080", target: "/x", want: "https://[::1]/x"},
+		{name: "ipv6 с портом", port: 8443, host: "[::1]:8080", target: "/x", want: "https://[::1]:8443/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectHandler(tt.port).ServeHTTP(w, r)
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+				t.Errorf("got %d %q, want 308 %q", w.Code, w.Header().Get("Location"), tt.want)
+			}
+		})
+	}
+}
+
+// Клиент, доверяющий сертификату для разработки, подключается по HTTP/2;
+// с tls.client_ca require сервер не принимает клиентов без сертификата
+func TestDevCertServesTLS(t *testing.T) {
+	certFile, keyFile := devCert(t, devCertHosts("")...)
+	caFile, issue := newCA(t)
+
+	tests := []struct {
+		name       string
+		cfg        config.TLS
+		clientCert bool
+		wantErr    bool
+	}{
+		{name: "без проверки клиента", cfg: config.TLS{}},
+		{name: "клиентский сертификат необязателен", cfg: config.TLS{ClientCA: caFile, ClientAuth: config.ClientAuthOptional}},
+		{name: "клиентский сертификат обязателен", cfg: config.TLS{ClientCA: caFile, ClientAuth: config.ClientAuthRequire}, wantErr: true},
+		{name: "клиент с сертификатом", cfg: config.TLS{ClientCA: caFile, ClientAuth: config.ClientAuthRequire}, clientCert: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := tlsConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.Certificates = []tls.Certificate{pair}
+			tc.NextProtos = []string{"h2", "http/1.1"}
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, r.Proto)
+			}))
+			srv.EnableHTTP2 = true
+			srv.TLS = tc
+			srv.StartTLS()
+			defer srv.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(pair.Leaf)
+			client := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.clientCert {
+				client.Certificates = []tls.Certificate{issue()}
+			}
+			tr := &http.Transport{TLSClientConfig: client, ForceAttemptHTTP2: true}
+			defer tr.CloseIdleConnections()
+
+			resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request without a client certificate succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "HTTP/2.0" {
+				t.Errorf("protocol %s, want HTTP/2.0", body)
+			}
+			if resp.TLS.Version < tls.VersionTLS12 {
+				t.Errorf("TLS version %x below 1.2", resp.TLS.Version)
+			}
+		})
+	}
+}
+
+// newCA создает УЦ клиентских сертификатов и возвращает путь к его
+// сертификату и функцию, выписывающую клиентский сертификат
+func newCA(t *testing.T) (string, func() tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clients"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return caFile, func() tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "client"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+}
+
+func TestTLSConfigRejectsBadCA(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "ca.pem")
+	os.WriteFile(bad, []byte("no certificates here"), 0o644)
+	for _, ca := range []string{bad, filepath.Join(dir, "missing.pem")} {
+		if _, err := tlsConfig(config.TLS{ClientCA: ca}); err == nil {
+			t.Errorf("tlsConfig accepted client CA %s", ca)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name имя инструментария в спанах сервера
+const Name = "restapi"
+
+// Экспортеры спанов
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Exporters допустимые экспортеры
+var Exporters = []string{ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile}
+
+// Options настройки трассировки
+type Options struct {
+	Exporter string
+	// Endpoint адрес приемника OTLP/HTTP, например http://localhost:4318.
+	// Пустой адрес берется из OTEL_EXPORTER_OTLP_ENDPOINT.
+	Endpoint string
+	// File файл, в который экспортер file дописывает спаны построчно в JSON
+	File string
+	// SampleRatio доля трасс, которые записываются, если вызывающий
+	// не решил этого сам в заголовке traceparent
+	SampleRatio    float64
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Setup настраивает глобальные провайдер спанов и распространение контекста
+// W3C Trace Context. Без экспортера заголовки traceparent все равно
+// передаются дальше, но спаны не записываются. Возвращенная функция
+// отправляет накопленные спаны и закрывает экспортер.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if opts.Exporter == ExporterNone || opts.Exporter == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		file     io.Closer
+		err      error
+	)
+	switch opts.Exporter {
+	case ExporterOTLP:
+		var o []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			o = append(o, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, o...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		f, ferr := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if ferr != nil {
+			return nil, ferr
+		}
+		file = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", opts.ServiceName),
+			attribute.String("service.version", opts.ServiceVersion),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// Start открывает дочерний спан name в контексте ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End закрывает спан, отмечая его ошибкой err, если она есть
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// restoreProvider возвращает глобальный провайдер после теста
+func restoreProvider(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "без экспортера", opts: Options{}},
+		{name: "none", opts: Options{Exporter: ExporterNone}},
+		{name: "неизвестный экспортер", opts: Options{Exporter: "jaeger"}, wantErr: true},
+		{name: "файл в несуществующем каталоге", opts: Options{Exporter: ExporterFile, File: "/nonexistent/dir/spans.json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreProvider(t)
+			shutdown, err := Setup(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := shutdown(context.Background()); err != nil {
+					t.Errorf("shutdown: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// Экспортер file дописывает спаны в файл, в том числе после перезапуска,
+// и помечает их именем и версией сервиса
+func TestSetupFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	for _, name := range []string{"first", "second"} {
+		restoreProvider(t)
+		shutdown, err := Setup(context.Background(), Options{
+			Exporter: ExporterFile, File: file, SampleRatio: 1,
+			ServiceName: "library-api", ServiceVersion: "1.2.3",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, span := Start(context.Background(), name)
+		End(span, nil)
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	for dec.More() {
+		var span struct {
+			Name     string
+			Resource []struct {
+				Key   string
+				Value struct{ Value any }
+			}
+		}
+		if err := dec.Decode(&span); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, span.Name)
+		attrs := map[string]any{}
+		for _, kv := range span.Resource {
+			attrs[kv.Key] = kv.Value.Value
+		}
+		if attrs["service.name"] != "library-api" || attrs["service.version"] != "1.2.3" {
+			t.Errorf("span %s resource = %v", span.Name, attrs)
+		}
+	}
+	if strings.Join(names, ",") != "first,second" {
+		t.Errorf("exported spans %v, want [first second]", names)
+	}
+}
+
+// Доля 0 отключает запись трасс, начатых сервером, но трасса, которую
+// вызывающий уже записывает, продолжается
+func TestSetupSampleRatio(t *testing.T) {
+	restoreProvider(t)
+	shutdown, err := Setup(context.Background(), Options{
+		Exporter: ExporterFile, File: filepath.Join(t.TempDir(), "spans.json"), SampleRatio: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	ctx, root := Start(context.Background(), "root")
+	defer root.End()
+	if root.SpanContext().IsSampled() {
+		t.Error("root span is sampled with ratio 0")
+	}
+	_, child := Start(ctx, "child")
+	defer child.End()
+	if child.SpanContext().IsSampled() {
+		t.Error("child of an unsampled span is sampled")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus codes.Code
+		wantEvents int
+	}{
+		{name: "без ошибки", wantStatus: codes.Unset},
+		{name: "с ошибкой", err: errors.New("disk full"), wantStatus: codes.Error, wantEvents: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreProvider(t)
+			rec := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+			ctx, parent := Start(context.Background(), "parent")
+			_, span := Start(ctx, "storage.save books")
+			End(span, tt.err)
+			parent.End()
+
+			spans := rec.Ended()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			got := spans[0]
+			if got.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Error("span is not a child of the span in ctx")
+			}
+			if got.Status().Code != tt.wantStatus || len(got.Events()) != tt.wantEvents {
+				t.Errorf("status %v with %d events, want %v with %d", got.Status(), len(got.Events()), tt.wantStatus, tt.wantEvents)
+			}
+			if tt.err != nil && got.Status().Description != tt.err.Error() {
+				t.Errorf("status description %q, want %q", got.Status().Description, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ETag строит тег сущности из версии записи
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// opaqueTag убирает у тега признак слабого сравнения W/
+func opaqueTag(tag string) string {
+	return strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+}
+
+// NotModified сообщает, что у клиента уже есть текущее представление записи:
+// один из тегов If-None-Match совпал с etag при слабом сравнении, а без
+// If-None-Match запись не менялась после If-Modified-Since
+func NotModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			if t := strings.TrimSpace(tag); t == "*" || opaqueTag(t) == opaqueTag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified передается с точностью до секунды
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// IfMatch возвращает версию записи, которую клиент собирается изменить,
+// из заголовка If-Match. Для If-Match: * возвращается 0, любая версия.
+// Без заголовка ответ 428, с тегом, который сервер не выдавал, 412.
+// Слабый тег принимается: его делает из нашего сжатие ответа, версия та же.
+func IfMatch(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case header == "":
+		return 0, NewProblem(http.StatusPreconditionRequired, CodePreconditionRequired,
+			"If-Match header with the ETag of the record is required")
+	case header == "*":
+		return 0, nil
+	case strings.Contains(header, ","):
+		return 0, NewProblem(http.StatusBadRequest, CodeInvalidPrecondition,
+			"If-Match must hold a single entity tag or *")
+	}
+	tag, ok := strings.CutPrefix(opaqueTag(header), `"`)
+	if ok {
+		tag, ok = strings.CutSuffix(tag, `"`)
+	}
+	version, err := strconv.Atoi(tag)
+	if !ok || err != nil || version < 1 {
+		return 0, NewProblem(http.StatusPreconditionFailed, CodeVersionMismatch,
+			"If-Match does not match the current version of the record")
+	}
+	return version, nil
+}
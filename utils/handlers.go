@@ -1,29 +1,19 @@
 package utils
 
 import (
-	"fmt"
 	"net/http"
-
-	"github.com/gorilla/mux"
 )
 
 var (
-	ErrNotFoundApi       = http.HandlerFunc(ErrNotFoundApiFunc)
-	ErrNotFoundApiCustom = http.HandlerFunc(ErrNotFoundApiCustomFunc)
-	ErrUpdatingStorage   = http.HandlerFunc(ErrUpdatingStorageApiFunc)
+	ErrNotFoundApi      = http.HandlerFunc(ErrNotFoundApiFunc)
+	ErrMethodNotAllowed = http.HandlerFunc(ErrMethodNotAllowedApiFunc)
 )
 
 func ErrNotFoundApiFunc(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintf(w, "%s\nNot Found", mux.Vars(r)["version"])
-}
-
-func ErrNotFoundApiCustomFunc(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintf(w, "Sory, but %s was'nt found", mux.Vars(r)["version"])
+	WriteProblem(w, r, NewProblem(http.StatusNotFound, CodeRouteNotFound, "Not Found"))
 }
 
-func ErrUpdatingStorageApiFunc(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprintf(w, "Got error while updating storage")
+func ErrMethodNotAllowedApiFunc(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+		r.Method+" is not allowed for "+r.URL.Path))
 }
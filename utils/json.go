@@ -1,10 +1,28 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"restapi/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func MarshalThis(input ...any) []byte {
+// MarshalThis сериализует значения в JSON массив. Сериализация целых
+// коллекций заметна по времени, поэтому попадает в трассировку запроса ctx.
+func MarshalThis(ctx context.Context, input ...any) []byte {
+	_, span := tracing.Start(ctx, "json.marshal")
+	data, err := json.Marshal(input)
+	span.SetAttributes(attribute.Int("json.bytes", len(data)))
+	tracing.End(span, err)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// MarshalValue сериализует одно значение без обертки в массив, которую добавляет MarshalThis
+func MarshalValue(input any) []byte {
 	if data, err := json.Marshal(input); err != nil {
 		return nil
 	} else {
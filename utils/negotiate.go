@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentJSON = "application/json"
+	ContentText = "text/plain"
+	ContentForm = "application/x-www-form-urlencoded"
+)
+
+// ErrUnsupportedBody возвращается, если тело запроса нельзя разобрать
+var ErrUnsupportedBody = errors.New("unsupported request body")
+
+// MaxBodySize наибольший размер тела запроса: поля книг, пользователей
+// и ключей занимают сотни байт
+const MaxBodySize = 1 << 20
+
+// ParseBody возвращает поля тела запроса независимо от кодировки.
+// Поддерживаются application/json (плоский объект) и формы, как в v1.
+// Тело больше MaxBodySize дает ошибку *Problem со статусом 413.
+func ParseBody(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != ContentJSON {
+		// ParseMultipartForm скрывает ошибку разбора обычной формы за
+		// ErrNotMultipart, поэтому форма разбирается отдельно
+		if err := r.ParseForm(); err != nil {
+			return nil, bodyError(err)
+		}
+		if err := r.ParseMultipartForm(MaxBodySize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, bodyError(err)
+		}
+		return r.Form, nil
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, bodyError(fmt.Errorf("%w: %w", ErrUnsupportedBody, err))
+	}
+
+	// Параметры строки запроса тоже учитываются, как у форм
+	values := r.URL.Query()
+	for key, v := range body {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			values.Set(key, v)
+		case json.Number:
+			values.Set(key, v.String())
+		case bool:
+			values.Set(key, strconv.FormatBool(v))
+		default:
+			return nil, fmt.Errorf("%w: field %q must be a string, number or boolean", ErrUnsupportedBody, key)
+		}
+	}
+	return values, nil
+}
+
+// bodyError заменяет превышение MaxBodySize ответом 413
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return NewProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit))
+	}
+	return err
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// Negotiate выбирает из offers тип ответа, который лучше всего подходит
+// под заголовок Accept. Без заголовка выбирается первый вариант,
+// пустая строка означает, что клиент не принимает ни один из них.
+func Negotiate(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" || len(offers) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	// Более конкретные диапазоны важнее: text/plain > text/* > */*
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		for _, ar := range ranges {
+			if !matches(ar.mediaType, offer) {
+				continue
+			}
+			if ar.q > bestQ {
+				best, bestQ = offer, ar.q
+			}
+			break
+		}
+	}
+	return best
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+func matches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// WriteJSON отправляет уже сериализованный JSON с корректным Content-Type.
+// Если клиент не принимает JSON, отвечает 406 Not Acceptable.
+func WriteJSON(w http.ResponseWriter, r *http.Request, status int, data []byte) {
+	if Negotiate(r, ContentJSON) == "" {
+		WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, CodeNotAcceptable,
+			"Only application/json is available"))
+		return
+	}
+	w.Header().Set("Content-Type", ContentJSON)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// WriteMessage отправляет короткое сообщение об успехе обычным текстом
+// или в виде {"message": "..."}, в зависимости от заголовка Accept
+func WriteMessage(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	switch Negotiate(r, ContentText, ContentJSON) {
+	case ContentJSON:
+		data, _ := json.Marshal(map[string]string{"message": msg})
+		w.Header().Set("Content-Type", ContentJSON)
+		w.WriteHeader(status)
+		w.Write(data)
+	case ContentText:
+		w.Header().Set("Content-Type", ContentText+"; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+	default:
+		WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, CodeNotAcceptable,
+			"Only text/plain and application/json are available"))
+	}
+}
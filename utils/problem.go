@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"restapi/logging"
+)
+
+// ContentProblem тип содержимого ошибок по RFC 7807
+const ContentProblem = "application/problem+json"
+
+// problemTypeBase префикс URI типа ошибки, к нему добавляется код
+const problemTypeBase = "https://www.libraryapi.com/problems/"
+
+// Стабильные коды ошибок: клиенты ветвятся по ним, а не по тексту
+const (
+	CodeRouteNotFound        = "route_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeInvalidBody          = "invalid_body"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeInvalidId            = "invalid_id"
+	CodeInvalidReference     = "invalid_reference"
+	CodeInvalidQuery         = "invalid_query"
+	CodeBookNotFound         = "book_not_found"
+	CodeUserNotFound         = "user_not_found"
+	CodePurchaseNotFound     = "purchase_not_found"
+	CodeReferenced           = "referenced"
+	CodeStorage              = "storage_error"
+	CodeInvalidAPIKey        = "invalid_api_key"
+	CodeExpiredAPIKey        = "expired_api_key"
+	CodeRevokedAPIKey        = "revoked_api_key"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeInsufficientRole     = "insufficient_role"
+	CodeNotOwner             = "not_owner"
+	CodeInvalidRole          = "invalid_role"
+	CodeInvalidScope         = "invalid_scope"
+	CodeKeyNotFound          = "key_not_found"
+	CodeInvalidToken         = "invalid_token"
+	CodeRevokedToken         = "revoked_token"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeLoginTaken           = "login_taken"
+	CodeRateLimited          = "rate_limited"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeInternal             = "internal_error"
+	CodeReadOnly             = "read_only"
+	CodeVersionMismatch      = "version_mismatch"
+	CodePreconditionRequired = "precondition_required"
+	CodeInvalidPrecondition  = "invalid_precondition"
+)
+
+// Problem описание ошибки в формате application/problem+json (RFC 7807)
+// @Description Машиночитаемое описание ошибки
+type Problem struct {
+	Type     string `json:"type" example:"https://www.libraryapi.com/problems/book_not_found"`
+	Title    string `json:"title" example:"Not Found"`
+	Status   int    `json:"status" example:"404"`
+	Detail   string `json:"detail,omitempty" example:"book not found"`
+	Code     string `json:"code" example:"book_not_found"`
+	Instance string `json:"instance,omitempty" example:"/api/v2/books/42"`
+	// RequestId совпадает с заголовком X-Request-ID и записями журнала о запросе
+	RequestId string `json:"request_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+}
+
+// NewProblem создает ошибку со статусом status и стабильным кодом code
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%d %s: %s", p.Status, p.Code, p.Detail)
+}
+
+// WriteProblem отправляет ошибку клиенту. Instance заполняется путем запроса,
+// RequestId идентификатором запроса.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	res := *p
+	if res.Instance == "" && r != nil {
+		res.Instance = r.URL.Path
+	}
+	if res.RequestId == "" && r != nil {
+		res.RequestId = logging.RequestID(r.Context())
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, p.Detail, p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", ContentProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(data)
+}
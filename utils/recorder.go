@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ResponseRecorder запоминает статус и размер ответа для журнала и метрик
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status      int
+	Size        int
+	wroteHeader bool
+}
+
+// NewResponseRecorder оборачивает w, статус по умолчанию 200
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.Size += n
+	return n, err
+}
+
+// Written сообщает, начата ли уже отправка ответа
+func (r *ResponseRecorder) Written() bool {
+	return r.wroteHeader
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RouteTemplate возвращает шаблон пути маршрута router, к которому относится
+// запрос, например /api/v2/books/{id}. Для запросов, не подошедших ни к
+// одному маршруту, возвращает false.
+func RouteTemplate(router *mux.Router, r *http.Request) (string, bool) {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+		return "", false
+	}
+	tpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	return tpl, true
+}
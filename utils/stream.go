@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"restapi/logging"
+	"restapi/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// streamBufferSize сколько ответа копится перед отправкой клиенту. Ошибка,
+// случившаяся до первой отправки, еще может стать ответом 500.
+const streamBufferSize = 32 << 10
+
+// JSONStream пишет JSON документ в w по частям, не собирая его в памяти
+// целиком. Первая ошибка записи или сериализации запоминается, следующие
+// вызовы ничего не делают, а Close ее возвращает.
+type JSONStream struct {
+	w     *bufio.Writer
+	span  trace.Span
+	bytes int
+	items int
+	err   error
+}
+
+// NewJSONStream начинает документ, запись попадает в трассировку запроса ctx
+func NewJSONStream(ctx context.Context, w io.Writer) *JSONStream {
+	_, span := tracing.Start(ctx, "json.stream")
+	return &JSONStream{w: bufio.NewWriterSize(w, streamBufferSize), span: span}
+}
+
+// Raw пишет готовый фрагмент JSON: скобки, запятые, ключи
+func (s *JSONStream) Raw(fragment string) {
+	if s.err != nil {
+		return
+	}
+	n, err := s.w.WriteString(fragment)
+	s.bytes += n
+	s.err = err
+}
+
+// Value сериализует значение v
+func (s *JSONStream) Value(v any) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	n, err := s.w.Write(data)
+	s.bytes += n
+	s.err = err
+}
+
+// Elem пишет i-й элемент массива, перед всеми, кроме первого, ставится запятая
+func (s *JSONStream) Elem(i int, v any) {
+	if i > 0 {
+		s.Raw(",")
+	}
+	s.Value(v)
+	s.items++
+}
+
+// Fail прерывает документ ошибкой err, например ошибкой чтения из базы
+func (s *JSONStream) Fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Err возвращает первую ошибку записи, после нее дальнейшая запись пропускается
+func (s *JSONStream) Err() error {
+	return s.err
+}
+
+// Close отправляет остаток буфера и возвращает первую ошибку
+func (s *JSONStream) Close() error {
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	s.span.SetAttributes(attribute.Int("json.bytes", s.bytes), attribute.Int("json.items", s.items))
+	tracing.End(s.span, s.err)
+	return s.err
+}
+
+// StreamJSON как WriteJSON, но тело ответа пишет write прямо в w. Если write
+// вернул ошибку до отправки первых байт, StreamJSON возвращает ее, и
+// вызывающий сам отвечает ошибкой. После начала ответа соединение
+// обрывается, чтобы клиент не принял обрезанный JSON за целый.
+func StreamJSON(w http.ResponseWriter, r *http.Request, status int, write func(io.Writer) error) error {
+	if Negotiate(r, ContentJSON) == "" {
+		WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, CodeNotAcceptable,
+			"Only application/json is available"))
+		return nil
+	}
+	body := &lazyBody{w: w, status: status}
+	if err := write(body); err != nil {
+		if !body.started {
+			return err
+		}
+		logging.FromContext(r.Context()).Warn("response stream aborted", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	body.start()
+	return nil
+}
+
+// lazyBody отправляет статус и заголовки только с первыми байтами тела
+type lazyBody struct {
+	w       http.ResponseWriter
+	status  int
+	started bool
+}
+
+func (b *lazyBody) start() {
+	if !b.started {
+		b.started = true
+		b.w.Header().Set("Content-Type", ContentJSON)
+		b.w.WriteHeader(b.status)
+	}
+}
+
+func (b *lazyBody) Write(p []byte) (int, error) {
+	b.start()
+	return b.w.Write(p)
+}